@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+// backupOptions are the flags of the backup command
+type backupOptions struct {
+	// to is the folder copies are made under, keeping their path relative to the root of the source
+	to           string
+	targetSource string
+	dryRun       bool
+	filter       *walkFilter
+	format       string
+}
+
+// Statuses of backupRecord
+const (
+	backupPlanned = "planned"
+	backupCopied  = "copied"
+	backupPresent = "present"
+	backupFailed  = "failed"
+)
+
+// backupRecord is a file backup copied, or would copy with -dry-run
+type backupRecord struct {
+	Path        string `json:"path"`
+	Destination string `json:"destination"`
+	Size        int64  `json:"size"`
+	// Status is planned for a dry run, copied, present when the copy was already on the target, or failed
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+type backupSummary struct {
+	DryRun        bool   `json:"dry_run"`
+	TargetSource  string `json:"target_source"`
+	NumFiles      int    `json:"num_files"`
+	NumCopied     int    `json:"num_copied"`
+	NumPresent    int    `json:"num_present"`
+	NumFailed     int    `json:"num_failed"`
+	SizeTotal     int64  `json:"size_total"`
+	NumNotIndexed int    `json:"num_not_indexed"`
+	NumErrors     int    `json:"num_errors"`
+}
+
+// backupFile is an indexed file without a copy in another source, and where it will be copied to
+type backupFile struct {
+	ff  *inventory.FoundFile
+	dst string
+}
+
+// backupPath copies the files under path that have no copy in another source to opts.to, verifies
+// the copies and indexes them under opts.targetSource. A dry run only prints what would be copied.
+func backupPath(ctx context.Context, store inventory.Store, source string, path string, opts backupOptions) error {
+	src, err := getSource(ctx, store, source)
+	if err != nil {
+		return err
+	}
+	root := src.Root
+	if root == "" {
+		root = string(filepath.Separator)
+	}
+
+	var plan []backupFile
+	var sizeTotal int64
+	nNotIndexed := 0
+	errs := newErrorLog(nil, nil)
+	err = walkFiles(ctx, path, source, opts.filter, func(ff inventory.FoundFile, err error) error {
+		if err != nil {
+			errs.add(ctx, source, ff.Path, "walk", err)
+			return nil
+		}
+		previousFF, err := store.GetFoundFileWithSizeAndModified(ctx, source, ff.Path, ff.Size, ff.Modified)
+		if err != nil {
+			return err
+		}
+		if previousFF == nil {
+			nNotIndexed++
+			return nil
+		}
+		otherFFs, err := store.GetFoundFileOtherSourcesWithSameContent(ctx, previousFF)
+		if err != nil || len(otherFFs) > 0 {
+			return err
+		}
+		rel, err := filepath.Rel(root, ff.Path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			errs.add(ctx, source, ff.Path, "backup", fmt.Errorf("outside the root %s of source %s", root, source))
+			return nil
+		}
+		plan = append(plan, backupFile{ff: previousFF, dst: filepath.Join(opts.to, rel)})
+		sizeTotal += ff.Size
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	res := newResultWriter(opts.format, "files", backupRecord{})
+	if !res.text() {
+		return writeBackup(ctx, store, res, plan, nNotIndexed, errs, opts)
+	}
+
+	unit, unitName := bestUnit(sizeTotal)
+	if opts.dryRun {
+		for _, bf := range plan {
+			fmt.Printf("%s -> %s\n", bf.ff.Path, bf.dst)
+		}
+		fmt.Printf("\nWould copy %d files without other sources to %s, size %.f %s\n", len(plan), opts.to, float32(sizeTotal)/unit, unitName)
+	} else {
+		nCopied, nPresent := 0, 0
+		var failed []string
+		for _, bf := range plan {
+			if err := ctx.Err(); err != nil {
+				fmt.Printf("\nInterrupted after copying %d of %d files\n", nCopied, len(plan))
+				return err
+			}
+			copied, err := backupFileTo(ctx, store, bf, opts.targetSource)
+			switch {
+			case err != nil:
+				failed = append(failed, fmt.Sprintf("%s: %s", bf.ff.Path, err))
+			case copied:
+				fmt.Println("Copied", bf.dst)
+				nCopied++
+			default:
+				fmt.Println("Already on target", bf.dst)
+				nPresent++
+			}
+		}
+		fmt.Printf("\nCopied %d of %d files without other sources to %s, size %.f %s\n", nCopied, len(plan), opts.to, float32(sizeTotal)/unit, unitName)
+		if nPresent > 0 {
+			fmt.Printf("%d files were already on the target and have been indexed\n", nPresent)
+		}
+		if len(failed) > 0 {
+			fmt.Printf("\nCould not back up %d files:\n", len(failed))
+			for _, f := range failed {
+				fmt.Println(f)
+			}
+		}
+	}
+	if nNotIndexed > 0 {
+		fmt.Println(nNotIndexed, "files are not indexed or have changed since, run index to back them up")
+	}
+	errs.printSummary(os.Stdout)
+	return nil
+}
+
+// writeBackup is backupPath for the machine readable formats, writing a record for each file
+func writeBackup(ctx context.Context, store inventory.Store, res *resultWriter, plan []backupFile, nNotIndexed int, errs *errorLog, opts backupOptions) error {
+	summary := backupSummary{DryRun: opts.dryRun, TargetSource: opts.targetSource, NumFiles: len(plan), NumNotIndexed: nNotIndexed}
+	for _, bf := range plan {
+		summary.SizeTotal += bf.ff.Size
+		rec := backupRecord{Path: bf.ff.Path, Destination: bf.dst, Size: bf.ff.Size, Status: backupPlanned}
+		if !opts.dryRun {
+			if err := ctx.Err(); err != nil {
+				res.close(summary)
+				return err
+			}
+			copied, err := backupFileTo(ctx, store, bf, opts.targetSource)
+			switch {
+			case err != nil:
+				rec.Status, rec.Error = backupFailed, err.Error()
+				summary.NumFailed++
+			case copied:
+				rec.Status = backupCopied
+				summary.NumCopied++
+			default:
+				rec.Status = backupPresent
+				summary.NumPresent++
+			}
+		}
+		if err := res.write(rec); err != nil {
+			return err
+		}
+	}
+	errs.printSummary(res.log())
+	summary.NumErrors = errs.count()
+	return res.close(summary)
+}
+
+// backupFileTo copies bf to its destination and indexes the copy under target. A file already at the
+// destination with the same content is indexed without copying it again, copied reports which happened.
+func backupFileTo(ctx context.Context, store inventory.Store, bf backupFile, target string) (copied bool, err error) {
+	hashes, err := store.GetFoundFileHashes(ctx, bf.ff.Source, bf.ff.Path, bf.ff.Hash)
+	if err != nil {
+		return false, err
+	}
+	hashes[bf.ff.HashAlgorithm] = bf.ff.Hash
+	var hs []Hasher
+	for algorithm := range hashes {
+		if h, ok := hashers[algorithm]; ok {
+			hs = append(hs, h)
+		}
+	}
+	if len(hs) == 0 {
+		return false, fmt.Errorf("unknown hash algorithm %q", bf.ff.HashAlgorithm)
+	}
+	sort.Slice(hs, func(i, j int) bool { return hs[i].Algorithm < hs[j].Algorithm })
+
+	if _, err := os.Lstat(bf.dst); err == nil {
+		// Leave a different file already at the destination alone
+		if err := checkHashes(bf.dst, hs, hashes); err != nil {
+			return false, fmt.Errorf("%s already exists: %w", bf.dst, err)
+		}
+	} else if os.IsNotExist(err) {
+		if err := copyFile(bf.ff.Path, bf.dst, bf.ff.Modified, hs, hashes); err != nil {
+			return false, err
+		}
+		copied = true
+	} else {
+		return false, err
+	}
+
+	info, err := os.Lstat(bf.dst)
+	if err != nil {
+		return copied, err
+	}
+	now := time.Now()
+	ff := newFoundFile(target, bf.dst, info)
+	ff.Hash = bf.ff.Hash
+	ff.HashAlgorithm = bf.ff.HashAlgorithm
+	ff.Hashes = hashes
+	ff.Type = bf.ff.Type
+	ff.MIME = bf.ff.MIME
+	ff.Category = bf.ff.Category
+	ff.Subcategory = bf.ff.Subcategory
+	ff.Label = bf.ff.Label
+	ff.Tags = bf.ff.Tags
+	ff.Discovered = now
+	ff.LastChecked = now
+	return copied, store.Save(ctx, &ff)
+}
+
+// errCopyMismatch is returned when a copy doesn't have the content indexed for the original
+var errCopyMismatch = errors.New("content doesn't match the indexed file")
+
+// checkHashes returns errCopyMismatch if the file at path doesn't have every digest in want
+func checkHashes(path string, hs []Hasher, want map[string]string) error {
+	got, _, err := getHashes(path, hs)
+	if err != nil {
+		return err
+	}
+	for _, h := range hs {
+		if got[h.Algorithm] != want[h.Algorithm] {
+			return fmt.Errorf("%w, %s %s != %s", errCopyMismatch, h.Algorithm, got[h.Algorithm], want[h.Algorithm])
+		}
+	}
+	return nil
+}
+
+// copyFile copies src to dst with the modified time, through a temporary file that is only renamed to
+// dst once its content is verified against the digests in want
+func copyFile(src string, dst string, modified time.Time, hs []Hasher, want map[string]string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	done := false
+	defer func() {
+		if !done {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err := io.Copy(tmp, in); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if info, err := in.Stat(); err == nil {
+		os.Chmod(tmp.Name(), info.Mode().Perm())
+	}
+	if err := os.Chtimes(tmp.Name(), modified, modified); err != nil {
+		return err
+	}
+	if err := checkHashes(tmp.Name(), hs, want); err != nil {
+		return fmt.Errorf("verifying the copy: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+	done = true
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+func TestBackupPath(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	laptop := filepath.Join(dir, "laptop")
+	nas := filepath.Join(dir, "nas")
+	to := filepath.Join(dir, "backup")
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeFile(t, filepath.Join(laptop, "a.txt"), "only on the laptop", modified)
+	writeFile(t, filepath.Join(laptop, "photos", "b.jpg"), "also only on the laptop", modified)
+	writeFile(t, filepath.Join(laptop, "c.txt"), "on the nas too", modified)
+	writeFile(t, filepath.Join(nas, "c.txt"), "on the nas too", modified)
+	store := inventory.NewMemoryStore()
+	for _, src := range []inventory.Source{
+		{Name: "laptop", Root: laptop},
+		{Name: "nas", Root: nas},
+		{Name: "backup", Root: to},
+	} {
+		src.Created = time.Now()
+		if err := store.AddSource(ctx, &src); err != nil {
+			t.Fatal(err)
+		}
+	}
+	index(t, store, "laptop", laptop)
+	index(t, store, "nas", nas)
+
+	opts := backupOptions{to: to, targetSource: "backup", dryRun: true, filter: testFilter(laptop)}
+	captureOutput(t, func() error {
+		return backupPath(ctx, store, "laptop", laptop, opts)
+	})
+	if _, err := os.Stat(to); !os.IsNotExist(err) {
+		t.Fatalf("dry run created %s, err == %v", to, err)
+	}
+
+	opts.dryRun = false
+	captureOutput(t, func() error {
+		return backupPath(ctx, store, "laptop", laptop, opts)
+	})
+	for path, want := range map[string]string{"a.txt": "only on the laptop", "photos/b.jpg": "also only on the laptop"} {
+		dst := filepath.Join(to, filepath.FromSlash(path))
+		got, err := ioutil.ReadFile(dst)
+		if err != nil || string(got) != want {
+			t.Errorf("backup copied %q to %s, err == %v, want %q", got, path, err, want)
+			continue
+		}
+		if info, err := os.Stat(dst); err != nil || !info.ModTime().Equal(modified) {
+			t.Errorf("copy of %s has modified time %v, want %v", path, info.ModTime(), modified)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(to, "c.txt")); !os.IsNotExist(err) {
+		t.Errorf("backup copied c.txt, which already has a copy on the nas")
+	}
+
+	ffs, err := store.GetFoundFilesUnderPath(ctx, "backup", to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ffs) != 2 || ffs[0].Hash == "" || ffs[0].Name != "a.txt" {
+		t.Fatalf("indexed %+v under backup, want a.txt and b.jpg with their hashes", ffs)
+	}
+	ff, err := store.GetFoundFile(ctx, "laptop", filepath.Join(laptop, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	others, err := store.GetFoundFileOtherSourcesWithSameContent(ctx, ff)
+	if err != nil || len(others) != 1 || others[0].Source != "backup" {
+		t.Errorf("copies of a.txt in other sources == %+v, %v, want the one on backup", others, err)
+	}
+
+	// Once backed up, the files have a copy and nothing more is copied
+	out := captureOutput(t, func() error {
+		return backupPath(ctx, store, "laptop", laptop, opts)
+	})
+	if want := "Copied 0 of 0 files"; !strings.Contains(out, want) {
+		t.Errorf("second backup printed %q, want %q", out, want)
+	}
+}
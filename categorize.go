@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+// rulesFileName is the name of the file in the home folder listing the categorization rules
+const rulesFileName = ".inventoryrules.json"
+
+// categoryRule sets the category, subcategory, label and tags of the files it matches, i.e.
+//
+//	{"name": "camera", "path": "^Pictures/", "types": ["image"], "taken_after": "2019-01-01", "category": "photos", "tags": ["camera"]}
+//
+// A file matches a rule when it matches every condition that is set, and one of the values of
+// extensions and types.
+type categoryRule struct {
+	Name string `json:"name"`
+	// Path is a regular expression matched against the slash separated path relative to the root of the source
+	Path       string   `json:"path,omitempty"`
+	Extensions []string `json:"extensions,omitempty"`
+	Types      []string `json:"types,omitempty"`
+	MinSize    string   `json:"min_size,omitempty"`
+	MaxSize    string   `json:"max_size,omitempty"`
+	// TakenAfter and TakenBefore are compared with the EXIF date of photos, files without one don't match
+	TakenAfter  string   `json:"taken_after,omitempty"`
+	TakenBefore string   `json:"taken_before,omitempty"`
+	Category    string   `json:"category,omitempty"`
+	Subcategory string   `json:"subcategory,omitempty"`
+	Label       string   `json:"label,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+
+	path        *regexp.Regexp
+	minSize     int64
+	maxSize     int64
+	takenAfter  time.Time
+	takenBefore time.Time
+}
+
+// rulesFile is the format of the rules file, the first rule matching a file applies to it
+type rulesFile struct {
+	Rules []categoryRule `json:"rules"`
+}
+
+// readRulesFile returns the rules in the file at path. A missing file has no rules, unless it was
+// explicitly given.
+func readRulesFile(path string, explicit bool) ([]categoryRule, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var rf rulesFile
+	if err := json.Unmarshal(data, &rf); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	names := map[string]bool{}
+	for i := range rf.Rules {
+		r := &rf.Rules[i]
+		if r.Name == "" {
+			return nil, fmt.Errorf("%s: rule %d has no name", path, i+1)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("%s: rule %s is listed twice", path, r.Name)
+		}
+		names[r.Name] = true
+		if err := r.parse(); err != nil {
+			return nil, fmt.Errorf("%s: rule %s: %w", path, r.Name, err)
+		}
+	}
+	return rf.Rules, nil
+}
+
+// parse checks the conditions of the rule and parses them
+func (r *categoryRule) parse() error {
+	var err error
+	if r.Path != "" {
+		if r.path, err = regexp.Compile(r.Path); err != nil {
+			return fmt.Errorf("invalid path: %w", err)
+		}
+	}
+	for i, ext := range r.Extensions {
+		r.Extensions[i] = strings.TrimPrefix(strings.ToLower(ext), ".")
+	}
+	if r.MinSize != "" {
+		if r.minSize, err = inventory.ParseSize(r.MinSize); err != nil {
+			return err
+		}
+	}
+	if r.MaxSize != "" {
+		if r.maxSize, err = inventory.ParseSize(r.MaxSize); err != nil {
+			return err
+		}
+	}
+	if r.TakenAfter != "" {
+		if r.takenAfter, err = parseDate(r.TakenAfter); err != nil {
+			return err
+		}
+	}
+	if r.TakenBefore != "" {
+		if r.takenBefore, err = parseDate(r.TakenBefore); err != nil {
+			return err
+		}
+	}
+	r.Tags = inventory.MergeTags(nil, r.Tags)
+	if r.Category == "" && r.Subcategory == "" && r.Label == "" && r.Tags == nil {
+		return errors.New("sets no category, subcategory, label or tags")
+	}
+	return nil
+}
+
+// match reports whether the rule applies to ff, whose source has root. taken returns when the file
+// was taken, it is only called for rules with a taken condition.
+func (r *categoryRule) match(ff *inventory.FoundFile, root string, taken func() (time.Time, bool)) bool {
+	if len(r.Extensions) > 0 && !contains(r.Extensions, ff.Extension) {
+		return false
+	}
+	if len(r.Types) > 0 && !contains(r.Types, ff.Type) {
+		return false
+	}
+	if (r.MinSize != "" && ff.Size < r.minSize) || (r.MaxSize != "" && ff.Size > r.maxSize) {
+		return false
+	}
+	if r.path != nil {
+		rel, err := filepath.Rel(root, ff.Path)
+		if err != nil || !r.path.MatchString(filepath.ToSlash(rel)) {
+			return false
+		}
+	}
+	if r.TakenAfter != "" || r.TakenBefore != "" {
+		t, ok := taken()
+		if !ok || (r.TakenAfter != "" && t.Before(r.takenAfter)) || (r.TakenBefore != "" && !t.Before(r.takenBefore)) {
+			return false
+		}
+	}
+	return true
+}
+
+// apply sets the category, subcategory, label and tags of the rule on ff, keeping the ones it doesn't set
+func (r *categoryRule) apply(ff *inventory.FoundFile) {
+	if r.Category != "" {
+		ff.Category = r.Category
+	}
+	if r.Subcategory != "" {
+		ff.Subcategory = r.Subcategory
+	}
+	if r.Label != "" {
+		ff.Label = r.Label
+	}
+	ff.Tags = inventory.MergeTags(ff.Tags, r.Tags)
+}
+
+// needsTakenTime reports whether any of the rules has a taken condition
+func needsTakenTime(rules []categoryRule) bool {
+	for i := range rules {
+		if rules[i].TakenAfter != "" || rules[i].TakenBefore != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// findRule returns the first rule matching ff, whose source has root, or nil if none do. taken
+// returns when the file was taken, it is only called for rules with a taken condition, and its
+// error is returned when the file couldn't be read.
+func findRule(rules []categoryRule, ff *inventory.FoundFile, root string, taken func() (time.Time, error)) (*categoryRule, error) {
+	var readErr error
+	read := false
+	var takenTime time.Time
+	readTaken := func() (time.Time, bool) {
+		if !read {
+			read = true
+			takenTime, readErr = taken()
+		}
+		return takenTime, readErr == nil
+	}
+	for i := range rules {
+		if rules[i].match(ff, root, readTaken) {
+			return &rules[i], nil
+		}
+	}
+	if errors.Is(readErr, errNoTakenTime) {
+		readErr = nil
+	}
+	return nil, readErr
+}
+
+// rulesPath returns the rules file given by the -rules flag, or the one in the home folder
+func rulesPath(path string) (string, bool, error) {
+	if path != "" {
+		return path, true, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", false, err
+	}
+	return filepath.Join(homeDir, rulesFileName), false, nil
+}
+
+// recategorizeRecord is a file whose category, subcategory, label or tags a rule changes
+type recategorizeRecord struct {
+	Source         string   `json:"source"`
+	Path           string   `json:"path"`
+	Rule           string   `json:"rule"`
+	OldCategory    string   `json:"old_category"`
+	Category       string   `json:"category"`
+	OldSubcategory string   `json:"old_subcategory"`
+	Subcategory    string   `json:"subcategory"`
+	OldLabel       string   `json:"old_label"`
+	Label          string   `json:"label"`
+	AddedTags      []string `json:"added_tags"`
+}
+
+type recategorizeSummary struct {
+	DryRun        bool `json:"dry_run"`
+	NumFiles      int  `json:"num_files"`
+	NumChanged    int  `json:"num_changed"`
+	NumUnreadable int  `json:"num_unreadable"`
+}
+
+// recategorize applies the rules to the current indexed files of source, or of every source, that
+// match where if it is set. With dryRun, it only prints what would change.
+func recategorize(ctx context.Context, store inventory.Store, source string, where *inventory.Query, rules []categoryRule, dryRun bool, format string) error {
+	srcs, err := store.GetSources(ctx)
+	if err != nil {
+		return err
+	}
+	roots := map[string]string{}
+	for _, src := range srcs {
+		roots[src.Name] = src.Root
+		if src.Root == "" {
+			roots[src.Name] = string(filepath.Separator)
+		}
+	}
+	ffs, err := store.FindFoundFiles(ctx, inventory.FileQuery{Source: source, Where: where})
+	if err != nil {
+		return err
+	}
+	res := newResultWriter(format, "files", recategorizeRecord{})
+	var changed []*inventory.FoundFile
+	// The tags added to the changed files, files adding the same tags are saved together
+	added := map[string][]*inventory.FoundFile{}
+	nUnreadable := 0
+	// Whether the root of a source with unreadable files is missing, their files are then only
+	// warned about once
+	unmounted := map[string]bool{}
+	for i := range ffs {
+		old := ffs[i]
+		ff := old
+		rule, err := findRule(rules, &ff, roots[ff.Source], func() (time.Time, error) { return readTakenTime(ff.Path) })
+		if err != nil {
+			nUnreadable++
+			if _, ok := unmounted[ff.Source]; !ok {
+				_, serr := os.Stat(roots[ff.Source])
+				unmounted[ff.Source] = serr != nil
+				if serr != nil {
+					fmt.Fprintf(res.log(), "Warning: couldn't read the files of %s, %s isn't mounted\n", ff.Source, roots[ff.Source])
+				}
+			}
+			if !unmounted[ff.Source] {
+				fmt.Fprintf(res.log(), "Warning: couldn't read %s: %v\n", ff.Path, err)
+			}
+		}
+		if rule == nil {
+			continue
+		}
+		rule.apply(&ff)
+		addedTags := newTags(ff.Tags, old.Tags)
+		if ff.Category == old.Category && ff.Subcategory == old.Subcategory && ff.Label == old.Label && addedTags == nil {
+			continue
+		}
+		changed = append(changed, &ffs[i])
+		if addedTags != nil {
+			key := strings.Join(addedTags, ",")
+			added[key] = append(added[key], &ffs[i])
+		}
+		rec := recategorizeRecord{Source: ff.Source, Path: ff.Path, Rule: rule.Name, OldCategory: old.Category, Category: ff.Category,
+			OldSubcategory: old.Subcategory, Subcategory: ff.Subcategory, OldLabel: old.Label, Label: ff.Label, AddedTags: addedTags}
+		ffs[i].Category, ffs[i].Subcategory, ffs[i].Label = ff.Category, ff.Subcategory, ff.Label
+		if res.text() {
+			printRecategorized(rec)
+		} else {
+			if rec.AddedTags == nil {
+				rec.AddedTags = []string{}
+			}
+			if err := res.write(rec); err != nil {
+				return err
+			}
+		}
+	}
+	if !dryRun {
+		if err := store.SaveCategories(ctx, changed); err != nil {
+			return err
+		}
+		for key, ffs := range added {
+			if err := store.AddTags(ctx, ffs, strings.Split(key, ",")); err != nil {
+				return err
+			}
+		}
+	}
+	if !res.text() {
+		return res.close(recategorizeSummary{DryRun: dryRun, NumFiles: len(ffs), NumChanged: len(changed), NumUnreadable: nUnreadable})
+	}
+	if len(changed) > 0 {
+		fmt.Println()
+	}
+	if dryRun {
+		fmt.Printf("Would recategorize %d of %d files, run without -dry-run to save the changes\n", len(changed), len(ffs))
+	} else {
+		fmt.Printf("Recategorized %d of %d files\n", len(changed), len(ffs))
+	}
+	if nUnreadable > 0 {
+		fmt.Printf("%d files couldn't be read to check their EXIF date\n", nUnreadable)
+	}
+	return nil
+}
+
+// printRecategorized prints the changes of rec as a diff, i.e.
+//
+//	laptop:/home/alice/Pictures/a.jpg (camera)
+//	  category: "" -> "photos"
+func printRecategorized(rec recategorizeRecord) {
+	fmt.Printf("%s:%s (%s)\n", rec.Source, rec.Path, rec.Rule)
+	for _, field := range []struct{ name, old, new string }{
+		{"category", rec.OldCategory, rec.Category},
+		{"subcategory", rec.OldSubcategory, rec.Subcategory},
+		{"label", rec.OldLabel, rec.Label},
+	} {
+		if field.old != field.new {
+			fmt.Printf("  %s: %q -> %q\n", field.name, field.old, field.new)
+		}
+	}
+	if rec.AddedTags != nil {
+		fmt.Printf("  tags: +%s\n", strings.Join(rec.AddedTags, ", +"))
+	}
+}
+
+// newTags returns the tags in tags that aren't in old
+func newTags(tags []string, old []string) []string {
+	var added []string
+	for _, t := range tags {
+		if !contains(old, t) {
+			added = append(added, t)
+		}
+	}
+	return added
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+// testJPEG returns a JPEG with just an EXIF DateTimeOriginal of taken
+func testJPEG(taken string) string {
+	var tiff bytes.Buffer
+	le := binary.LittleEndian
+	tiff.WriteString("II*\x00")
+	binary.Write(&tiff, le, uint32(8))
+	// IFD0 pointing to the Exif IFD at 26, which points to the date at 44
+	for _, ifd := range [][3]uint32{{exifTagExifIFD, 4, 26}, {exifTagDateTimeOriginal, 2, 44}} {
+		count := uint32(1)
+		if ifd[1] == 2 {
+			count = uint32(len(taken) + 1)
+		}
+		binary.Write(&tiff, le, uint16(1))
+		binary.Write(&tiff, le, uint16(ifd[0]))
+		binary.Write(&tiff, le, uint16(ifd[1]))
+		binary.Write(&tiff, le, count)
+		binary.Write(&tiff, le, ifd[2])
+		binary.Write(&tiff, le, uint32(0))
+	}
+	tiff.WriteString(taken + "\x00")
+
+	var jpeg bytes.Buffer
+	jpeg.Write([]byte{0xff, 0xd8, 0xff, 0xe0, 0, 4, 0, 0, 0xff, 0xe1})
+	binary.Write(&jpeg, binary.BigEndian, uint16(2+6+tiff.Len()))
+	jpeg.WriteString("Exif\x00\x00")
+	jpeg.Write(tiff.Bytes())
+	jpeg.Write([]byte{0xff, 0xda})
+	return jpeg.String()
+}
+
+func TestReadTakenTime(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.jpg"), testJPEG("2019:07:14 10:30:00"), time.Now())
+	writeFile(t, filepath.Join(dir, "b.jpg"), "\xff\xd8\xff\xda", time.Now())
+	writeFile(t, filepath.Join(dir, "c.txt"), "text", time.Now())
+
+	taken, err := readTakenTime(filepath.Join(dir, "a.jpg"))
+	if want := time.Date(2019, 7, 14, 10, 30, 0, 0, time.Local); err != nil || !taken.Equal(want) {
+		t.Errorf("readTakenTime(a.jpg) == %v, %v, want %v", taken, err, want)
+	}
+	for _, name := range []string{"b.jpg", "c.txt"} {
+		if _, err := readTakenTime(filepath.Join(dir, name)); !errors.Is(err, errNoTakenTime) {
+			t.Errorf("readTakenTime(%s) error == %v, want %v", name, err, errNoTakenTime)
+		}
+	}
+}
+
+func TestReadRulesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), rulesFileName)
+	cases := []struct {
+		rules   string
+		wantErr string
+	}{
+		{`{"rules": [{"name": "docs", "extensions": [".PDF"], "max_size": "10MB", "category": "documents"}]}`, ""},
+		{`{"rules": [{"category": "documents"}]}`, "rule 1 has no name"},
+		{`{"rules": [{"name": "a", "label": "x"}, {"name": "a", "label": "y"}]}`, "rule a is listed twice"},
+		{`{"rules": [{"name": "a", "path": "(", "label": "x"}]}`, "invalid path"},
+		{`{"rules": [{"name": "a", "min_size": "big", "label": "x"}]}`, "big"},
+		{`{"rules": [{"name": "a", "taken_after": "July", "label": "x"}]}`, "invalid date"},
+		{`{"rules": [{"name": "a", "types": ["image"]}]}`, "sets no category"},
+	}
+	for _, c := range cases {
+		writeFile(t, path, c.rules, time.Now())
+		rules, err := readRulesFile(path, true)
+		if c.wantErr == "" {
+			if err != nil || len(rules) != 1 || rules[0].Extensions[0] != "pdf" {
+				t.Errorf("readRulesFile(%s) == %+v, %v, want the docs rule", c.rules, rules, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+			t.Errorf("readRulesFile(%s) error == %v, want %q", c.rules, err, c.wantErr)
+		}
+	}
+	if rules, err := readRulesFile(filepath.Join(t.TempDir(), rulesFileName), false); err != nil || rules != nil {
+		t.Errorf("readRulesFile() of a missing file == %v, %v, want no rules", rules, err)
+	}
+}
+
+const testRulesFile = `{"rules": [
+	{"name": "summer", "types": ["image"], "taken_after": "2019-06-01", "taken_before": "2019-09-01", "category": "photos", "subcategory": "summer", "tags": ["vacation"]},
+	{"name": "photos", "path": "^Pictures/", "types": ["image"], "category": "photos"},
+	{"name": "small", "max_size": "10", "label": "small"}
+]}`
+
+func TestRecategorize(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeFile(t, filepath.Join(dir, "Pictures", "a.jpg"), testJPEG("2019:07:14 10:30:00"), modified)
+	writeFile(t, filepath.Join(dir, "Pictures", "b.jpg"), testJPEG("2020:07:14 10:30:00"), modified)
+	writeFile(t, filepath.Join(dir, "Downloads", "c.jpg"), testJPEG("2018:01:01 00:00:00"), modified)
+	writeFile(t, filepath.Join(dir, "Downloads", "d.txt"), "d", modified)
+	store := inventory.NewMemoryStore()
+	if err := store.AddSource(ctx, &inventory.Source{Name: "laptop", Root: dir, Created: modified}); err != nil {
+		t.Fatal(err)
+	}
+	rulesPath := filepath.Join(t.TempDir(), rulesFileName)
+	writeFile(t, rulesPath, testRulesFile, time.Now())
+	rules, err := readRulesFile(rulesPath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Indexing applies the first matching rule, the flags override it
+	hs, _ := ParseHashers("md5")
+	opts := indexOptions{workers: 2, hashers: hs, batchSize: 2, filter: testFilter(dir), rules: rules[1:], root: dir, label: "laptop"}
+	captureOutput(t, func() error {
+		return indexPath(ctx, store, "laptop", dir, opts)
+	})
+	want := map[string]string{"a.jpg": "photos//laptop/", "b.jpg": "photos//laptop/", "c.jpg": "//laptop/", "d.txt": "//laptop/"}
+	checkCategories := func(when string) {
+		t.Helper()
+		ffs, err := store.FindFoundFiles(ctx, inventory.FileQuery{Source: "laptop"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ff := range ffs {
+			got := strings.Join([]string{ff.Category, ff.Subcategory, ff.Label, strings.Join(ff.Tags, ",")}, "/")
+			if got != want[ff.Name] {
+				t.Errorf("%s, %s has category/subcategory/label/tags %s, want %s", when, ff.Name, got, want[ff.Name])
+			}
+		}
+	}
+	checkCategories("after index")
+
+	out := captureOutput(t, func() error {
+		return recategorize(ctx, store, "laptop", nil, rules, true, formatText)
+	})
+	for _, line := range []string{"laptop:" + filepath.Join(dir, "Pictures", "a.jpg") + " (summer)\n  subcategory: \"\" -> \"summer\"\n  tags: +vacation\n",
+		"laptop:" + filepath.Join(dir, "Downloads", "d.txt") + " (small)\n  label: \"laptop\" -> \"small\"\n",
+		"Would recategorize 2 of 4 files"} {
+		if !strings.Contains(out, line) {
+			t.Errorf("recategorize -dry-run output:\n%s\nwant %q", out, line)
+		}
+	}
+	checkCategories("after a dry run")
+
+	where, err := parseWhere("ext:jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = captureOutput(t, func() error {
+		return recategorize(ctx, store, "laptop", where, rules, false, formatJSON)
+	})
+	if !strings.Contains(out, `"num_files": 3,`) || !strings.Contains(out, `"num_changed": 1,`) {
+		t.Errorf("recategorize -where ext:jpg output:\n%s\nwant 1 of 3 files changed", out)
+	}
+	want["a.jpg"] = "photos/summer/laptop/vacation"
+	checkCategories("after recategorize")
+
+	// The files of a source that isn't mounted are warned about once
+	unmounted := dir + "-unmounted"
+	if err := os.Rename(dir, unmounted); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Rename(unmounted, dir)
+	var stdout string
+	stderr := captureStderr(t, func() error {
+		stdout = captureOutput(t, func() error {
+			return recategorize(ctx, store, "laptop", nil, rules, true, formatJSON)
+		})
+		return nil
+	})
+	if strings.Count(stderr, "Warning:") != 1 || !strings.Contains(stderr, "laptop, "+dir+" isn't mounted") {
+		t.Errorf("recategorize of an unmounted source logged:\n%s\nwant one warning for laptop", stderr)
+	}
+	if strings.Contains(stdout, `"num_unreadable": 0`) {
+		t.Errorf("recategorize of an unmounted source output:\n%s\nwant unreadable files", stdout)
+	}
+}
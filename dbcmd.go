@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+// runDBCommand runs the database maintenance subcommands, i.e. fileinventory db version
+func runDBCommand(ctx context.Context, args []string, defaultFormat string) error {
+	if len(args) < 1 {
+		return errors.New("expected 'db version' or 'db migrate'")
+	}
+	dbCmd := flag.NewFlagSet("db "+args[0], flag.ExitOnError)
+	dbPath := dbCmd.String("db", "", "database path - defaults to $HOMEDIR/index.db")
+	format := addFormatFlag(dbCmd, defaultFormat)
+	dbCmd.Parse(args[1:])
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	res := newResultWriter(*format, "migrations", migrationRecord{})
+
+	store, err := inventory.Open(ctx, *dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	switch args[0] {
+	case "version":
+		version, err := store.SchemaVersion(ctx)
+		if err != nil {
+			return err
+		}
+		ms, err := store.AppliedMigrations(ctx)
+		if err != nil {
+			return err
+		}
+		if !res.text() {
+			if err := writeMigrations(res, ms); err != nil {
+				return err
+			}
+			return res.close(dbVersionSummary{Path: store.Path(), Version: version, LatestVersion: inventory.LatestSchemaVersion()})
+		}
+		fmt.Println(store.Path())
+		fmt.Printf("Schema version %d, this version of fileinventory supports %d\n", version, inventory.LatestSchemaVersion())
+		for _, m := range ms {
+			fmt.Printf("%4d    %s    %s\n", m.Version, m.Applied.Format("2006-01-02 15:04"), m.Name)
+		}
+		switch {
+		case version > inventory.LatestSchemaVersion():
+			fmt.Println("\nThe database was migrated by a newer version of fileinventory, please upgrade")
+		case version < inventory.LatestSchemaVersion():
+			fmt.Println("\nThe database will be migrated the next time it is used, or run 'db migrate'")
+		}
+		return nil
+	case "migrate":
+		applied, err := store.Migrate(ctx)
+		for _, m := range applied {
+			fmt.Fprintf(res.log(), "Applied migration %d: %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Fprintln(res.log(), "Database is up to date")
+		}
+		if !res.text() {
+			if err := writeMigrations(res, applied); err != nil {
+				return err
+			}
+			return res.close(nil)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown db command %q, expected 'version' or 'migrate'", args[0])
+	}
+}
+
+// migrationRecord is a migration listed by db version, or applied by db migrate
+type migrationRecord struct {
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+	Applied string `json:"applied"`
+}
+
+type dbVersionSummary struct {
+	Path          string `json:"path"`
+	Version       int    `json:"version"`
+	LatestVersion int    `json:"latest_version"`
+}
+
+func writeMigrations(res *resultWriter, ms []inventory.Migration) error {
+	for _, m := range ms {
+		if err := res.write(migrationRecord{Version: m.Version, Name: m.Name, Applied: formatTime(m.Applied)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+type dupeFileJSON struct {
+	Source   string `json:"source"`
+	Path     string `json:"path"`
+	Modified string `json:"modified"`
+}
+
+type dupeGroupJSON struct {
+	HashAlgorithm string         `json:"hash_algorithm"`
+	Hash          string         `json:"hash"`
+	Size          int64          `json:"size"`
+	Wasted        int64          `json:"wasted"`
+	Files         []dupeFileJSON `json:"files"`
+}
+
+// dupeRecord is a file of a duplicate group, the rows of dupes in csv
+type dupeRecord struct {
+	HashAlgorithm string `json:"hash_algorithm"`
+	Hash          string `json:"hash"`
+	Size          int64  `json:"size"`
+	Wasted        int64  `json:"wasted"`
+	Source        string `json:"source"`
+	Path          string `json:"path"`
+	Modified      string `json:"modified"`
+}
+
+type dupesSummary struct {
+	NumGroups   int   `json:"num_groups"`
+	NumFiles    int   `json:"num_files"`
+	Reclaimable int64 `json:"reclaimable"`
+}
+
+// listDuplicates prints groups of files with the same content, largest wasted space first. Only the
+// files matching the Where and Tags of filter are included, if set.
+func listDuplicates(ctx context.Context, store inventory.Store, source string, minSize int64, fileType string, filter inventory.FileQuery, format string) error {
+	groups, err := store.GetDuplicateGroups(ctx, source, minSize, fileType)
+	if err != nil {
+		return err
+	}
+	if filter.Where != nil || filter.Tags != nil {
+		filter.Source = source
+		if groups, err = filterDuplicates(ctx, store, groups, filter); err != nil {
+			return err
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].Wasted() > groups[j].Wasted()
+	})
+	var reclaimable int64
+	nFiles := 0
+	for i := range groups {
+		reclaimable += groups[i].Wasted()
+		nFiles += len(groups[i].Files)
+	}
+
+	// json and jsonl have a record per group, with its files, csv a row per file
+	res := newResultWriter(format, "groups", dupeRecord{})
+	if !res.text() {
+		for i := range groups {
+			g := &groups[i]
+			gj := dupeGroupJSON{HashAlgorithm: g.HashAlgorithm, Hash: g.Hash, Size: g.Size, Wasted: g.Wasted()}
+			for _, ff := range g.Files {
+				gj.Files = append(gj.Files, dupeFileJSON{Source: ff.Source, Path: ff.Path, Modified: ff.Modified.Format(time.RFC3339)})
+			}
+			if format != formatCSV {
+				if err := res.write(gj); err != nil {
+					return err
+				}
+				continue
+			}
+			for _, f := range gj.Files {
+				err := res.write(dupeRecord{HashAlgorithm: gj.HashAlgorithm, Hash: gj.Hash, Size: gj.Size, Wasted: gj.Wasted, Source: f.Source, Path: f.Path, Modified: f.Modified})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return res.close(dupesSummary{NumGroups: len(groups), NumFiles: nFiles, Reclaimable: reclaimable})
+	}
+
+	if len(groups) == 0 {
+		fmt.Println("No duplicate files found")
+		return nil
+	}
+	for i := range groups {
+		g := &groups[i]
+		unit, unitName := bestUnit(g.Wasted())
+		fmt.Printf("%d copies of %s:%s, %.f %s reclaimable\n", len(g.Files), g.HashAlgorithm, g.Hash, float32(g.Wasted())/unit, unitName)
+		for _, ff := range g.Files {
+			fmt.Printf("%-16s    %s    %s\n", ff.Source, ff.Modified.Format("2006-01-02 15:04"), ff.Path)
+		}
+		fmt.Println()
+	}
+	unit, unitName := bestUnit(reclaimable)
+	fmt.Printf("Found %d files in %d duplicate groups\n", nFiles, len(groups))
+	fmt.Printf("Reclaimable space: %.f %s\n", float32(reclaimable)/unit, unitName)
+	return nil
+}
+
+// filterDuplicates returns the groups with only their files matching q, leaving out groups
+// without at least two of them, like the files left out by the other filters
+func filterDuplicates(ctx context.Context, store inventory.Store, groups []inventory.DuplicateGroup, q inventory.FileQuery) ([]inventory.DuplicateGroup, error) {
+	ffs, err := store.FindFoundFiles(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	matched := map[[2]string]bool{}
+	for i := range ffs {
+		matched[[2]string{ffs[i].Source, ffs[i].Path}] = true
+	}
+	var filtered []inventory.DuplicateGroup
+	for _, g := range groups {
+		var files []inventory.FoundFile
+		for _, ff := range g.Files {
+			if matched[[2]string{ff.Source, ff.Path}] {
+				files = append(files, ff)
+			}
+		}
+		if len(files) > 1 {
+			g.Files = files
+			filtered = append(filtered, g)
+		}
+	}
+	return filtered, nil
+}
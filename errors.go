@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+// errorKind classifies an error reading a file, i.e. inventory.ErrorPermission
+func errorKind(err error) string {
+	switch {
+	case os.IsPermission(err):
+		return inventory.ErrorPermission
+	case os.IsNotExist(err):
+		return inventory.ErrorVanished
+	default:
+		return inventory.ErrorIO
+	}
+}
+
+// errorLog collects the files and folders that couldn't be read so a run can carry on and
+// summarise them at the end. Errors are saved to the store when there is a run to tie them to.
+type errorLog struct {
+	mu     sync.Mutex
+	store  inventory.Store
+	run    *inventory.IndexRun
+	errors []inventory.IndexError
+	// saveErr is the first error saving to the store
+	saveErr error
+}
+
+// newErrorLog returns an errorLog saving errors for run, store and run can be nil to only collect them
+func newErrorLog(store inventory.Store, run *inventory.IndexRun) *errorLog {
+	return &errorLog{store: store, run: run}
+}
+
+// add records that op failed for path, it is safe to call from multiple goroutines
+func (l *errorLog) add(ctx context.Context, source string, path string, op string, err error) {
+	e := inventory.IndexError{
+		Op:       op,
+		Source:   source,
+		Path:     path,
+		Kind:     errorKind(err),
+		Message:  err.Error(),
+		Occurred: time.Now(),
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errors = append(l.errors, e)
+	if l.store == nil || l.run == nil || l.saveErr != nil {
+		return
+	}
+	e.RunID = l.run.ID
+	l.saveErr = l.store.SaveIndexError(ctx, &e)
+}
+
+func (l *errorLog) count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.errors)
+}
+
+// printSummary prints the number of errors of each kind to w, followed by the errors when there are few of them
+func (l *errorLog) printSummary(w io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.errors) == 0 {
+		return
+	}
+	counts := map[string]int{}
+	for _, e := range l.errors {
+		counts[e.Kind]++
+	}
+	var kinds []string
+	for kind, n := range counts {
+		kinds = append(kinds, fmt.Sprintf("%d %s", n, kind))
+	}
+	sort.Strings(kinds)
+	fmt.Fprintf(w, "\n%d files or folders could not be read (%s)\n", len(l.errors), strings.Join(kinds, ", "))
+	const maxListed = 20
+	for i, e := range l.errors {
+		if i == maxListed {
+			fmt.Fprintf(w, "... and %d more\n", len(l.errors)-maxListed)
+			break
+		}
+		fmt.Fprintf(w, "%s: %s\n", e.Path, e.Message)
+	}
+	if l.run != nil {
+		fmt.Fprintln(w, "See ls -errors")
+	}
+}
+
+// indexErrorRecord is a file or folder listed by ls -errors
+type indexErrorRecord struct {
+	Source   string `json:"source"`
+	Path     string `json:"path"`
+	Op       string `json:"op"`
+	Kind     string `json:"kind"`
+	Message  string `json:"message"`
+	Occurred string `json:"occurred"`
+	RunID    int64  `json:"run_id"`
+}
+
+type indexErrorsSummary struct {
+	NumErrors int `json:"num_errors"`
+}
+
+func listIndexErrors(ctx context.Context, store inventory.Store, source string, path string, format string) error {
+	es, err := store.GetIndexErrorsUnderPath(ctx, source, path)
+	if err != nil {
+		return err
+	}
+	res := newResultWriter(format, "errors", indexErrorRecord{})
+	if !res.text() {
+		for _, e := range es {
+			err := res.write(indexErrorRecord{Source: e.Source, Path: e.Path, Op: e.Op, Kind: e.Kind, Message: e.Message, Occurred: formatTime(e.Occurred), RunID: e.RunID})
+			if err != nil {
+				return err
+			}
+		}
+		return res.close(indexErrorsSummary{NumErrors: len(es)})
+	}
+	if len(es) == 0 {
+		fmt.Println("No errors found")
+		return nil
+	}
+	var lastDir string
+	for _, e := range es {
+		dir := filepath.Dir(e.Path)
+		if dir != lastDir {
+			fmt.Printf("\n%s\n", dir)
+			fmt.Print("Occurred            Source              Op             Kind                 Name\n")
+			lastDir = dir
+		}
+		fmt.Printf("%s    %-16s    %-11s    %-17s    %s\n", e.Occurred.Format("2006-01-02 15:04"), e.Source, e.Op, e.Kind, filepath.Base(e.Path))
+		fmt.Printf("    %s\n", e.Message)
+	}
+	fmt.Println("\nFound", len(es), "errors from the latest index runs")
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// errNoTakenTime is returned by readTakenTime for files without an EXIF date
+var errNoTakenTime = errors.New("no EXIF date")
+
+// EXIF tags read by readTakenTime
+const (
+	exifTagDateTime         = 0x0132
+	exifTagExifIFD          = 0x8769
+	exifTagDateTimeOriginal = 0x9003
+)
+
+// readTakenTime returns when the photo at path was taken, from the EXIF DateTimeOriginal of JPEG and
+// TIFF based files, i.e. most camera raw formats, falling back to the EXIF DateTime. EXIF dates
+// have no time zone, they are read as local time.
+func readTakenTime(path string) (time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	var header [4]byte
+	if _, err := io.ReadFull(f, header[:]); err != nil {
+		return time.Time{}, errNoTakenTime
+	}
+	switch {
+	case header[0] == 0xff && header[1] == 0xd8:
+		if _, err := f.Seek(2, io.SeekStart); err != nil {
+			return time.Time{}, err
+		}
+		tiff, err := readJPEGExif(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return readTIFFTakenTime(bytes.NewReader(tiff))
+	case string(header[:]) == "II*\x00" || string(header[:]) == "MM\x00*":
+		return readTIFFTakenTime(f)
+	}
+	return time.Time{}, errNoTakenTime
+}
+
+// readJPEGExif returns the TIFF structure in the Exif APP1 segment of the JPEG f, positioned after
+// its start of image marker
+func readJPEGExif(f io.Reader) ([]byte, error) {
+	for {
+		var marker [4]byte
+		if _, err := io.ReadFull(f, marker[:]); err != nil {
+			return nil, errNoTakenTime
+		}
+		// The image data follows start of scan, EXIF is always before it
+		if marker[0] != 0xff || marker[1] == 0xda || marker[1] == 0xd9 {
+			return nil, errNoTakenTime
+		}
+		n := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if n < 0 {
+			return nil, errNoTakenTime
+		}
+		segment := make([]byte, n)
+		if _, err := io.ReadFull(f, segment); err != nil {
+			return nil, errNoTakenTime
+		}
+		if marker[1] == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:], nil
+		}
+	}
+}
+
+// readTIFFTakenTime returns the date in the IFDs of the TIFF structure r
+func readTIFFTakenTime(r io.ReaderAt) (time.Time, error) {
+	var header [8]byte
+	if _, err := r.ReadAt(header[:], 0); err != nil {
+		return time.Time{}, errNoTakenTime
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if header[0] == 'M' {
+		order = binary.BigEndian
+	}
+	ifd0, err := readIFD(r, order, int64(order.Uint32(header[4:])))
+	if err != nil {
+		return time.Time{}, err
+	}
+	var taken string
+	if e, ok := ifd0[exifTagExifIFD]; ok {
+		exif, err := readIFD(r, order, int64(order.Uint32(e.value[:])))
+		if err != nil {
+			return time.Time{}, err
+		}
+		if e, ok := exif[exifTagDateTimeOriginal]; ok {
+			taken, _ = e.ascii(r, order)
+		}
+	}
+	if e, ok := ifd0[exifTagDateTime]; ok && taken == "" {
+		taken, _ = e.ascii(r, order)
+	}
+	t, err := time.ParseInLocation("2006:01:02 15:04:05", strings.TrimSpace(taken), time.Local)
+	if err != nil {
+		return time.Time{}, errNoTakenTime
+	}
+	return t, nil
+}
+
+// ifdEntry is a field of an IFD, value holds the value itself if it fits, or its offset
+type ifdEntry struct {
+	typ   uint16
+	count uint32
+	value [4]byte
+}
+
+// ascii returns the string value of the entry
+func (e ifdEntry) ascii(r io.ReaderAt, order binary.ByteOrder) (string, error) {
+	const typeASCII = 2
+	if e.typ != typeASCII || e.count > 64 {
+		return "", errNoTakenTime
+	}
+	b := e.value[:]
+	if e.count > 4 {
+		b = make([]byte, e.count)
+		if _, err := r.ReadAt(b, int64(order.Uint32(e.value[:]))); err != nil {
+			return "", errNoTakenTime
+		}
+	}
+	return strings.TrimRight(string(b[:e.count]), "\x00"), nil
+}
+
+// readIFD returns the entries of the IFD at offset, by tag
+func readIFD(r io.ReaderAt, order binary.ByteOrder, offset int64) (map[uint16]ifdEntry, error) {
+	var count [2]byte
+	if _, err := r.ReadAt(count[:], offset); err != nil {
+		return nil, errNoTakenTime
+	}
+	n := int(order.Uint16(count[:]))
+	b := make([]byte, n*12)
+	if _, err := r.ReadAt(b, offset+2); err != nil {
+		return nil, errNoTakenTime
+	}
+	entries := map[uint16]ifdEntry{}
+	for i := 0; i < n; i++ {
+		field := b[i*12 : i*12+12]
+		e := ifdEntry{typ: order.Uint16(field[2:]), count: order.Uint32(field[4:])}
+		copy(e.value[:], field[8:])
+		entries[order.Uint16(field)] = e
+	}
+	return entries, nil
+}
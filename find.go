@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+// findRecord is a file listed by find
+type findRecord struct {
+	Source        string   `json:"source"`
+	Path          string   `json:"path"`
+	Name          string   `json:"name"`
+	Type          string   `json:"type"`
+	MIME          string   `json:"mime"`
+	Size          int64    `json:"size"`
+	Category      string   `json:"category"`
+	Subcategory   string   `json:"subcategory"`
+	Label         string   `json:"label"`
+	Tags          []string `json:"tags"`
+	HashAlgorithm string   `json:"hash_algorithm"`
+	Hash          string   `json:"hash"`
+	Modified      string   `json:"modified"`
+	Discovered    string   `json:"discovered"`
+	// Missing is whether the file is no longer found at its path
+	Missing bool `json:"missing"`
+}
+
+type findSummary struct {
+	NumFiles  int   `json:"num_files"`
+	SizeTotal int64 `json:"size_total"`
+}
+
+// findOptions are the flags of the find command, as given
+type findOptions struct {
+	name             string
+	regex            string
+	tags             string
+	minSize          string
+	maxSize          string
+	modifiedAfter    string
+	modifiedBefore   string
+	discoveredAfter  string
+	discoveredBefore string
+}
+
+// query returns the query of the flags in o added to q
+func (o *findOptions) query(q inventory.FileQuery) (inventory.FileQuery, error) {
+	var err error
+	q.Name = o.name
+	if o.regex != "" {
+		if q.NameRegexp, err = regexp.Compile(o.regex); err != nil {
+			return q, fmt.Errorf("-regex: %w", err)
+		}
+	}
+	q.Tags = inventory.SplitTags(o.tags)
+	for _, s := range []struct {
+		flag  string
+		value string
+		size  *int64
+	}{{"-min-size", o.minSize, &q.MinSize}, {"-max-size", o.maxSize, &q.MaxSize}} {
+		if s.value == "" {
+			continue
+		}
+		if *s.size, err = inventory.ParseSize(s.value); err != nil {
+			return q, fmt.Errorf("%s: %w", s.flag, err)
+		}
+	}
+	for _, d := range []struct {
+		flag  string
+		value string
+		t     *time.Time
+	}{
+		{"-modified-after", o.modifiedAfter, &q.ModifiedAfter}, {"-modified-before", o.modifiedBefore, &q.ModifiedBefore},
+		{"-discovered-after", o.discoveredAfter, &q.DiscoveredAfter}, {"-discovered-before", o.discoveredBefore, &q.DiscoveredBefore},
+	} {
+		if d.value == "" {
+			continue
+		}
+		if *d.t, err = parseDate(d.value); err != nil {
+			return q, fmt.Errorf("%s: %w", d.flag, err)
+		}
+	}
+	return q, nil
+}
+
+// parseDate parses a date, i.e. 2020-01-02, in local time, or a time in RFC 3339
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, fmt.Errorf("invalid date %q, expected i.e. 2020-01-02 or 2020-01-02T15:04:05Z", s)
+	}
+	return t, nil
+}
+
+// findFiles prints the indexed files matching q, from the database only so sources don't need to be mounted
+func findFiles(ctx context.Context, store inventory.Store, q inventory.FileQuery, format string) error {
+	ffs, err := store.FindFoundFiles(ctx, q)
+	if err != nil {
+		return err
+	}
+	res := newResultWriter(format, "files", findRecord{})
+	var sizeTotal int64
+	for i := range ffs {
+		ff := &ffs[i]
+		sizeTotal += ff.Size
+		if res.text() {
+			continue
+		}
+		tags := ff.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		err := res.write(findRecord{Source: ff.Source, Path: ff.Path, Name: ff.Name, Type: ff.Type, MIME: ff.MIME, Size: ff.Size, Category: ff.Category,
+			Subcategory: ff.Subcategory, Label: ff.Label, Tags: tags, HashAlgorithm: ff.HashAlgorithm, Hash: ff.Hash,
+			Modified: formatTime(ff.Modified), Discovered: formatTime(ff.Discovered), Missing: ff.Status == inventory.StatusMissing})
+		if err != nil {
+			return err
+		}
+	}
+	if !res.text() {
+		return res.close(findSummary{NumFiles: len(ffs), SizeTotal: sizeTotal})
+	}
+
+	if len(ffs) == 0 {
+		fmt.Println("No matching files found")
+		return nil
+	}
+	fmt.Print("Source              Modified            Size (KB)    Type        Path\n")
+	for i := range ffs {
+		ff := &ffs[i]
+		path := ff.Path
+		if ff.Status == inventory.StatusMissing {
+			path += " (missing)"
+		}
+		fmt.Printf("%-16s    %s    %9.f    %-8s    %s\n", ff.Source, ff.Modified.Format("2006-01-02 15:04"), float32(ff.Size)/1000, ff.Type, path)
+	}
+	unit, unitName := bestUnit(sizeTotal)
+	fmt.Printf("\nFound %d files, %.f %s\n", len(ffs), float32(sizeTotal)/unit, unitName)
+	return nil
+}
+
+// parseWhere parses the query of a -where flag, nil if it is empty
+func parseWhere(s string) (*inventory.Query, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	q, err := inventory.ParseQuery(s)
+	if err != nil {
+		return nil, fmt.Errorf("-where: %w", err)
+	}
+	return q, nil
+}
+
+// whereFiles returns the paths of the indexed files matching q, for the -where and -tags filters
+func whereFiles(ctx context.Context, store inventory.Store, q inventory.FileQuery) (map[string]bool, error) {
+	ffs, err := store.FindFoundFiles(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[string]bool, len(ffs))
+	for i := range ffs {
+		paths[ffs[i].Path] = true
+	}
+	return paths, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+func TestFindOptionsQuery(t *testing.T) {
+	opts := findOptions{regex: `^IMG_\d+`, tags: "family, 2020, family,", minSize: "10KB", modifiedAfter: "2020-01-02", discoveredBefore: "2021-01-01T00:00:00Z"}
+	q, err := opts.query(inventory.FileQuery{Source: "laptop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Source != "laptop" || q.NameRegexp == nil || strings.Join(q.Tags, ",") != "2020,family" || q.MinSize != 10000 || q.MaxSize != 0 {
+		t.Errorf("query == %+v", q)
+	}
+	if !q.ModifiedAfter.Equal(time.Date(2020, 1, 2, 0, 0, 0, 0, time.Local)) || !q.DiscoveredBefore.Equal(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("query dates == %v, %v", q.ModifiedAfter, q.DiscoveredBefore)
+	}
+
+	for _, bad := range []findOptions{{regex: "("}, {maxSize: "big"}, {modifiedBefore: "yesterday"}} {
+		if _, err := bad.query(inventory.FileQuery{}); err == nil {
+			t.Errorf("query of %+v succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestFindFiles(t *testing.T) {
+	dir := t.TempDir()
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeFile(t, filepath.Join(dir, "a.txt"), "hello", modified)
+	writeFile(t, filepath.Join(dir, "photos", "IMG_1.jpg"), "photo", modified)
+	store := inventory.NewMemoryStore()
+	index(t, store, "laptop", dir)
+	index(t, store, "backup", filepath.Join(dir, "photos"))
+	if err := os.Remove(filepath.Join(dir, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	index(t, store, "laptop", dir)
+
+	out := captureOutput(t, func() error {
+		return findFiles(context.Background(), store, inventory.FileQuery{Name: "*.jpg"}, formatText)
+	})
+	if !strings.Contains(out, "backup") || !strings.Contains(out, filepath.Join(dir, "photos", "IMG_1.jpg")) || !strings.Contains(out, "Found 2 files") {
+		t.Errorf("find output:\n%s\nwant the photo in both sources", out)
+	}
+
+	out = captureOutput(t, func() error {
+		return findFiles(context.Background(), store, inventory.FileQuery{Source: "laptop", Extension: "txt", Missing: true}, formatJSON)
+	})
+	var got struct {
+		Files   []findRecord `json:"files"`
+		Summary findSummary  `json:"summary"`
+	}
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("find json output %q: %v", out, err)
+	}
+	if len(got.Files) != 1 || got.Files[0].Name != "a.txt" || !got.Files[0].Missing || got.Summary.NumFiles != 1 {
+		t.Errorf("find json output == %+v, want the missing a.txt", got)
+	}
+
+	out = captureOutput(t, func() error {
+		return findFiles(context.Background(), store, inventory.FileQuery{Extension: "txt"}, formatText)
+	})
+	if !strings.Contains(out, "No matching files found") {
+		t.Errorf("find output:\n%s\nwant no files, the only txt file is missing", out)
+	}
+}
+
+func TestWhere(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeFile(t, filepath.Join(dir, "a.txt"), "hello", modified)
+	writeFile(t, filepath.Join(dir, "b.txt"), "hello", modified)
+	writeFile(t, filepath.Join(dir, "c.jpg"), "hello", modified)
+	store := inventory.NewMemoryStore()
+	index(t, store, "laptop", dir)
+
+	if _, err := parseWhere("sise>1"); err == nil || !strings.HasPrefix(err.Error(), "-where: column 1") {
+		t.Errorf("parseWhere(sise>1) error == %v, want a parse error", err)
+	}
+	where, err := parseWhere("ext:txt -name:b*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := testFilter(dir)
+	if filter.where, err = whereFiles(ctx, store, inventory.FileQuery{Source: "laptop", Where: where}); err != nil {
+		t.Fatal(err)
+	}
+	out := captureOutput(t, func() error {
+		return listFiles(ctx, store, "laptop", dir, filter, formatJSONL)
+	})
+	if !strings.Contains(out, `"name":"a.txt"`) || strings.Contains(out, "b.txt") || strings.Contains(out, "c.jpg") {
+		t.Errorf("ls -where output:\n%s\nwant only a.txt", out)
+	}
+
+	for _, c := range []struct {
+		query string
+		want  int
+	}{{"ext:txt", 2}, {"-name:b.txt", 2}, {"name:a.txt", 0}} {
+		where, err := parseWhere(c.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := captureOutput(t, func() error {
+			return listDuplicates(ctx, store, "", 0, "", inventory.FileQuery{Where: where}, formatJSON)
+		})
+		var got struct {
+			Summary dupesSummary `json:"summary"`
+		}
+		if err := json.Unmarshal([]byte(out), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Summary.NumFiles != c.want {
+			t.Errorf("dupes -where %s == %d files, want %d", c.query, got.Summary.NumFiles, c.want)
+		}
+	}
+}
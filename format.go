@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Output formats. Besides text, every command writes its results as records, with the same fields
+// in each format, named by the json tags of the command's record type:
+//
+//	json    one object, {"<records>": [...], "summary": {...}}
+//	jsonl   one object per line for each record, then {"summary": {...}}
+//	csv     a header and a row for each record, lists are joined with ";", so stdout is a single
+//	        table. The summary is written to stderr as a header and a row, with the summary fields
+//	        that are lists of objects, like the health of each policy, following as their own
+//	        blocks of a header and rows after an empty line. Commands without records, like
+//	        index, write their summary to stdout instead.
+//
+// Times are RFC 3339 and empty when unknown, sizes are in bytes. Messages about progress and files
+// that couldn't be read are written to stderr, so stdout only has the results.
+const (
+	formatText  = "text"
+	formatJSON  = "json"
+	formatJSONL = "jsonl"
+	formatCSV   = "csv"
+)
+
+var formats = []string{formatText, formatJSON, formatJSONL, formatCSV}
+
+// addFormatFlag adds the -format flag to fs, defaulting to the format given before the command
+func addFormatFlag(fs *flag.FlagSet, format string) *string {
+	return fs.String("format", format, "output format ("+strings.Join(formats, ", ")+")")
+}
+
+func checkFormat(format string) error {
+	for _, f := range formats {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown format %q, expected %s", format, strings.Join(formats, ", "))
+}
+
+// resultWriter writes the records and summary of a command in a machine readable format
+type resultWriter struct {
+	format string
+	w      io.Writer
+	// key names the list of records in json
+	key string
+	// record is a zero record, so csv has a header even without any records
+	record  interface{}
+	records []interface{}
+	csv     *csv.Writer
+	// summary is where the summary goes in csv when there are records, stderr
+	summary io.Writer
+	n       int
+}
+
+// newResultWriter returns a writer to stdout of records like record, listed under key in json
+func newResultWriter(format string, key string, record interface{}) *resultWriter {
+	if format == "" {
+		format = formatText
+	}
+	r := &resultWriter{format: format, w: os.Stdout, summary: os.Stderr, key: key, record: record, records: []interface{}{}}
+	if format == formatCSV {
+		r.csv = csv.NewWriter(r.w)
+	}
+	return r
+}
+
+// text reports whether the results are printed for people rather than written as records
+func (r *resultWriter) text() bool {
+	return r.format == formatText
+}
+
+// log is where messages that aren't results go, stdout for text and stderr otherwise
+func (r *resultWriter) log() io.Writer {
+	if r.text() {
+		return os.Stdout
+	}
+	return os.Stderr
+}
+
+func (r *resultWriter) write(record interface{}) error {
+	r.n++
+	switch r.format {
+	case formatJSON:
+		r.records = append(r.records, record)
+	case formatJSONL:
+		return json.NewEncoder(r.w).Encode(record)
+	case formatCSV:
+		if r.n == 1 {
+			r.csv.Write(csvHeader(record))
+		}
+		return r.csv.Write(csvRow(record))
+	}
+	return nil
+}
+
+// close writes the summary, if any, and anything still buffered
+func (r *resultWriter) close(summary interface{}) error {
+	switch r.format {
+	case formatJSON:
+		out := map[string]interface{}{}
+		if r.key != "" {
+			out[r.key] = r.records
+		}
+		if summary != nil {
+			out["summary"] = summary
+		}
+		enc := json.NewEncoder(r.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	case formatJSONL:
+		if summary == nil {
+			return nil
+		}
+		return json.NewEncoder(r.w).Encode(map[string]interface{}{"summary": summary})
+	case formatCSV:
+		if r.record == nil {
+			if summary != nil {
+				writeCSVSummary(r.csv, summary)
+			}
+			r.csv.Flush()
+			return r.csv.Error()
+		}
+		if r.n == 0 {
+			r.csv.Write(csvHeader(r.record))
+		}
+		r.csv.Flush()
+		if err := r.csv.Error(); err != nil || summary == nil {
+			return err
+		}
+		w := csv.NewWriter(r.summary)
+		writeCSVSummary(w, summary)
+		w.Flush()
+		return w.Error()
+	}
+	return nil
+}
+
+// writeCSVSummary writes the summary to w as a header and a row, followed by a block for each of
+// its lists of objects after an empty line
+func writeCSVSummary(w *csv.Writer, summary interface{}) {
+	w.Write(csvHeader(summary))
+	w.Write(csvRow(summary))
+	v := reflect.Indirect(reflect.ValueOf(summary))
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if f.Kind() != reflect.Slice || v.Type().Field(i).PkgPath != "" || reflect.Indirect(reflect.New(f.Type().Elem())).Kind() != reflect.Struct {
+			continue
+		}
+		w.Write(nil)
+		w.Write(csvHeader(reflect.New(f.Type().Elem()).Interface()))
+		for j := 0; j < f.Len(); j++ {
+			w.Write(csvRow(f.Index(j).Interface()))
+		}
+	}
+}
+
+// csvFields returns the indexes and names of the fields of struct type t written to csv
+func csvFields(t reflect.Type) ([]int, []string) {
+	var idx []int
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" || f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		switch f.Type.Kind() {
+		case reflect.Struct, reflect.Map, reflect.Ptr, reflect.Interface:
+			continue
+		case reflect.Slice:
+			if f.Type.Elem().Kind() != reflect.String {
+				continue
+			}
+		}
+		idx = append(idx, i)
+		names = append(names, name)
+	}
+	return idx, names
+}
+
+func csvHeader(record interface{}) []string {
+	_, names := csvFields(reflect.Indirect(reflect.ValueOf(record)).Type())
+	return names
+}
+
+func csvRow(record interface{}) []string {
+	v := reflect.Indirect(reflect.ValueOf(record))
+	idx, _ := csvFields(v.Type())
+	row := make([]string, len(idx))
+	for i, fi := range idx {
+		f := v.Field(fi)
+		switch f.Kind() {
+		case reflect.String:
+			row[i] = f.String()
+		case reflect.Bool:
+			row[i] = strconv.FormatBool(f.Bool())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			row[i] = strconv.FormatInt(f.Int(), 10)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			row[i] = strconv.FormatUint(f.Uint(), 10)
+		case reflect.Float32, reflect.Float64:
+			row[i] = strconv.FormatFloat(f.Float(), 'f', -1, 64)
+		case reflect.Slice:
+			row[i] = strings.Join(f.Interface().([]string), ";")
+		}
+	}
+	return row
+}
+
+// formatTime formats t for records, an empty string if it is unknown
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+type testRecord struct {
+	Name  string   `json:"name"`
+	Size  int64    `json:"size"`
+	OK    bool     `json:"ok"`
+	Tags  []string `json:"tags"`
+	inner int
+}
+
+type testSummary struct {
+	NumRecords int `json:"num_records"`
+}
+
+func TestResultWriter(t *testing.T) {
+	records := []testRecord{{Name: "a, b", Size: 1, OK: true, Tags: []string{"x", "y"}}, {Name: "c", Size: 2, Tags: []string{}}}
+	cases := []struct {
+		format string
+		want   string
+	}{
+		{formatJSONL, `{"name":"a, b","size":1,"ok":true,"tags":["x","y"]}
+{"name":"c","size":2,"ok":false,"tags":[]}
+{"summary":{"num_records":2}}
+`},
+		{formatCSV, `name,size,ok,tags
+"a, b",1,true,x;y
+c,2,false,
+`},
+	}
+	for _, c := range cases {
+		out := captureOutput(t, func() error {
+			res := newResultWriter(c.format, "records", testRecord{})
+			for _, r := range records {
+				if err := res.write(r); err != nil {
+					return err
+				}
+			}
+			return res.close(testSummary{NumRecords: len(records)})
+		})
+		if out != c.want {
+			t.Errorf("%s output:\n%s\nwant:\n%s", c.format, out, c.want)
+		}
+	}
+
+	var summary string
+	out := captureOutput(t, func() error {
+		summary = captureStderr(t, func() error {
+			return newResultWriter(formatCSV, "records", testRecord{}).close(testSummary{})
+		})
+		return nil
+	})
+	if out != "name,size,ok,tags\n" || summary != "num_records\n0\n" {
+		t.Errorf("csv output without records == %q, summary %q, want the header and the summary on stderr", out, summary)
+	}
+	out = captureOutput(t, func() error {
+		return newResultWriter(formatCSV, "", nil).close(testSummary{NumRecords: 3})
+	})
+	if out != "num_records\n3\n" {
+		t.Errorf("csv output of a summary == %q, want the summary as the only row", out)
+	}
+}
+
+func TestListFilesFormats(t *testing.T) {
+	dir := t.TempDir()
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeFile(t, filepath.Join(dir, "a.txt"), "hello", modified)
+	store := inventory.NewMemoryStore()
+	index(t, store, "laptop", dir)
+	writeFile(t, filepath.Join(dir, "b.jpg"), "not indexed", modified)
+
+	out := captureOutput(t, func() error {
+		return listFiles(context.Background(), store, "laptop", dir, testFilter(dir), formatJSON)
+	})
+	var got struct {
+		Files   []fileRecord `json:"files"`
+		Summary filesSummary `json:"summary"`
+	}
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("ls json output %q: %v", out, err)
+	}
+	if len(got.Files) != 2 || got.Summary.NumFiles != 2 || got.Summary.SizeTotal != 16 {
+		t.Fatalf("ls json output == %+v, want 2 files of 16 bytes", got)
+	}
+	a, b := got.Files[0], got.Files[1]
+	if a.Name != "a.txt" || a.Modified != "2020-01-02T03:04:05Z" || a.Discovered == "" || b.Name != "b.jpg" || b.Type != "image" || b.Discovered != "" {
+		t.Errorf("ls json files == %+v, want a.txt discovered and b.jpg not", got.Files)
+	}
+
+	out = captureOutput(t, func() error {
+		return listFiles(context.Background(), store, "laptop", dir, testFilter(dir), formatCSV)
+	})
+	// stdout is a single table, loadable into spreadsheets
+	rows, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || strings.Join(rows[0], ",") != "path,name,type,size,modified,discovered" {
+		t.Errorf("ls csv output:\n%s\nwant a header and 2 files", out)
+	}
+}
+
+func TestCheckHealthFilesCSV(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	laptop, nas := filepath.Join(dir, "laptop"), filepath.Join(dir, "nas")
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeFile(t, filepath.Join(laptop, "a.jpg"), "on both", modified)
+	writeFile(t, filepath.Join(laptop, "b.jpg"), "only on the laptop", modified)
+	writeFile(t, filepath.Join(nas, "a.jpg"), "on both", modified)
+	store := inventory.NewMemoryStore()
+	for _, name := range []string{"laptop", "nas"} {
+		if err := store.AddSource(ctx, &inventory.Source{Name: name, Created: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		index(t, store, name, filepath.Join(dir, name))
+	}
+
+	var out string
+	summary := captureStderr(t, func() error {
+		out = captureOutput(t, func() error {
+			return checkHealthFiles(ctx, store, "laptop", laptop, testFilter(laptop), nil, formatCSV)
+		})
+		return nil
+	})
+	if strings.Contains(out, "\n\n") || strings.Contains(out, "num_files") {
+		t.Errorf("health csv output:\n%s\nwant only the files", out)
+	}
+	want := "num_files,num_healthy,health,num_not_indexed,num_errors\n2,1,50,0,0\n" +
+		"\nname,copies,kinds,num_files,num_healthy,health\ndefault,2,,2,1,50\n"
+	if !strings.HasSuffix(summary, want) {
+		t.Errorf("health csv summary:\n%s\nwant:\n%s", summary, want)
+	}
+}
@@ -3,5 +3,7 @@ module github.com/roh/fileinventory
 go 1.15
 
 require (
+	github.com/cespare/xxhash/v2 v2.3.0
 	github.com/mattn/go-sqlite3 v1.14.6
+	github.com/zeebo/blake3 v0.2.4
 )
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/zeebo/blake3"
+)
+
+// Hasher computes digests of file contents with a named algorithm
+type Hasher struct {
+	Algorithm string
+	New       func() hash.Hash
+}
+
+var hashers = map[string]Hasher{
+	"md5":    {"md5", md5.New},
+	"sha256": {"sha256", sha256.New},
+	"blake3": {"blake3", func() hash.Hash { return blake3.New() }},
+	"xxhash": {"xxhash", func() hash.Hash { return xxhash.New() }},
+}
+
+// HashAlgorithms returns the names of the supported hash algorithms
+func HashAlgorithms() []string {
+	var names []string
+	for name := range hashers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseHashers parses a comma separated list of algorithms, i.e. "sha256,xxhash".
+// The first algorithm is the primary one used to identify the file's content.
+func ParseHashers(s string) ([]Hasher, error) {
+	var hs []Hasher
+	seen := map[string]bool{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		h, ok := hashers[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown hash algorithm %q, expected one of %s", name, strings.Join(HashAlgorithms(), ", "))
+		}
+		seen[name] = true
+		hs = append(hs, h)
+	}
+	if len(hs) == 0 {
+		return nil, fmt.Errorf("no hash algorithm specified")
+	}
+	return hs, nil
+}
+
+// getHashes reads the file once and returns its digest for every hasher, keyed by algorithm, along
+// with the media type sniffed from its first bytes
+func getHashes(path string, hs []Hasher) (map[string]string, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	hashes := make([]hash.Hash, len(hs))
+	writers := make([]io.Writer, len(hs), len(hs)+1)
+	for i, h := range hs {
+		hashes[i] = h.New()
+		writers[i] = hashes[i]
+	}
+	head := &headWriter{}
+	if _, err := io.Copy(io.MultiWriter(append(writers, head)...), f); err != nil {
+		return nil, "", err
+	}
+	digests := make(map[string]string, len(hs))
+	for i, h := range hs {
+		digests[h.Algorithm] = fmt.Sprintf("%x", hashes[i].Sum(nil))
+	}
+	return digests, sniffMIME(head.b), nil
+}
+
+type hashJob struct {
+	ff inventory.FoundFile
+	hs []Hasher
+	// previous is the indexed file the result is compared to, if any
+	previous *inventory.FoundFile
+	// seq is the file's position in the walk, used to checkpoint index runs
+	seq int
+	// taken is set to also read when the file was taken, for the categorization rules
+	taken bool
+}
+
+type hashResult struct {
+	hashJob
+	hashes map[string]string
+	// mime is the media type of the contents, if it is known
+	mime string
+	// takenTime is when the file was taken, if the job asked for it and takenErr is nil
+	takenTime time.Time
+	takenErr  error
+	// err is set if the file couldn't be read, hashing carries on with the other files
+	err error
+}
+
+// hashFiles hashes the files received on jobs with a pool of workers, passing each result to handle
+// as it completes, until jobs is closed. handle is always called from the calling goroutine, so it is
+// safe to write to the database from it. Hashing stops at the first error returned by handle or when
+// ctx is cancelled, the caller should then cancel whatever is sending jobs.
+func hashFiles(ctx context.Context, jobs <-chan hashJob, workers int, progress *indexProgress, handle func(hashResult) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	progress.mu.Lock()
+	progress.current = make([]string, workers)
+	progress.mu.Unlock()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan hashResult, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			hashWorker(ctx, w, jobs, results, progress)
+		}(w)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	progress.display()
+	var err error
+	for done := false; !done; {
+		select {
+		case r, ok := <-results:
+			if !ok {
+				done = true
+				break
+			}
+			if err != nil {
+				// Drain the remaining results so the workers can exit
+				continue
+			}
+			if err = handle(r); err != nil {
+				cancel()
+				continue
+			}
+			progress.fileSaved(r.ff.Size)
+			progress.display()
+		case <-ticker.C:
+			progress.display()
+		}
+	}
+	progress.clear()
+	if err == nil {
+		err = ctx.Err()
+	}
+	return err
+}
+
+func hashWorker(ctx context.Context, w int, jobs <-chan hashJob, results chan<- hashResult, progress *indexProgress) {
+	for {
+		var job hashJob
+		select {
+		case j, ok := <-jobs:
+			if !ok {
+				return
+			}
+			job = j
+		case <-ctx.Done():
+			return
+		}
+		progress.setCurrent(w, job.ff.Name)
+		r := hashResult{hashJob: job}
+		r.hashes, r.mime, r.err = getHashes(job.ff.Path, job.hs)
+		if job.taken && r.err == nil {
+			r.takenTime, r.takenErr = readTakenTime(job.ff.Path)
+		}
+		progress.setCurrent(w, "")
+		select {
+		case results <- r:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestParseHashers(t *testing.T) {
+	cases := []struct {
+		s       string
+		want    []string
+		wantErr bool
+	}{
+		{"md5", []string{"md5"}, false},
+		{"SHA256, xxhash", []string{"sha256", "xxhash"}, false},
+		{"blake3,blake3,md5", []string{"blake3", "md5"}, false},
+		{"", nil, true},
+		{"crc32", nil, true},
+	}
+
+	for _, c := range cases {
+		got, err := ParseHashers(c.s)
+		if (err != nil) != c.wantErr {
+			t.Errorf("ParseHashers(%q) error == %v, want error %v", c.s, err, c.wantErr)
+			continue
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("ParseHashers(%q) == %v, want %v", c.s, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i].Algorithm != c.want[i] {
+				t.Errorf("ParseHashers(%q)[%d] == %v, want %v", c.s, i, got[i].Algorithm, c.want[i])
+			}
+		}
+	}
+}
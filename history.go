@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+// fileVersionRecord is a version of a file listed by history
+type fileVersionRecord struct {
+	Source        string `json:"source"`
+	Path          string `json:"path"`
+	HashAlgorithm string `json:"hash_algorithm"`
+	Hash          string `json:"hash"`
+	Size          int64  `json:"size"`
+	Modified      string `json:"modified"`
+	FirstSeen     string `json:"first_seen"`
+	LastSeen      string `json:"last_seen"`
+	// Current is whether it is the version the source has now
+	Current bool `json:"current"`
+}
+
+type historySummary struct {
+	NumVersions int `json:"num_versions"`
+}
+
+// showHistory prints every version of the file seen at path, marking the current version of each source
+func showHistory(ctx context.Context, store inventory.Store, source string, path string, format string) error {
+	fvs, err := store.GetFileVersions(ctx, source, path)
+	if err != nil {
+		return err
+	}
+	res := newResultWriter(format, "versions", fileVersionRecord{})
+	if res.text() {
+		if len(fvs) == 0 {
+			fmt.Println("No history found for", path)
+			return nil
+		}
+		fmt.Println(path)
+		fmt.Print("    Source              First seen          Last seen           Modified            Size (KB)    Hash\n")
+	}
+	current := map[string]*inventory.FoundFile{}
+	for _, fv := range fvs {
+		ff, ok := current[fv.Source]
+		if !ok {
+			ff, err = store.GetFoundFile(ctx, fv.Source, fv.Path)
+			if err != nil {
+				return err
+			}
+			current[fv.Source] = ff
+		}
+		isCurrent := ff != nil && ff.Hash == fv.Hash && ff.Size == fv.Size && ff.Modified.Equal(fv.Modified)
+		if !res.text() {
+			err := res.write(fileVersionRecord{Source: fv.Source, Path: fv.Path, HashAlgorithm: fv.HashAlgorithm, Hash: fv.Hash, Size: fv.Size,
+				Modified: formatTime(fv.Modified), FirstSeen: formatTime(fv.FirstSeen), LastSeen: formatTime(fv.LastSeen), Current: isCurrent})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		marker := " "
+		if isCurrent {
+			marker = "*"
+		}
+		s := float32(fv.Size) / 1000
+		fmt.Printf("  %s %-16s    %s    %s    %s    %9.f    %s:%s\n", marker, fv.Source, fv.FirstSeen.Format("2006-01-02 15:04"), fv.LastSeen.Format("2006-01-02 15:04"), fv.Modified.Format("2006-01-02 15:04"), s, fv.HashAlgorithm, fv.Hash)
+	}
+	if !res.text() {
+		return res.close(historySummary{NumVersions: len(fvs)})
+	}
+	fmt.Println("\n* current version")
+	return nil
+}
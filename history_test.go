@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+func TestShowHistory(t *testing.T) {
+	ctx := context.Background()
+	store := inventory.NewMemoryStore()
+	checked := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	old := &inventory.FoundFile{Source: "laptop", Path: "/notes.txt", Name: "notes.txt", Hash: "aaa", HashAlgorithm: "md5", Size: 5000,
+		Modified: checked, Discovered: checked, LastChecked: checked}
+	edited := *old
+	edited.Hash, edited.Size, edited.Modified, edited.LastChecked = "bbb", 7000, checked.Add(24*time.Hour), checked.Add(48*time.Hour)
+	for _, ff := range []*inventory.FoundFile{old, &edited} {
+		if err := store.Save(ctx, ff); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ff, err := store.GetFoundFileWithHash(ctx, "laptop", "/notes.txt", "aaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ff == nil || ff.Status != inventory.StatusReplaced {
+		t.Errorf("GetFoundFileWithHash(aaa) == %+v, want the first version replaced", ff)
+	}
+
+	out := captureOutput(t, func() error {
+		return showHistory(ctx, store, "", "/notes.txt", formatText)
+	})
+	lines := strings.Split(out, "\n")
+	want := []string{
+		"/notes.txt",
+		"    Source              First seen          Last seen           Modified            Size (KB)    Hash",
+		"    laptop              2020-01-02 03:04    2020-01-02 03:04    2020-01-02 03:04            5    md5:aaa",
+		"  * laptop              2020-01-04 03:04    2020-01-04 03:04    2020-01-03 03:04            7    md5:bbb",
+		"",
+		"* current version",
+	}
+	if len(lines) < len(want) || strings.Join(lines[:len(want)], "\n") != strings.Join(want, "\n") {
+		t.Errorf("history output:\n%s\nwant:\n%s", out, strings.Join(want, "\n"))
+	}
+
+	out = captureOutput(t, func() error {
+		return showHistory(ctx, store, "laptop", "/notes.txt", formatJSONL)
+	})
+	records := strings.Split(strings.TrimSpace(out), "\n")
+	if len(records) < 2 || !strings.Contains(records[0], `"hash":"aaa"`) || !strings.Contains(records[0], `"current":false`) ||
+		!strings.Contains(records[1], `"hash":"bbb"`) || !strings.Contains(records[1], `"current":true`) {
+		t.Errorf("history -format jsonl output:\n%s\nwant aaa replaced by the current bbb", out)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+// ignoreFileName is the name of the files listing gitignore style patterns of files to skip.
+// They apply to the folder they are in and all its subfolders, including when walking a subfolder,
+// from the root of the source down.
+const ignoreFileName = ".inventoryignore"
+
+// globalIgnorePath returns the path of the ignore file applying to every walk, i.e.
+// $XDG_CONFIG_HOME/fileinventory/ignore. Its patterns are relative to the folder walked. It is
+// apart from the ignore files of folders, so it isn't also read as the ignore file of the home folder.
+func globalIgnorePath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "fileinventory", "ignore"), nil
+}
+
+// ignoreRule is a single gitignore style pattern
+type ignoreRule struct {
+	// base is the folder the pattern is relative to
+	base    string
+	pattern string
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+// parseIgnoreRule parses a line of an ignore file, returning nil for blank lines and comments
+func parseIgnoreRule(base string, line string) (*ignoreRule, error) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+	r := &ignoreRule{base: base, pattern: line}
+	if strings.HasPrefix(line, "!") {
+		r.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return nil, nil
+	}
+	re, err := compileIgnorePattern(line)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", r.pattern, err)
+	}
+	r.re = re
+	return r, nil
+}
+
+// compileIgnorePattern converts a gitignore style pattern to a regular expression matching
+// slash separated paths relative to the pattern's base folder
+func compileIgnorePattern(pattern string) (*regexp.Regexp, error) {
+	var re strings.Builder
+	// Patterns with a slash only match relative to the base folder, others match at any depth
+	if strings.Contains(pattern, "/") {
+		re.WriteString("^")
+		pattern = strings.TrimPrefix(pattern, "/")
+	} else {
+		re.WriteString("^(?:.*/)?")
+	}
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		atSegmentStart := i == 0 || pattern[i-1] == '/'
+		switch {
+		case atSegmentStart && strings.HasPrefix(pattern[i:], "**/"):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case atSegmentStart && pattern[i:] == "**":
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	re.WriteString("$")
+	return regexp.Compile(re.String())
+}
+
+func (r *ignoreRule) match(path string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	rel, err := filepath.Rel(r.base, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	return r.re.MatchString(filepath.ToSlash(rel))
+}
+
+// readIgnoreFile returns the rules in the ignore file at path, relative to base.
+// A missing file has no rules.
+func readIgnoreFile(path string, base string) ([]*ignoreRule, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rules []*ignoreRule
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		r, err := parseIgnoreRule(base, scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		if r != nil {
+			rules = append(rules, r)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// walkFilter decides which files and folders are skipped when walking a folder. Rules are
+// applied in order, the global ignore file, -exclude flags, then the ignore files in each
+// folder from the top down, and the last matching rule wins.
+type walkFilter struct {
+	root string
+	// top is the highest folder whose ignore file applies, the root of the source, or the
+	// filesystem root if it is empty, so the same files are skipped whichever folder is walked
+	top      string
+	global   []*ignoreRule
+	includes []*ignoreRule
+	hidden   bool
+	// dirRules caches the rules of the ignore file in each folder
+	dirRules map[string][]*ignoreRule
+	// where, when set, has the only files walked, the indexed files matching a -where query
+	where map[string]bool
+}
+
+func newWalkFilter(root string, excludes []string, includes []string, hidden bool) (*walkFilter, error) {
+	f := &walkFilter{root: root, hidden: hidden, dirRules: map[string][]*ignoreRule{}}
+	if path, err := globalIgnorePath(); err == nil {
+		rules, err := readIgnoreFile(path, root)
+		if err != nil {
+			return nil, err
+		}
+		f.global = rules
+	}
+	for _, pattern := range excludes {
+		r, err := parseIgnoreRule(root, pattern)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil {
+			f.global = append(f.global, r)
+		}
+	}
+	for _, pattern := range includes {
+		r, err := parseIgnoreRule(root, pattern)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil {
+			f.includes = append(f.includes, r)
+		}
+	}
+	return f, nil
+}
+
+// setSource stops reading ignore files at the root of source, the source walked, when the folder
+// walked is inside it
+func (f *walkFilter) setSource(ctx context.Context, store inventory.Store, source string) error {
+	root, err := sourceRoot(ctx, store, source)
+	if err != nil {
+		return err
+	}
+	if isUnder(f.root, root) {
+		f.top = root
+	}
+	return nil
+}
+
+// rulesFor returns the rules of the ignore files in dir and its parents up to the top folder,
+// top down
+func (f *walkFilter) rulesFor(dir string) []*ignoreRule {
+	var dirs []string
+	for d := dir; ; d = filepath.Dir(d) {
+		dirs = append(dirs, d)
+		if d == f.top || filepath.Dir(d) == d {
+			break
+		}
+	}
+	var rules []*ignoreRule
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		dirRules, ok := f.dirRules[d]
+		if !ok {
+			var err error
+			dirRules, err = readIgnoreFile(filepath.Join(d, ignoreFileName), d)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Warning:", err)
+			}
+			f.dirRules[d] = dirRules
+		}
+		rules = append(rules, dirRules...)
+	}
+	return rules
+}
+
+// skip reports whether the file or folder at path should be skipped, assuming its parent folders are not
+func (f *walkFilter) skip(path string, isDir bool) bool {
+	if path == f.root {
+		return false
+	}
+	if !f.hidden && IsHidden(filepath.Base(path)) {
+		return true
+	}
+	skipped := false
+	for _, rules := range [][]*ignoreRule{f.global, f.rulesFor(filepath.Dir(path))} {
+		for _, r := range rules {
+			if r.match(path, isDir) {
+				skipped = !r.negate
+			}
+		}
+	}
+	if skipped || isDir {
+		return skipped
+	}
+	if f.where != nil && !f.where[path] {
+		return true
+	}
+	if len(f.includes) == 0 {
+		return false
+	}
+	for _, r := range f.includes {
+		if r.match(path, isDir) {
+			return false
+		}
+	}
+	return true
+}
+
+// ignored reports whether the file at path, or any of its parent folders below root, is skipped
+func (f *walkFilter) ignored(path string) bool {
+	rel, err := filepath.Rel(f.root, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	dir := f.root
+	parts := strings.Split(rel, string(filepath.Separator))
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		if f.skip(dir, true) {
+			return true
+		}
+	}
+	return f.skip(path, false)
+}
+
+// stringList is a flag that can be repeated
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// walkFilterFlags are the flags controlling which files are walked
+type walkFilterFlags struct {
+	excludes stringList
+	includes stringList
+	hidden   *bool
+}
+
+func addWalkFilterFlags(fs *flag.FlagSet) *walkFilterFlags {
+	w := &walkFilterFlags{}
+	fs.Var(&w.excludes, "exclude", "skip files and folders matching this gitignore style pattern, can be repeated")
+	fs.Var(&w.includes, "include", "only include files matching this gitignore style pattern, can be repeated")
+	w.hidden = fs.Bool("hidden", false, "include hidden files and folders")
+	return w
+}
+
+func (w *walkFilterFlags) newWalkFilter(root string) (*walkFilter, error) {
+	return newWalkFilter(root, w.excludes, w.includes, *w.hidden)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+func TestIgnoreRuleMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		{"*.tmp", "a.tmp", false, true},
+		{"*.tmp", "sub/dir/a.tmp", false, true},
+		{"*.tmp", "a.tmp.jpg", false, false},
+		{"/build", "build", true, true},
+		{"/build", "sub/build", true, false},
+		{"node_modules/", "sub/node_modules", true, true},
+		{"node_modules/", "node_modules", false, false},
+		{"docs/*.pdf", "docs/a.pdf", false, true},
+		{"docs/*.pdf", "docs/sub/a.pdf", false, false},
+		{"docs/*.pdf", "sub/docs/a.pdf", false, false},
+		{"**/cache", "a/b/cache", true, true},
+		{"**/cache", "cache", true, true},
+		{"photos/**", "photos/2020/a.jpg", false, true},
+		{"photos/**", "photos", true, false},
+		{"a/**/b", "a/b", true, true},
+		{"a/**/b", "a/x/y/b", true, true},
+		{"IMG_????.jpg", "IMG_0001.jpg", false, true},
+		{"IMG_????.jpg", "IMG_01.jpg", false, false},
+		{"[Tt]humbs.db", "thumbs.db", false, true},
+		{"file[!0-9]", "file1", false, false},
+		{"file[!0-9]", "filea", false, true},
+		{`\#notes`, "#notes", false, true},
+		{"café.txt", "café.txt", false, true},
+		{"a+b(1).txt", "a+b(1).txt", false, true},
+	}
+
+	for _, c := range cases {
+		r, err := parseIgnoreRule("/root", c.pattern)
+		if err != nil {
+			t.Errorf("parseIgnoreRule(%q) error == %v", c.pattern, err)
+			continue
+		}
+		got := r.match(filepath.Join("/root", filepath.FromSlash(c.path)), c.isDir)
+		if got != c.want {
+			t.Errorf("%q match(%q, %v) == %v, want %v", c.pattern, c.path, c.isDir, got, c.want)
+		}
+	}
+}
+
+func TestParseIgnoreRuleSkipsComments(t *testing.T) {
+	for _, line := range []string{"", "   ", "# a comment", "/"} {
+		r, err := parseIgnoreRule("/root", line)
+		if r != nil || err != nil {
+			t.Errorf("parseIgnoreRule(%q) == %v, %v, want nil", line, r, err)
+		}
+	}
+}
+
+func TestWalkFilter(t *testing.T) {
+	dir := t.TempDir()
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, name := range []string{"a.jpg", "a.tmp", "keep.tmp", ".hidden.jpg", "cache/b.jpg", "sub/c.jpg", "sub/d.txt"} {
+		writeFile(t, filepath.Join(dir, filepath.FromSlash(name)), name, modified)
+	}
+	writeFile(t, filepath.Join(dir, ignoreFileName), "*.tmp\n!keep.tmp\ncache/\n", modified)
+	writeFile(t, filepath.Join(dir, "sub", ignoreFileName), "*.txt\n", modified)
+
+	cases := []struct {
+		name     string
+		excludes []string
+		includes []string
+		hidden   bool
+		want     []string
+	}{
+		{"ignore files", nil, nil, false, []string{"a.jpg", "keep.tmp", "sub/c.jpg"}},
+		{"exclude", []string{"sub"}, nil, false, []string{"a.jpg", "keep.tmp"}},
+		{"include", nil, []string{"*.jpg"}, false, []string{"a.jpg", "sub/c.jpg"}},
+		{"hidden", nil, []string{"*.jpg"}, true, []string{".hidden.jpg", "a.jpg", "sub/c.jpg"}},
+	}
+
+	for _, c := range cases {
+		f := testFilter(dir)
+		f.hidden = c.hidden
+		for _, p := range c.excludes {
+			r, _ := parseIgnoreRule(dir, p)
+			f.global = append(f.global, r)
+		}
+		for _, p := range c.includes {
+			r, _ := parseIgnoreRule(dir, p)
+			f.includes = append(f.includes, r)
+		}
+		var got []string
+		err := walkFiles(context.Background(), dir, "laptop", f, func(ff inventory.FoundFile, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(dir, ff.Path)
+			got = append(got, filepath.ToSlash(rel))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("%s: walked %v, want %v", c.name, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("%s: walked %v, want %v", c.name, got, c.want)
+				break
+			}
+		}
+	}
+}
+
+func TestIgnoredFilesAreNotMissing(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeFile(t, filepath.Join(dir, "a.jpg"), "hello", modified)
+	writeFile(t, filepath.Join(dir, "cache", "b.jpg"), "world", modified)
+	store := inventory.NewMemoryStore()
+	index(t, store, "laptop", dir)
+
+	writeFile(t, filepath.Join(dir, ignoreFileName), "cache/\n", modified)
+	index(t, store, "laptop", dir)
+	missing, err := store.GetMissingFoundFilesUnderPath(ctx, "laptop", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 0 {
+		t.Errorf("%d files marked missing after ignoring them, want 0", len(missing))
+	}
+}
+
+// setEnv sets the environment variable key for the rest of the test
+func setEnv(t *testing.T, key string, value string) {
+	t.Helper()
+	old, ok := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestWalkFilterGlobalIgnoreFile(t *testing.T) {
+	home := t.TempDir()
+	setEnv(t, "HOME", home)
+	setEnv(t, "XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	globalPath, err := globalIgnorePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, globalPath, "/build\n", modified)
+	// The ignore file of the home folder is relative to it, unlike the global one
+	writeFile(t, filepath.Join(home, ignoreFileName), "/a.log\n", modified)
+	root := filepath.Join(home, "project")
+	for _, name := range []string{"a.log", "build/b.jpg", "sub/build/c.jpg"} {
+		writeFile(t, filepath.Join(root, filepath.FromSlash(name)), name, modified)
+	}
+
+	f, err := newWalkFilter(root, nil, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.top = home
+	var got []string
+	err = walkFiles(context.Background(), root, "laptop", f, func(ff inventory.FoundFile, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, ff.Path)
+		got = append(got, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "a.log sub/build/c.jpg"; strings.Join(got, " ") != want {
+		t.Errorf("walked %v, want %s", got, want)
+	}
+}
+
+func TestIndexPathSubfolderUnderIgnoringParent(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	setEnv(t, "XDG_CONFIG_HOME", t.TempDir())
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeFile(t, filepath.Join(dir, ignoreFileName), "node_modules/\n", modified)
+	writeFile(t, filepath.Join(dir, "app", "main.js"), "main", modified)
+	writeFile(t, filepath.Join(dir, "app", "node_modules", "dep.js"), "dep", modified)
+	store := inventory.NewRootedStore(inventory.NewMemoryStore())
+	if err := store.AddSource(ctx, &inventory.Source{Name: "laptop", Root: dir, Created: modified}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The ignore file of the source root applies whichever folder is indexed
+	app := filepath.Join(dir, "app")
+	f, err := newWalkFilter(app, nil, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.setSource(ctx, store, "laptop"); err != nil {
+		t.Fatal(err)
+	}
+	hs, _ := ParseHashers("md5")
+	captureOutput(t, func() error {
+		return indexPath(ctx, store, "laptop", app, indexOptions{workers: 2, hashers: hs, batchSize: 2, filter: f})
+	})
+	ffs, err := store.GetFoundFilesUnderPath(ctx, "laptop", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ffs) != 1 || ffs[0].Name != "main.js" {
+		t.Errorf("indexed %+v, want only main.js", ffs)
+	}
+	if !f.ignored(filepath.Join(app, "node_modules", "dep.js")) {
+		t.Error("ignored(node_modules/dep.js) == false, want true")
+	}
+}
@@ -0,0 +1,43 @@
+package inventory
+
+import "context"
+
+// DuplicateGroup is a set of files with the same content
+type DuplicateGroup struct {
+	HashAlgorithm string
+	Hash          string
+	Size          int64
+	Files         []FoundFile
+}
+
+// Wasted is the space that could be reclaimed by keeping only one copy
+func (g *DuplicateGroup) Wasted() int64 {
+	return g.Size * int64(len(g.Files)-1)
+}
+
+// GetDuplicateGroups returns the current files sharing a hash with at least one other file.
+// An empty source or fileType matches all sources or types.
+func (s *SQLiteStore) GetDuplicateGroups(ctx context.Context, source string, minSize int64, fileType string) ([]DuplicateGroup, error) {
+	const sql = `
+		SELECT ` + foundFileColumns + `
+		FROM found_files WHERE status = '' and (? = '' or source = ?) and size >= ? and (? = '' or type = ?)
+			and (hash_algorithm, hash) IN (
+				SELECT hash_algorithm, hash FROM found_files
+				WHERE status = '' and (? = '' or source = ?) and size >= ? and (? = '' or type = ?)
+				GROUP BY hash_algorithm, hash HAVING count(*) > 1)
+		ORDER BY hash_algorithm, hash, source, path`
+	ffs, err := s.queryFoundFiles(ctx, sql, source, source, minSize, fileType, fileType, source, source, minSize, fileType, fileType)
+	if err != nil {
+		return nil, err
+	}
+	var groups []DuplicateGroup
+	for _, ff := range ffs {
+		n := len(groups)
+		if n == 0 || groups[n-1].HashAlgorithm != ff.HashAlgorithm || groups[n-1].Hash != ff.Hash {
+			groups = append(groups, DuplicateGroup{HashAlgorithm: ff.HashAlgorithm, Hash: ff.Hash, Size: ff.Size})
+			n++
+		}
+		groups[n-1].Files = append(groups[n-1].Files, ff)
+	}
+	return groups, nil
+}
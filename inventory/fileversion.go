@@ -0,0 +1,49 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// FileVersion is a distinct content, size and modified time observed at a path
+type FileVersion struct {
+	Source        string
+	Path          string
+	Hash          string
+	HashAlgorithm string
+	Size          int64
+	Modified      time.Time
+	FirstSeen     time.Time
+	LastSeen      time.Time
+}
+
+// GetFileVersions returns every version seen at path, oldest first. An empty source returns versions from all sources.
+func (s *SQLiteStore) GetFileVersions(ctx context.Context, source string, path string) ([]FileVersion, error) {
+	const sql = `
+		SELECT source, path, hash, hash_algorithm, size, modified, first_seen, last_seen
+		FROM file_versions WHERE (? = '' or source = ?) and path = ? ORDER BY source, first_seen`
+	rows, err := s.db.QueryContext(ctx, sql, source, source, path)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var fvs []FileVersion
+	for rows.Next() {
+		fv, err := toFileVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		fvs = append(fvs, *fv)
+	}
+	return fvs, rows.Err()
+}
+
+func toFileVersion(rows *sql.Rows) (*FileVersion, error) {
+	var fv FileVersion
+	err := rows.Scan(&fv.Source, &fv.Path, &fv.Hash, &fv.HashAlgorithm, &fv.Size, &fv.Modified, &fv.FirstSeen, &fv.LastSeen)
+	if err != nil {
+		return nil, err
+	}
+	return &fv, nil
+}
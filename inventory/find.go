@@ -0,0 +1,188 @@
+package inventory
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// FileQuery selects indexed files for FindFoundFiles, fields left empty don't filter
+type FileQuery struct {
+	Source string
+	// Name is a glob matched against the file name, i.e. IMG_*.jpg
+	Name string
+	// NameRegexp is matched against the file name
+	NameRegexp  *regexp.Regexp
+	Extension   string
+	Type        string
+	Category    string
+	Subcategory string
+	Label       string
+	// Tags are the tags the file needs to have, all of them
+	Tags []string
+	// MinSize and MaxSize are inclusive, a MaxSize of 0 has no limit
+	MinSize int64
+	MaxSize int64
+	// The date ranges include After and exclude Before
+	ModifiedAfter    time.Time
+	ModifiedBefore   time.Time
+	DiscoveredAfter  time.Time
+	DiscoveredBefore time.Time
+	// Hash is a digest of the file with any algorithm
+	Hash string
+	// Missing includes the files that are no longer found, which are otherwise left out
+	Missing bool
+	// Where is a query the files need to match too, nil for none
+	Where *Query
+	// Limit is the most files returned, 0 has no limit
+	Limit int
+}
+
+// sql returns the conditions of q on found_files and their arguments. NameRegexp isn't included,
+// it is checked by matchPost.
+func (q *FileQuery) sql() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	add := func(cond string, arg ...interface{}) {
+		conds = append(conds, cond)
+		args = append(args, arg...)
+	}
+	if q.Missing {
+		add("status IN (?, ?)", StatusCurrent, StatusMissing)
+	} else {
+		add("status = ?", StatusCurrent)
+	}
+	for _, c := range []struct {
+		column string
+		value  string
+	}{
+		{"source", q.Source}, {"extension", q.Extension}, {"type", q.Type},
+		{"category", q.Category}, {"subcategory", q.Subcategory}, {"label", q.Label},
+	} {
+		if c.value != "" {
+			add(c.column+" = ?", c.value)
+		}
+	}
+	if q.Name != "" {
+		add("name GLOB ?", q.Name)
+	}
+	if q.MinSize > 0 {
+		add("size >= ?", q.MinSize)
+	}
+	if q.MaxSize > 0 {
+		add("size <= ?", q.MaxSize)
+	}
+	for _, c := range []struct {
+		cond  string
+		value time.Time
+	}{
+		// Times are stored as text with the offset they had, so compare them as julian days
+		{"julianday(modified) >= julianday(?)", q.ModifiedAfter}, {"julianday(modified) < julianday(?)", q.ModifiedBefore},
+		{"julianday(discovered) >= julianday(?)", q.DiscoveredAfter}, {"julianday(discovered) < julianday(?)", q.DiscoveredBefore},
+	} {
+		if !c.value.IsZero() {
+			add(c.cond, c.value)
+		}
+	}
+	if q.Hash != "" {
+		add("(hash = ? or (source, path, hash) IN (SELECT source, path, hash FROM file_hashes WHERE digest = ?))", q.Hash, q.Hash)
+	}
+	for _, tag := range q.Tags {
+		add(hasTagSQL, tag)
+	}
+	if q.Where != nil {
+		whereConds, whereArgs := q.Where.sql()
+		conds = append(conds, whereConds...)
+		args = append(args, whereArgs...)
+	}
+	return strings.Join(conds, " and "), args
+}
+
+// matchPost reports whether ff matches NameRegexp, the part of q that isn't done in SQL
+func (q *FileQuery) matchPost(ff *FoundFile) bool {
+	return q.NameRegexp == nil || q.NameRegexp.MatchString(ff.Name)
+}
+
+// match reports whether ff matches q, hashes are its digests and copies counts the sources with its content
+func (q *FileQuery) match(ff *FoundFile, hashes map[string]string, copies func() int) bool {
+	if ff.Status != StatusCurrent && !(q.Missing && ff.Status == StatusMissing) {
+		return false
+	}
+	for _, c := range []struct{ got, want string }{
+		{ff.Source, q.Source}, {ff.Extension, q.Extension}, {ff.Type, q.Type},
+		{ff.Category, q.Category}, {ff.Subcategory, q.Subcategory}, {ff.Label, q.Label},
+	} {
+		if c.want != "" && c.got != c.want {
+			return false
+		}
+	}
+	if q.Name != "" {
+		if ok, _ := filepath.Match(q.Name, ff.Name); !ok {
+			return false
+		}
+	}
+	if ff.Size < q.MinSize || (q.MaxSize > 0 && ff.Size > q.MaxSize) {
+		return false
+	}
+	if !inRange(ff.Modified, q.ModifiedAfter, q.ModifiedBefore) || !inRange(ff.Discovered, q.DiscoveredAfter, q.DiscoveredBefore) {
+		return false
+	}
+	if q.Hash != "" && ff.Hash != q.Hash {
+		found := false
+		for _, digest := range hashes {
+			found = found || digest == q.Hash
+		}
+		if !found {
+			return false
+		}
+	}
+	if q.Where != nil && !q.Where.match(ff, hashes, copies) {
+		return false
+	}
+	return q.matchPost(ff) && hasTags(ff.Tags, q.Tags)
+}
+
+func inRange(t time.Time, after time.Time, before time.Time) bool {
+	return (after.IsZero() || !t.Before(after)) && (before.IsZero() || t.Before(before))
+}
+
+// hasTags reports whether tags include every one of want
+func hasTags(tags []string, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, t := range tags {
+			found = found || t == w
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// FindFoundFiles returns the indexed files matching q in any source, ordered by source and path
+func (s *SQLiteStore) FindFoundFiles(ctx context.Context, q FileQuery) ([]FoundFile, error) {
+	where, args := q.sql()
+	query := `SELECT ` + foundFileColumns + ` FROM found_files WHERE ` + where + ` ORDER BY source, path`
+	post := q.NameRegexp != nil
+	if q.Limit > 0 && !post {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+	ffs, err := s.queryFoundFiles(ctx, query, args...)
+	if err != nil || !post {
+		return ffs, err
+	}
+	var matched []FoundFile
+	for i := range ffs {
+		if q.matchPost(&ffs[i]) {
+			matched = append(matched, ffs[i])
+			if len(matched) == q.Limit {
+				break
+			}
+		}
+	}
+	return matched, nil
+}
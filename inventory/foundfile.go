@@ -1,145 +1,220 @@
 package inventory
 
 import (
+	"context"
 	"database/sql"
-	"log"
 	"time"
 )
 
 // FoundFile ...
 type FoundFile struct {
-	Source      string
-	Path        string
-	Md5hash     string
-	Name        string
-	Extension   string
-	Type        string
+	Source        string
+	Path          string
+	Status        string
+	Hash          string
+	HashAlgorithm string
+	// Hashes holds every digest computed for the file keyed by algorithm, including the primary Hash.
+	// It is only populated when saving, use Store.GetFoundFileHashes to load it.
+	Hashes    map[string]string
+	Name      string
+	Extension string
+	Type      string
+	// MIME is the media type detected from the contents, empty if it isn't known
+	MIME        string
 	Size        int64
 	Category    string
 	Subcategory string
 	Label       string
-	Tags        string
+	// Tags are the tags of the file at Path, sorted. Saving a file adds its tags to the ones the
+	// path already has, use Store.RemoveTags to remove them.
+	Tags        []string
 	Modified    time.Time
 	Discovered  time.Time
 	LastChecked time.Time
+	// LastVerified is when the contents were last rehashed and compared against Hash by verify
+	LastVerified time.Time
+	VerifyStatus string
+	// MissingSince is when the file was first noticed to no longer exist at Path
+	MissingSince time.Time
 }
 
-// CreateFoundFileTable ...
-func CreateFoundFileTable() {
-	const sql = `
-		CREATE TABLE if not exists found_files (
-			source TEXT NOT NULL,
-			path TEXT NOT NULL,
-			status TEXT NOT NULL DEFAULT '',
-			md5hash TEXT NOT NULL,
-			name TEXT NOT NULL,
-			size int NOT NULL,
-			modified TIMESTAMP NOT NULL,
-			extension TEXT NOT NULL,
-			type TEXT NOT NULL DEFAULT '',
-			category TEXT NOT NULL DEFAULT '',
-			subcategory TEXT NOT NULL DEFAULT '',
-			label TEXT NOT NULL DEFAULT '',
-			tags TEXT NOT NULL DEFAULT '',
-			notes TEXT NOT NULL DEFAULT '',
-			discovered TIMESTAMP NOT NULL,
-			last_checked TIMESTAMP NOT NULL,
-			unique(source, path, md5hash)
-	    )`
-	_, err := db.Exec(sql)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
+// Statuses of a found file. Only the current version of a file at a path has an empty status.
+const (
+	StatusCurrent  = ""
+	StatusReplaced = "replaced"
+	StatusMissing  = "missing"
+)
 
-// GetFoundFileWithMd5hash ...
-func GetFoundFileWithMd5hash(source string, path string, md5hash string) *FoundFile {
-	// FIXME: Not following go pattern, need to use interface
-	const sql = `
-		SELECT source, path, md5hash, name, size, modified, extension, type, category, subcategory, label, tags, discovered, last_checked
-		FROM found_files WHERE source = ? and path = ? and md5hash = ?`
-	rows, err := db.Query(sql, source, path, md5hash)
+// Verify statuses recorded by verify
+const (
+	VerifyOK      = "ok"
+	VerifyCorrupt = "corrupt"
+)
+
+// foundFileColumns are the columns of a found file, with its tags from file_tags. The tags column
+// of found_files is no longer used.
+const foundFileColumns = `source, path, status, hash, hash_algorithm, name, size, modified, extension, type, mime, category, subcategory, label,
+	(SELECT group_concat(tag) FROM file_tags WHERE file_tags.source = found_files.source and file_tags.path = found_files.path),
+	discovered, last_checked, last_verified, verify_status, missing_since`
+
+// queryFoundFiles returns the found files selected by query, which must select foundFileColumns
+func (s *SQLiteStore) queryFoundFiles(ctx context.Context, query string, args ...interface{}) ([]FoundFile, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 	defer rows.Close()
+	var ffs []FoundFile
 	for rows.Next() {
-		return toFoundFile(rows)
+		ff, err := toFoundFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		ffs = append(ffs, *ff)
 	}
-	return nil
+	return ffs, rows.Err()
+}
+
+// queryFoundFile returns the first found file selected by query, or nil if there are none
+func (s *SQLiteStore) queryFoundFile(ctx context.Context, query string, args ...interface{}) (*FoundFile, error) {
+	ffs, err := s.queryFoundFiles(ctx, query+" LIMIT 1", args...)
+	if err != nil || len(ffs) == 0 {
+		return nil, err
+	}
+	return &ffs[0], nil
+}
+
+// GetFoundFileWithHash ...
+func (s *SQLiteStore) GetFoundFileWithHash(ctx context.Context, source string, path string, hash string) (*FoundFile, error) {
+	const sql = `
+		SELECT ` + foundFileColumns + `
+		FROM found_files WHERE source = ? and path = ? and hash = ?`
+	return s.queryFoundFile(ctx, sql, source, path, hash)
+}
+
+// GetFoundFile returns the current version of the file at path
+func (s *SQLiteStore) GetFoundFile(ctx context.Context, source string, path string) (*FoundFile, error) {
+	const sql = `
+		SELECT ` + foundFileColumns + `
+		FROM found_files WHERE source = ? and path = ? and status = ''`
+	return s.queryFoundFile(ctx, sql, source, path)
 }
 
-// GetFoundFileOtherSourcesWithMd5hash ...
-func GetFoundFileOtherSourcesWithMd5hash(excludeSource string, md5hash string) []FoundFile {
+// GetFoundFileWithHashes returns the file at path with any of the given digests, keyed by algorithm
+func (s *SQLiteStore) GetFoundFileWithHashes(ctx context.Context, source string, path string, hashes map[string]string) (*FoundFile, error) {
 	const sql = `
-		SELECT source, path, md5hash, name, size, modified, extension, type, category, subcategory, label, tags, discovered, last_checked
-		FROM found_files WHERE source != ? and md5hash = ?`
-	rows, err := db.Query(sql, excludeSource, md5hash)
+		SELECT ` + foundFileColumns + `
+		FROM found_files WHERE source = ? and path = ? and hash IN (
+			SELECT hash FROM file_hashes WHERE source = ? and path = ? and algorithm = ? and digest = ?)`
+	for algorithm, digest := range hashes {
+		ff, err := s.queryFoundFile(ctx, sql, source, path, source, path, algorithm, digest)
+		if err != nil || ff != nil {
+			return ff, err
+		}
+	}
+	return nil, nil
+}
+
+// GetFoundFileHashes returns every digest stored for the file, keyed by algorithm
+func (s *SQLiteStore) GetFoundFileHashes(ctx context.Context, source string, path string, hash string) (map[string]string, error) {
+	const sql = `SELECT algorithm, digest FROM file_hashes WHERE source = ? and path = ? and hash = ?`
+	rows, err := s.db.QueryContext(ctx, sql, source, path, hash)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 	defer rows.Close()
-	var ffs []FoundFile
+	hashes := map[string]string{}
 	for rows.Next() {
-		ffs = append(ffs, *toFoundFile(rows))
+		var algorithm, digest string
+		if err := rows.Scan(&algorithm, &digest); err != nil {
+			return nil, err
+		}
+		hashes[algorithm] = digest
 	}
-	return ffs
+	return hashes, rows.Err()
+}
+
+// GetFoundFileOtherSourcesWithSameContent returns files in other sources sharing a digest
+// with the given file. Digests are only compared when computed with the same algorithm.
+func (s *SQLiteStore) GetFoundFileOtherSourcesWithSameContent(ctx context.Context, ff *FoundFile) ([]FoundFile, error) {
+	const sql = `
+		SELECT ` + foundFileColumns + `
+		FROM found_files WHERE source != ? and status = '' and (source, path, hash) IN (
+			SELECT other.source, other.path, other.hash
+			FROM file_hashes mine JOIN file_hashes other
+				ON other.algorithm = mine.algorithm and other.digest = mine.digest
+			WHERE mine.source = ? and mine.path = ? and mine.hash = ?)`
+	return s.queryFoundFiles(ctx, sql, ff.Source, ff.Source, ff.Path, ff.Hash)
 }
 
 // GetSimilarFoundFileSourcesWithSizeAndModified ...
-func GetSimilarFoundFileSourcesWithSizeAndModified(size int64, modified time.Time) []FoundFile {
+func (s *SQLiteStore) GetSimilarFoundFileSourcesWithSizeAndModified(ctx context.Context, size int64, modified time.Time) ([]FoundFile, error) {
 	const sql = `
-		SELECT source, path, md5hash, name, size, modified, extension, type, category, subcategory, label, tags, discovered, last_checked
-		FROM found_files WHERE size = ? and modified = ?`
-	rows, err := db.Query(sql, size, modified)
-	if err != nil {
-		log.Panic(err)
-	}
-	defer rows.Close()
-	var ffs []FoundFile
-	for rows.Next() {
-		ffs = append(ffs, *toFoundFile(rows))
-	}
-	return ffs
+		SELECT ` + foundFileColumns + `
+		FROM found_files WHERE size = ? and modified = ? and status = ''`
+	return s.queryFoundFiles(ctx, sql, size, modified)
 }
 
 // GetFoundFileWithSizeAndModified ...
-func GetFoundFileWithSizeAndModified(source string, path string, size int64, modified time.Time) *FoundFile {
-	// FIXME: Not following go pattern, need to use interface
+func (s *SQLiteStore) GetFoundFileWithSizeAndModified(ctx context.Context, source string, path string, size int64, modified time.Time) (*FoundFile, error) {
 	const sql = `
-		SELECT source, path, md5hash, name, size, modified, extension, type, category, subcategory, label, tags, discovered, last_checked
-		FROM found_files WHERE source = ? and path = ? and size = ? and modified = ?`
-	rows, err := db.Query(sql, source, path, size, modified)
-	if err != nil {
-		log.Panic(err)
-	}
-	defer rows.Close()
-	for rows.Next() {
-		return toFoundFile(rows)
-	}
-	return nil
+		SELECT ` + foundFileColumns + `
+		FROM found_files WHERE source = ? and path = ? and size = ? and modified = ? and status = ''`
+	return s.queryFoundFile(ctx, sql, source, path, size, modified)
 }
 
-func toFoundFile(rows *sql.Rows) *FoundFile {
-	var source, path, md5hash, name, extension, fileType, category, subcategory, label, tags string
+func toFoundFile(rows *sql.Rows) (*FoundFile, error) {
+	var source, path, status, hash, hashAlgorithm, name, extension, fileType, mimeType, category, subcategory, label, verifyStatus string
 	var modified, lastChecked, discovered time.Time
+	var lastVerified, missingSince sql.NullTime
+	var tags sql.NullString
 	var size int64
-	err := rows.Scan(&source, &path, &md5hash, &name, &size, &modified, &extension, &fileType, &category, &subcategory, &label, &tags, &discovered, &lastChecked)
+	err := rows.Scan(&source, &path, &status, &hash, &hashAlgorithm, &name, &size, &modified, &extension, &fileType, &mimeType, &category, &subcategory, &label, &tags, &discovered, &lastChecked, &lastVerified, &verifyStatus, &missingSince)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	return &FoundFile{Source: source, Path: path, Md5hash: md5hash, Name: name, Extension: extension, Type: fileType, Size: size, Modified: modified, Category: category, Subcategory: subcategory, Label: label, Tags: tags, Discovered: discovered, LastChecked: lastChecked}
+	return &FoundFile{Source: source, Path: path, Status: status, Hash: hash, HashAlgorithm: hashAlgorithm, Name: name, Extension: extension, Type: fileType, MIME: mimeType, Size: size, Modified: modified, Category: category, Subcategory: subcategory, Label: label, Tags: SplitTags(tags.String), Discovered: discovered, LastChecked: lastChecked, LastVerified: lastVerified.Time, VerifyStatus: verifyStatus, MissingSince: missingSince.Time}, nil
 }
 
 // Save ...
-func (ff *FoundFile) Save() {
+func (s *SQLiteStore) Save(ctx context.Context, ff *FoundFile) error {
+	return s.SaveAll(ctx, []*FoundFile{ff})
+}
+
+// SaveAll saves the files in a single transaction, which is much faster than saving them one at a time
+func (s *SQLiteStore) SaveAll(ctx context.Context, ffs []*FoundFile) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, ff := range ffs {
+		if err := saveFoundFile(ctx, tx, ff); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, ff := range ffs {
+		ff.Status = StatusCurrent
+		ff.MissingSince = time.Time{}
+	}
+	return nil
+}
+
+func saveFoundFile(ctx context.Context, tx *sql.Tx, ff *FoundFile) error {
 	// If the file changes, it is considered a different file, even if it is in the same path.
+	// The saved file becomes the current version, other versions at the path are marked as replaced.
 	const sql = `
-		INSERT INTO found_files (source, path, md5hash, name, extension, type, size, modified, discovered, last_checked, category, subcategory, label, tags)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-		ON CONFLICT (source, path, md5hash) DO UPDATE SET
+		INSERT INTO found_files (source, path, status, hash, hash_algorithm, name, extension, type, mime, size, modified, discovered, last_checked, category, subcategory, label)
+		VALUES (?, ?, '', ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (source, path, hash) DO UPDATE SET
+			status=excluded.status,
+			missing_since=NULL,
 			name=excluded.name,
 			type=excluded.type,
+			mime=excluded.mime,
 			extension=excluded.extension,
 			size=excluded.size,
 			modified=excluded.modified,
@@ -147,10 +222,105 @@ func (ff *FoundFile) Save() {
 			last_checked=excluded.last_checked,
 			category=excluded.category,
 			subcategory=excluded.subcategory,
-			label=excluded.label,
-			tags=excluded.tags`
-	_, err := db.Exec(sql, ff.Source, ff.Path, ff.Md5hash, ff.Name, ff.Extension, ff.Type, ff.Size, ff.Modified, ff.Discovered, ff.LastChecked, ff.Category, ff.Subcategory, ff.Label, ff.Tags)
+			label=excluded.label`
+	_, err := tx.ExecContext(ctx, sql, ff.Source, ff.Path, ff.Hash, ff.HashAlgorithm, ff.Name, ff.Extension, ff.Type, ff.MIME, ff.Size, ff.Modified, ff.Discovered, ff.LastChecked, ff.Category, ff.Subcategory, ff.Label)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
+	if err := addTags(ctx, tx, ff.Source, ff.Path, ff.Tags); err != nil {
+		return err
+	}
+	const replacedSQL = `UPDATE found_files SET status = 'replaced' WHERE source = ? and path = ? and hash != ? and status = ''`
+	if _, err := tx.ExecContext(ctx, replacedSQL, ff.Source, ff.Path, ff.Hash); err != nil {
+		return err
+	}
+	const versionSQL = `
+		INSERT INTO file_versions (source, path, hash, hash_algorithm, size, modified, first_seen, last_seen) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (source, path, hash, size, modified) DO UPDATE SET last_seen=excluded.last_seen`
+	if _, err := tx.ExecContext(ctx, versionSQL, ff.Source, ff.Path, ff.Hash, ff.HashAlgorithm, ff.Size, ff.Modified, ff.LastChecked, ff.LastChecked); err != nil {
+		return err
+	}
+	const hashSQL = `
+		INSERT INTO file_hashes (source, path, hash, algorithm, digest) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (source, path, hash, algorithm) DO UPDATE SET digest=excluded.digest`
+	hashes := map[string]string{ff.HashAlgorithm: ff.Hash}
+	for algorithm, digest := range ff.Hashes {
+		hashes[algorithm] = digest
+	}
+	for algorithm, digest := range hashes {
+		if _, err := tx.ExecContext(ctx, hashSQL, ff.Source, ff.Path, ff.Hash, algorithm, digest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveVerified records the result of rehashing the file and comparing it to its stored hash
+func (s *SQLiteStore) SaveVerified(ctx context.Context, ff *FoundFile, status string, verified time.Time) error {
+	const sql = `UPDATE found_files SET verify_status = ?, last_verified = ? WHERE source = ? and path = ? and hash = ?`
+	if _, err := s.db.ExecContext(ctx, sql, status, verified, ff.Source, ff.Path, ff.Hash); err != nil {
+		return err
+	}
+	ff.VerifyStatus = status
+	ff.LastVerified = verified
+	return nil
+}
+
+// SaveMissing marks the file as no longer existing at its path
+func (s *SQLiteStore) SaveMissing(ctx context.Context, ff *FoundFile, missingSince time.Time) error {
+	const sql = `UPDATE found_files SET status = 'missing', missing_since = ? WHERE source = ? and path = ? and hash = ?`
+	if _, err := s.db.ExecContext(ctx, sql, missingSince, ff.Source, ff.Path, ff.Hash); err != nil {
+		return err
+	}
+	ff.Status = StatusMissing
+	ff.MissingSince = missingSince
+	return nil
+}
+
+// SaveCategories stores the category, subcategory and label of each file in a single transaction
+func (s *SQLiteStore) SaveCategories(ctx context.Context, ffs []*FoundFile) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	const sql = `UPDATE found_files SET category = ?, subcategory = ?, label = ? WHERE source = ? and path = ? and hash = ?`
+	for _, ff := range ffs {
+		if _, err := tx.ExecContext(ctx, sql, ff.Category, ff.Subcategory, ff.Label, ff.Source, ff.Path, ff.Hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetFoundFilesUnderPath returns the current files at or below root
+func (s *SQLiteStore) GetFoundFilesUnderPath(ctx context.Context, source string, root string) ([]FoundFile, error) {
+	return s.getFoundFilesUnderPath(ctx, source, root, StatusCurrent)
+}
+
+// GetFoundFilesUnderPathAfter returns a page of the current files of source at or below root
+func (s *SQLiteStore) GetFoundFilesUnderPathAfter(ctx context.Context, source string, root string, after string, limit int) ([]FoundFile, error) {
+	const sql = `
+		SELECT ` + foundFileColumns + `
+		FROM found_files WHERE source = ? and status = ? and path > ?
+			and (path = ? or substr(path, 1, length(?)) = ?)
+		ORDER BY path LIMIT ?`
+	prefix := pathPrefix(root)
+	return s.queryFoundFiles(ctx, sql, source, StatusCurrent, after, root, prefix, prefix, limit)
+}
+
+// GetMissingFoundFilesUnderPath returns the files at or below root that no longer exist.
+// An empty source returns missing files from all sources.
+func (s *SQLiteStore) GetMissingFoundFilesUnderPath(ctx context.Context, source string, root string) ([]FoundFile, error) {
+	return s.getFoundFilesUnderPath(ctx, source, root, StatusMissing)
+}
+
+func (s *SQLiteStore) getFoundFilesUnderPath(ctx context.Context, source string, root string, status string) ([]FoundFile, error) {
+	const sql = `
+		SELECT ` + foundFileColumns + `
+		FROM found_files WHERE (? = '' or source = ?) and status = ?
+			and (path = ? or substr(path, 1, length(?)) = ?)
+		ORDER BY path`
+	prefix := pathPrefix(root)
+	return s.queryFoundFiles(ctx, sql, source, source, status, root, prefix, prefix)
 }
@@ -0,0 +1,179 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Statuses of an IndexRun
+const (
+	RunRunning     = "running"
+	RunInterrupted = "interrupted"
+	RunFailed      = "failed"
+	RunComplete    = "complete"
+)
+
+// IndexRun is a single run of the index command over root
+type IndexRun struct {
+	ID     int64
+	Source string
+	Root   string
+	// Flags are the command line flags of the run, JSON encoded
+	Flags    string
+	Status   string
+	Started  time.Time
+	Finished time.Time
+	// Checkpoint is the last file in walk order that was saved along with every file before it,
+	// resuming the run continues after it
+	Checkpoint  string
+	NumFound    int
+	NumSkipped  int
+	NumNew      int
+	NumPrevious int
+	NumErrors   int
+	NumMissing  int
+	SizeTotal   int64
+}
+
+// Kinds of IndexError
+const (
+	ErrorPermission = "permission denied"
+	ErrorVanished   = "vanished"
+	ErrorIO         = "io error"
+)
+
+// IndexError is a file or folder an index run couldn't read
+type IndexError struct {
+	RunID int64
+	// Op is what was being done when the error occurred, i.e. "read folder" or "hash"
+	Op       string
+	Source   string
+	Path     string
+	Kind     string
+	Message  string
+	Occurred time.Time
+}
+
+const indexRunColumns = `id, source, root, flags, status, started, finished, checkpoint,
+	num_found, num_skipped, num_new, num_previous, num_errors, num_missing, size_total`
+
+// CreateIndexRun records the start of run, setting its ID
+func (s *SQLiteStore) CreateIndexRun(ctx context.Context, run *IndexRun) error {
+	const sql = `INSERT INTO index_runs (source, root, flags, status, started) VALUES (?, ?, ?, ?, ?)`
+	res, err := s.db.ExecContext(ctx, sql, run.Source, run.Root, run.Flags, run.Status, run.Started)
+	if err != nil {
+		return err
+	}
+	run.ID, err = res.LastInsertId()
+	return err
+}
+
+// UpdateIndexRun records the status, checkpoint and counts of run
+func (s *SQLiteStore) UpdateIndexRun(ctx context.Context, run *IndexRun) error {
+	var finished sql.NullTime
+	if !run.Finished.IsZero() {
+		finished = sql.NullTime{Time: run.Finished, Valid: true}
+	}
+	const query = `
+		UPDATE index_runs SET status = ?, finished = ?, checkpoint = ?, num_found = ?, num_skipped = ?,
+			num_new = ?, num_previous = ?, num_errors = ?, num_missing = ?, size_total = ?
+		WHERE id = ?`
+	_, err := s.db.ExecContext(ctx, query, run.Status, finished, run.Checkpoint, run.NumFound, run.NumSkipped,
+		run.NumNew, run.NumPrevious, run.NumErrors, run.NumMissing, run.SizeTotal, run.ID)
+	return err
+}
+
+// GetResumableIndexRun returns the most recent incomplete run of source that hasn't been followed
+// by another run over the same root, or nil
+func (s *SQLiteStore) GetResumableIndexRun(ctx context.Context, source string) (*IndexRun, error) {
+	const sql = `
+		SELECT ` + indexRunColumns + ` FROM index_runs r
+		WHERE source = ? and status != 'complete' and NOT EXISTS (
+			SELECT 1 FROM index_runs later WHERE later.source = r.source and later.root = r.root and later.id > r.id)
+		ORDER BY id DESC LIMIT 1`
+	runs, err := s.queryIndexRuns(ctx, sql, source)
+	if err != nil || len(runs) == 0 {
+		return nil, err
+	}
+	return &runs[0], nil
+}
+
+// GetIndexRuns returns the runs of source, most recent first. An empty source returns runs of all sources.
+func (s *SQLiteStore) GetIndexRuns(ctx context.Context, source string) ([]IndexRun, error) {
+	const sql = `SELECT ` + indexRunColumns + ` FROM index_runs WHERE (? = '' or source = ?) ORDER BY id DESC`
+	return s.queryIndexRuns(ctx, sql, source, source)
+}
+
+func (s *SQLiteStore) queryIndexRuns(ctx context.Context, query string, args ...interface{}) ([]IndexRun, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var runs []IndexRun
+	for rows.Next() {
+		run, err := toIndexRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, *run)
+	}
+	return runs, rows.Err()
+}
+
+func toIndexRun(rows *sql.Rows) (*IndexRun, error) {
+	var run IndexRun
+	var finished sql.NullTime
+	err := rows.Scan(&run.ID, &run.Source, &run.Root, &run.Flags, &run.Status, &run.Started, &finished, &run.Checkpoint,
+		&run.NumFound, &run.NumSkipped, &run.NumNew, &run.NumPrevious, &run.NumErrors, &run.NumMissing, &run.SizeTotal)
+	if err != nil {
+		return nil, err
+	}
+	run.Finished = finished.Time
+	return &run, nil
+}
+
+// SaveIndexError records a file or folder the run couldn't read
+func (s *SQLiteStore) SaveIndexError(ctx context.Context, e *IndexError) error {
+	const sql = `
+		INSERT INTO index_errors (run_id, source, path, op, kind, message, occurred)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`
+	_, err := s.db.ExecContext(ctx, sql, e.RunID, e.Source, e.Path, e.Op, e.Kind, e.Message, e.Occurred)
+	return err
+}
+
+// GetIndexErrorsUnderPath returns the errors at or below root from the latest run of each source
+// over each root, ordered by path. An empty source returns errors from all sources.
+func (s *SQLiteStore) GetIndexErrorsUnderPath(ctx context.Context, source string, root string) ([]IndexError, error) {
+	const sql = `
+		SELECT run_id, source, path, op, kind, message, occurred
+		FROM index_errors WHERE (? = '' or source = ?)
+			and run_id IN (SELECT max(id) FROM index_runs GROUP BY source, root)
+			and (path = ? or substr(path, 1, length(?)) = ?)
+		ORDER BY path, source`
+	prefix := pathPrefix(root)
+	rows, err := s.db.QueryContext(ctx, sql, source, source, root, prefix, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var es []IndexError
+	for rows.Next() {
+		e, err := toIndexError(rows)
+		if err != nil {
+			return nil, err
+		}
+		es = append(es, *e)
+	}
+	return es, rows.Err()
+}
+
+func toIndexError(rows *sql.Rows) (*IndexError, error) {
+	var e IndexError
+	err := rows.Scan(&e.RunID, &e.Source, &e.Path, &e.Op, &e.Kind, &e.Message, &e.Occurred)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
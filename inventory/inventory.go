@@ -1,34 +1,73 @@
 package inventory
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
-var db *sql.DB
+// SQLiteStore is a Store backed by an SQLite database
+type SQLiteStore struct {
+	db   *sql.DB
+	path string
+}
+
+// Init opens the inventory database at path, applying any pending migrations.
+// An empty path defaults to $HOMEDIR/index.db.
+func Init(ctx context.Context, path string) (*SQLiteStore, error) {
+	s, err := Open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.Migrate(ctx); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("opening %s: %w", s.path, err)
+	}
+	return s, nil
+}
 
-// Init ...
-func Init(path string) {
+// Open opens the inventory database at path without migrating it.
+// An empty path defaults to $HOMEDIR/index.db.
+func Open(ctx context.Context, path string) (*SQLiteStore, error) {
 	if path == "" {
 		homeDir, err := os.UserHomeDir()
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 		path = filepath.Join(homeDir, "index.db")
 	}
-	var err error
-	db, err = sql.Open("sqlite3", path)
+	// WAL lets readers continue while a long index run is writing, and the busy timeout
+	// waits for other writers instead of failing immediately with "database is locked"
+	dsn := path + "?_journal_mode=WAL&_busy_timeout=5000"
+	if strings.Contains(path, "?") {
+		dsn = path + "&_journal_mode=WAL&_busy_timeout=5000"
+	}
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	CreateFoundFileTable()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	return &SQLiteStore{db: db, path: path}, nil
+}
+
+// Path returns the path of the database file
+func (s *SQLiteStore) Path() string {
+	return s.path
 }
 
 // Close ...
-func Close() {
-	if db != nil {
-		db.Close()
-	}
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStore) tableExists(ctx context.Context, name string) (bool, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, "SELECT count(*) FROM sqlite_master WHERE type = 'table' and name = ?", name).Scan(&n)
+	return n > 0, err
 }
@@ -0,0 +1,772 @@
+package inventory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type fileKey struct {
+	source string
+	path   string
+	hash   string
+}
+
+type pathKey struct {
+	source string
+	path   string
+}
+
+// MemoryStore is a Store that keeps everything in memory, it is mainly useful for tests
+type MemoryStore struct {
+	mu       sync.Mutex
+	files    map[fileKey]*FoundFile
+	hashes   map[fileKey]map[string]string
+	tags     map[pathKey][]string
+	versions []FileVersion
+	runs     []IndexRun
+	errors   []IndexError
+	sources  map[string]Source
+	// lastRunID is the ID of the latest run created, IDs aren't reused after removing a source
+	lastRunID int64
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		files:   map[fileKey]*FoundFile{},
+		hashes:  map[fileKey]map[string]string{},
+		tags:    map[pathKey][]string{},
+		sources: map[string]Source{},
+	}
+}
+
+func keyOf(ff *FoundFile) fileKey {
+	return fileKey{ff.Source, ff.Path, ff.Hash}
+}
+
+// find returns copies of the files matching match, ordered by source and path
+func (m *MemoryStore) find(match func(ff *FoundFile) bool) []FoundFile {
+	var ffs []FoundFile
+	for _, ff := range m.files {
+		c := *ff
+		c.Hashes = nil
+		c.Tags = m.tags[pathKey{ff.Source, ff.Path}]
+		if match(&c) {
+			ffs = append(ffs, c)
+		}
+	}
+	sort.Slice(ffs, func(i, j int) bool {
+		if ffs[i].Source != ffs[j].Source {
+			return ffs[i].Source < ffs[j].Source
+		}
+		if ffs[i].Path != ffs[j].Path {
+			return ffs[i].Path < ffs[j].Path
+		}
+		return ffs[i].Hash < ffs[j].Hash
+	})
+	return ffs
+}
+
+func (m *MemoryStore) findOne(match func(ff *FoundFile) bool) *FoundFile {
+	ffs := m.find(match)
+	if len(ffs) == 0 {
+		return nil
+	}
+	return &ffs[0]
+}
+
+// GetFoundFile ...
+func (m *MemoryStore) GetFoundFile(ctx context.Context, source string, path string) (*FoundFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.findOne(func(ff *FoundFile) bool {
+		return ff.Source == source && ff.Path == path && ff.Status == StatusCurrent
+	}), nil
+}
+
+// GetFoundFileWithHash ...
+func (m *MemoryStore) GetFoundFileWithHash(ctx context.Context, source string, path string, hash string) (*FoundFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.findOne(func(ff *FoundFile) bool {
+		return ff.Source == source && ff.Path == path && ff.Hash == hash
+	}), nil
+}
+
+// GetFoundFileWithHashes ...
+func (m *MemoryStore) GetFoundFileWithHashes(ctx context.Context, source string, path string, hashes map[string]string) (*FoundFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.findOne(func(ff *FoundFile) bool {
+		if ff.Source != source || ff.Path != path {
+			return false
+		}
+		for algorithm, digest := range m.hashes[keyOf(ff)] {
+			if hashes[algorithm] == digest {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+// GetFoundFileHashes ...
+func (m *MemoryStore) GetFoundFileHashes(ctx context.Context, source string, path string, hash string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hashes := map[string]string{}
+	for algorithm, digest := range m.hashes[fileKey{source, path, hash}] {
+		hashes[algorithm] = digest
+	}
+	return hashes, nil
+}
+
+// FindFoundFiles ...
+func (m *MemoryStore) FindFoundFiles(ctx context.Context, q FileQuery) ([]FoundFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ffs := m.find(func(ff *FoundFile) bool {
+		return q.match(ff, m.hashes[keyOf(ff)], func() int { return m.copies(ff) })
+	})
+	if q.Limit > 0 && len(ffs) > q.Limit {
+		ffs = ffs[:q.Limit]
+	}
+	return ffs, nil
+}
+
+// GetFoundFileWithSizeAndModified ...
+func (m *MemoryStore) GetFoundFileWithSizeAndModified(ctx context.Context, source string, path string, size int64, modified time.Time) (*FoundFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.findOne(func(ff *FoundFile) bool {
+		return ff.Source == source && ff.Path == path && ff.Size == size && ff.Modified.Equal(modified) && ff.Status == StatusCurrent
+	}), nil
+}
+
+// copies counts the sources with a current file with the content of mine, its own included
+func (m *MemoryStore) copies(mine *FoundFile) int {
+	mineHashes := m.hashes[keyOf(mine)]
+	sources := map[string]bool{mine.Source: true}
+	for _, ff := range m.files {
+		if ff.Status != StatusCurrent || sources[ff.Source] {
+			continue
+		}
+		for algorithm, digest := range m.hashes[keyOf(ff)] {
+			if d, ok := mineHashes[algorithm]; ok && d == digest {
+				sources[ff.Source] = true
+				break
+			}
+		}
+	}
+	return len(sources)
+}
+
+// GetFoundFileOtherSourcesWithSameContent ...
+func (m *MemoryStore) GetFoundFileOtherSourcesWithSameContent(ctx context.Context, mine *FoundFile) ([]FoundFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mineHashes := m.hashes[keyOf(mine)]
+	return m.find(func(ff *FoundFile) bool {
+		if ff.Source == mine.Source || ff.Status != StatusCurrent {
+			return false
+		}
+		for algorithm, digest := range m.hashes[keyOf(ff)] {
+			if d, ok := mineHashes[algorithm]; ok && d == digest {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+// GetSimilarFoundFileSourcesWithSizeAndModified ...
+func (m *MemoryStore) GetSimilarFoundFileSourcesWithSizeAndModified(ctx context.Context, size int64, modified time.Time) ([]FoundFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.find(func(ff *FoundFile) bool {
+		return ff.Size == size && ff.Modified.Equal(modified) && ff.Status == StatusCurrent
+	}), nil
+}
+
+// GetFoundFilesUnderPath ...
+func (m *MemoryStore) GetFoundFilesUnderPath(ctx context.Context, source string, root string) ([]FoundFile, error) {
+	return m.getFoundFilesUnderPath(source, root, StatusCurrent), nil
+}
+
+// GetFoundFilesUnderPathAfter ...
+func (m *MemoryStore) GetFoundFilesUnderPathAfter(ctx context.Context, source string, root string, after string, limit int) ([]FoundFile, error) {
+	var ffs []FoundFile
+	for _, ff := range m.getFoundFilesUnderPath(source, root, StatusCurrent) {
+		if ff.Path > after && len(ffs) < limit {
+			ffs = append(ffs, ff)
+		}
+	}
+	return ffs, nil
+}
+
+// GetMissingFoundFilesUnderPath ...
+func (m *MemoryStore) GetMissingFoundFilesUnderPath(ctx context.Context, source string, root string) ([]FoundFile, error) {
+	return m.getFoundFilesUnderPath(source, root, StatusMissing), nil
+}
+
+func (m *MemoryStore) getFoundFilesUnderPath(source string, root string, status string) []FoundFile {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	prefix := pathPrefix(root)
+	ffs := m.find(func(ff *FoundFile) bool {
+		return (source == "" || ff.Source == source) && ff.Status == status && (ff.Path == root || strings.HasPrefix(ff.Path, prefix))
+	})
+	sort.SliceStable(ffs, func(i, j int) bool {
+		return ffs[i].Path < ffs[j].Path
+	})
+	return ffs
+}
+
+// GetDuplicateGroups ...
+func (m *MemoryStore) GetDuplicateGroups(ctx context.Context, source string, minSize int64, fileType string) ([]DuplicateGroup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ffs := m.find(func(ff *FoundFile) bool {
+		return ff.Status == StatusCurrent && (source == "" || ff.Source == source) && ff.Size >= minSize && (fileType == "" || ff.Type == fileType)
+	})
+	sort.SliceStable(ffs, func(i, j int) bool {
+		if ffs[i].HashAlgorithm != ffs[j].HashAlgorithm {
+			return ffs[i].HashAlgorithm < ffs[j].HashAlgorithm
+		}
+		return ffs[i].Hash < ffs[j].Hash
+	})
+	var groups []DuplicateGroup
+	for _, ff := range ffs {
+		n := len(groups)
+		if n == 0 || groups[n-1].HashAlgorithm != ff.HashAlgorithm || groups[n-1].Hash != ff.Hash {
+			groups = append(groups, DuplicateGroup{HashAlgorithm: ff.HashAlgorithm, Hash: ff.Hash, Size: ff.Size})
+			n++
+		}
+		groups[n-1].Files = append(groups[n-1].Files, ff)
+	}
+	var dupes []DuplicateGroup
+	for _, g := range groups {
+		if len(g.Files) > 1 {
+			dupes = append(dupes, g)
+		}
+	}
+	return dupes, nil
+}
+
+// GetFileVersions ...
+func (m *MemoryStore) GetFileVersions(ctx context.Context, source string, path string) ([]FileVersion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var fvs []FileVersion
+	for _, fv := range m.versions {
+		if (source == "" || fv.Source == source) && fv.Path == path {
+			fvs = append(fvs, fv)
+		}
+	}
+	sort.SliceStable(fvs, func(i, j int) bool {
+		if fvs[i].Source != fvs[j].Source {
+			return fvs[i].Source < fvs[j].Source
+		}
+		return fvs[i].FirstSeen.Before(fvs[j].FirstSeen)
+	})
+	return fvs, nil
+}
+
+// Save ...
+func (m *MemoryStore) Save(ctx context.Context, ff *FoundFile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := keyOf(ff)
+	saved := *ff
+	saved.Status = StatusCurrent
+	saved.MissingSince = time.Time{}
+	saved.Hashes = nil
+	saved.Tags = nil
+	if len(ff.Tags) > 0 {
+		m.tags[pathKey{ff.Source, ff.Path}] = MergeTags(m.tags[pathKey{ff.Source, ff.Path}], ff.Tags)
+	}
+	if previous, ok := m.files[key]; ok {
+		saved.LastVerified = previous.LastVerified
+		saved.VerifyStatus = previous.VerifyStatus
+	}
+	m.files[key] = &saved
+	for k, other := range m.files {
+		if k.source == key.source && k.path == key.path && k.hash != key.hash && other.Status == StatusCurrent {
+			other.Status = StatusReplaced
+		}
+	}
+
+	found := false
+	for i := range m.versions {
+		fv := &m.versions[i]
+		if fv.Source == ff.Source && fv.Path == ff.Path && fv.Hash == ff.Hash && fv.Size == ff.Size && fv.Modified.Equal(ff.Modified) {
+			fv.LastSeen = ff.LastChecked
+			found = true
+		}
+	}
+	if !found {
+		m.versions = append(m.versions, FileVersion{Source: ff.Source, Path: ff.Path, Hash: ff.Hash, HashAlgorithm: ff.HashAlgorithm, Size: ff.Size, Modified: ff.Modified, FirstSeen: ff.LastChecked, LastSeen: ff.LastChecked})
+	}
+
+	hashes, ok := m.hashes[key]
+	if !ok {
+		hashes = map[string]string{}
+		m.hashes[key] = hashes
+	}
+	hashes[ff.HashAlgorithm] = ff.Hash
+	for algorithm, digest := range ff.Hashes {
+		hashes[algorithm] = digest
+	}
+	ff.Status = StatusCurrent
+	ff.MissingSince = time.Time{}
+	return nil
+}
+
+// SaveAll ...
+func (m *MemoryStore) SaveAll(ctx context.Context, ffs []*FoundFile) error {
+	for _, ff := range ffs {
+		if err := m.Save(ctx, ff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveVerified ...
+func (m *MemoryStore) SaveVerified(ctx context.Context, ff *FoundFile, status string, verified time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if saved, ok := m.files[keyOf(ff)]; ok {
+		saved.VerifyStatus = status
+		saved.LastVerified = verified
+	}
+	ff.VerifyStatus = status
+	ff.LastVerified = verified
+	return nil
+}
+
+// SaveMissing ...
+func (m *MemoryStore) SaveMissing(ctx context.Context, ff *FoundFile, missingSince time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if saved, ok := m.files[keyOf(ff)]; ok {
+		saved.Status = StatusMissing
+		saved.MissingSince = missingSince
+	}
+	ff.Status = StatusMissing
+	ff.MissingSince = missingSince
+	return nil
+}
+
+// SaveCategories ...
+func (m *MemoryStore) SaveCategories(ctx context.Context, ffs []*FoundFile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ff := range ffs {
+		if saved, ok := m.files[keyOf(ff)]; ok {
+			saved.Category = ff.Category
+			saved.Subcategory = ff.Subcategory
+			saved.Label = ff.Label
+		}
+	}
+	return nil
+}
+
+// GetTags ...
+func (m *MemoryStore) GetTags(ctx context.Context, source string) ([]Tag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := map[string]int{}
+	for _, ff := range m.files {
+		if ff.Status == StatusCurrent && (source == "" || ff.Source == source) {
+			for _, tag := range m.tags[pathKey{ff.Source, ff.Path}] {
+				counts[tag]++
+			}
+		}
+	}
+	var tags []Tag
+	for name, n := range counts {
+		tags = append(tags, Tag{Name: name, NumFiles: n})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Name < tags[j].Name
+	})
+	return tags, nil
+}
+
+// AddTags ...
+func (m *MemoryStore) AddTags(ctx context.Context, ffs []*FoundFile, tags []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ff := range ffs {
+		key := pathKey{ff.Source, ff.Path}
+		m.tags[key] = MergeTags(m.tags[key], tags)
+		ff.Tags = MergeTags(ff.Tags, tags)
+	}
+	return nil
+}
+
+// RemoveTags ...
+func (m *MemoryStore) RemoveTags(ctx context.Context, ffs []*FoundFile, tags []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ff := range ffs {
+		key := pathKey{ff.Source, ff.Path}
+		if m.tags[key] = removeTags(m.tags[key], tags); len(m.tags[key]) == 0 {
+			delete(m.tags, key)
+		}
+		ff.Tags = removeTags(ff.Tags, tags)
+	}
+	return nil
+}
+
+// CreateIndexRun ...
+func (m *MemoryStore) CreateIndexRun(ctx context.Context, run *IndexRun) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastRunID++
+	run.ID = m.lastRunID
+	m.runs = append(m.runs, *run)
+	return nil
+}
+
+// UpdateIndexRun ...
+func (m *MemoryStore) UpdateIndexRun(ctx context.Context, run *IndexRun) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.runs {
+		if m.runs[i].ID == run.ID {
+			saved := *run
+			saved.Source = m.runs[i].Source
+			saved.Root = m.runs[i].Root
+			saved.Flags = m.runs[i].Flags
+			saved.Started = m.runs[i].Started
+			m.runs[i] = saved
+		}
+	}
+	return nil
+}
+
+// GetIndexRuns ...
+func (m *MemoryStore) GetIndexRuns(ctx context.Context, source string) ([]IndexRun, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var runs []IndexRun
+	for i := len(m.runs) - 1; i >= 0; i-- {
+		if source == "" || m.runs[i].Source == source {
+			runs = append(runs, m.runs[i])
+		}
+	}
+	return runs, nil
+}
+
+// GetResumableIndexRun ...
+func (m *MemoryStore) GetResumableIndexRun(ctx context.Context, source string) (*IndexRun, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	later := map[string]bool{}
+	for i := len(m.runs) - 1; i >= 0; i-- {
+		run := m.runs[i]
+		if run.Source != source {
+			continue
+		}
+		if !later[run.Root] && run.Status != RunComplete {
+			return &run, nil
+		}
+		later[run.Root] = true
+	}
+	return nil, nil
+}
+
+// SaveIndexError ...
+func (m *MemoryStore) SaveIndexError(ctx context.Context, e *IndexError) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors = append(m.errors, *e)
+	return nil
+}
+
+// GetIndexErrorsUnderPath ...
+func (m *MemoryStore) GetIndexErrorsUnderPath(ctx context.Context, source string, root string) ([]IndexError, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	type runKey struct{ source, root string }
+	latest := map[runKey]int64{}
+	for _, run := range m.runs {
+		k := runKey{run.Source, run.Root}
+		if run.ID > latest[k] {
+			latest[k] = run.ID
+		}
+	}
+	latestIDs := map[int64]bool{}
+	for _, id := range latest {
+		latestIDs[id] = true
+	}
+	prefix := pathPrefix(root)
+	var es []IndexError
+	for _, e := range m.errors {
+		if (source == "" || e.Source == source) && latestIDs[e.RunID] && (e.Path == root || strings.HasPrefix(e.Path, prefix)) {
+			es = append(es, e)
+		}
+	}
+	sort.SliceStable(es, func(i, j int) bool {
+		if es[i].Path != es[j].Path {
+			return es[i].Path < es[j].Path
+		}
+		return es[i].Source < es[j].Source
+	})
+	return es, nil
+}
+
+// GetSources ...
+func (m *MemoryStore) GetSources(ctx context.Context) ([]Source, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var srcs []Source
+	for name := range m.sources {
+		srcs = append(srcs, m.source(name))
+	}
+	sort.Slice(srcs, func(i, j int) bool { return srcs[i].Name < srcs[j].Name })
+	return srcs, nil
+}
+
+// GetSource ...
+func (m *MemoryStore) GetSource(ctx context.Context, name string) (*Source, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sources[name]; !ok {
+		return nil, nil
+	}
+	src := m.source(name)
+	return &src, nil
+}
+
+// source returns the registered source with its computed fields
+func (m *MemoryStore) source(name string) Source {
+	src := m.sources[name]
+	var latest int64
+	for _, run := range m.runs {
+		if run.Source == name && run.Status == RunComplete && run.ID > latest {
+			latest = run.ID
+			src.LastIndexed = run.Finished
+		}
+	}
+	for _, ff := range m.files {
+		if ff.Source == name && ff.Status == StatusCurrent {
+			src.NumFiles++
+			src.SizeTotal += ff.Size
+		}
+	}
+	return src
+}
+
+// AddSource ...
+func (m *MemoryStore) AddSource(ctx context.Context, src *Source) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sources[src.Name]; ok {
+		return ErrSourceExists
+	}
+	m.sources[src.Name] = Source{Name: src.Name, Description: src.Description, Kind: src.Kind, Root: src.Root,
+		VolumeUUID: src.VolumeUUID, VolumeLabel: src.VolumeLabel, Created: src.Created}
+	return nil
+}
+
+// UpdateSource ...
+func (m *MemoryStore) UpdateSource(ctx context.Context, src *Source) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	saved, ok := m.sources[src.Name]
+	if !ok {
+		return ErrSourceNotFound
+	}
+	if saved.Root == "" && src.Root != "" {
+		if err := m.rebase(src.Name, src.Root); err != nil {
+			return err
+		}
+	}
+	saved.Description = src.Description
+	saved.Kind = src.Kind
+	saved.Root = src.Root
+	saved.VolumeUUID = src.VolumeUUID
+	saved.VolumeLabel = src.VolumeLabel
+	m.sources[src.Name] = saved
+	return nil
+}
+
+// rebase rewrites the paths of source, relative to /, relative to root
+func (m *MemoryStore) rebase(source string, root string) error {
+	rebased := func(path *string) error {
+		p, err := rebasePath(*path, root)
+		*path = p
+		return err
+	}
+	files := map[fileKey]*FoundFile{}
+	hashes := map[fileKey]map[string]string{}
+	for key, ff := range m.files {
+		if key.source != source {
+			continue
+		}
+		delete(m.files, key)
+		if err := rebased(&ff.Path); err != nil {
+			return err
+		}
+		files[keyOf(ff)] = ff
+		if h, ok := m.hashes[key]; ok {
+			delete(m.hashes, key)
+			hashes[keyOf(ff)] = h
+		}
+	}
+	for key, ff := range files {
+		m.files[key] = ff
+	}
+	for key, h := range hashes {
+		m.hashes[key] = h
+	}
+	tags := map[pathKey][]string{}
+	for key, t := range m.tags {
+		if key.source == source {
+			delete(m.tags, key)
+			if err := rebased(&key.path); err != nil {
+				return err
+			}
+			tags[key] = t
+		}
+	}
+	for key, t := range tags {
+		m.tags[key] = t
+	}
+	for i := range m.versions {
+		if m.versions[i].Source == source {
+			if err := rebased(&m.versions[i].Path); err != nil {
+				return err
+			}
+		}
+	}
+	for i := range m.runs {
+		if m.runs[i].Source == source {
+			if err := rebased(&m.runs[i].Root); err != nil {
+				return err
+			}
+			if err := rebased(&m.runs[i].Checkpoint); err != nil {
+				return err
+			}
+		}
+	}
+	for i := range m.errors {
+		if m.errors[i].Source == source {
+			if err := rebased(&m.errors[i].Path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RenameSource ...
+func (m *MemoryStore) RenameSource(ctx context.Context, name string, newName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sources[newName]; ok {
+		return ErrSourceExists
+	}
+	src, ok := m.sources[name]
+	if !ok {
+		return ErrSourceNotFound
+	}
+	delete(m.sources, name)
+	src.Name = newName
+	m.sources[newName] = src
+	for key, ff := range m.files {
+		if key.source == name {
+			delete(m.files, key)
+			ff.Source = newName
+			m.files[keyOf(ff)] = ff
+		}
+	}
+	for key, hashes := range m.hashes {
+		if key.source == name {
+			delete(m.hashes, key)
+			key.source = newName
+			m.hashes[key] = hashes
+		}
+	}
+	for key, tags := range m.tags {
+		if key.source == name {
+			delete(m.tags, key)
+			key.source = newName
+			m.tags[key] = tags
+		}
+	}
+	for i := range m.versions {
+		if m.versions[i].Source == name {
+			m.versions[i].Source = newName
+		}
+	}
+	for i := range m.runs {
+		if m.runs[i].Source == name {
+			m.runs[i].Source = newName
+		}
+	}
+	for i := range m.errors {
+		if m.errors[i].Source == name {
+			m.errors[i].Source = newName
+		}
+	}
+	return nil
+}
+
+// RemoveSource ...
+func (m *MemoryStore) RemoveSource(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sources[name]; !ok {
+		return ErrSourceNotFound
+	}
+	delete(m.sources, name)
+	for key := range m.files {
+		if key.source == name {
+			delete(m.files, key)
+		}
+	}
+	for key := range m.hashes {
+		if key.source == name {
+			delete(m.hashes, key)
+		}
+	}
+	for key := range m.tags {
+		if key.source == name {
+			delete(m.tags, key)
+		}
+	}
+	var versions []FileVersion
+	for _, fv := range m.versions {
+		if fv.Source != name {
+			versions = append(versions, fv)
+		}
+	}
+	m.versions = versions
+	var runs []IndexRun
+	for _, run := range m.runs {
+		if run.Source != name {
+			runs = append(runs, run)
+		}
+	}
+	m.runs = runs
+	var errors []IndexError
+	for _, e := range m.errors {
+		if e.Source != name {
+			errors = append(errors, e)
+		}
+	}
+	m.errors = errors
+	return nil
+}
+
+// Close ...
+func (m *MemoryStore) Close() error {
+	return nil
+}
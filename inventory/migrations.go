@@ -0,0 +1,470 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// ErrSchemaTooNew is returned when opening a database migrated by a newer version of fileinventory
+var ErrSchemaTooNew = errors.New("database schema is newer than this version of fileinventory supports")
+
+type migration struct {
+	name  string
+	stmts []string
+	// fn, if set, runs after stmts for changes that can't be made in SQL alone
+	fn func(ctx context.Context, tx *sql.Tx) error
+}
+
+// migrations upgrade the schema from one version to the next. The schema version of a database is
+// the number of migrations applied to it. Never edit or reorder a migration once released, add a new one.
+var migrations = []migration{
+	{"create found_files", []string{`
+		CREATE TABLE if not exists found_files (
+			source TEXT NOT NULL,
+			path TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT '',
+			md5hash TEXT NOT NULL,
+			name TEXT NOT NULL,
+			size int NOT NULL,
+			modified TIMESTAMP NOT NULL,
+			extension TEXT NOT NULL,
+			type TEXT NOT NULL DEFAULT '',
+			category TEXT NOT NULL DEFAULT '',
+			subcategory TEXT NOT NULL DEFAULT '',
+			label TEXT NOT NULL DEFAULT '',
+			tags TEXT NOT NULL DEFAULT '',
+			notes TEXT NOT NULL DEFAULT '',
+			discovered TIMESTAMP NOT NULL,
+			last_checked TIMESTAMP NOT NULL,
+			unique(source, path, md5hash)
+	    )`,
+	}, nil},
+	{"support hash algorithms other than md5", []string{
+		// hash is the digest computed with hash_algorithm, it identifies the content of the file
+		"ALTER TABLE found_files RENAME COLUMN md5hash TO hash",
+		"ALTER TABLE found_files ADD COLUMN hash_algorithm TEXT NOT NULL DEFAULT 'md5'",
+		// file_hashes holds every digest computed for a found file
+		`CREATE TABLE file_hashes (
+			source TEXT NOT NULL,
+			path TEXT NOT NULL,
+			hash TEXT NOT NULL,
+			algorithm TEXT NOT NULL,
+			digest TEXT NOT NULL,
+			unique(source, path, hash, algorithm)
+	    )`,
+		`INSERT INTO file_hashes (source, path, hash, algorithm, digest)
+			SELECT source, path, hash, hash_algorithm, hash FROM found_files`,
+	}, nil},
+	{"record verification results", []string{
+		"ALTER TABLE found_files ADD COLUMN last_verified TIMESTAMP",
+		"ALTER TABLE found_files ADD COLUMN verify_status TEXT NOT NULL DEFAULT ''",
+	}, nil},
+	{"record file versions", []string{`
+		CREATE TABLE file_versions (
+			source TEXT NOT NULL,
+			path TEXT NOT NULL,
+			hash TEXT NOT NULL,
+			hash_algorithm TEXT NOT NULL,
+			size int NOT NULL,
+			modified TIMESTAMP NOT NULL,
+			first_seen TIMESTAMP NOT NULL,
+			last_seen TIMESTAMP NOT NULL,
+			unique(source, path, hash, size, modified)
+	    )`,
+		`INSERT INTO file_versions (source, path, hash, hash_algorithm, size, modified, first_seen, last_seen)
+			SELECT source, path, hash, hash_algorithm, size, modified, discovered, last_checked FROM found_files`,
+		// Only the most recently checked row at each path is the current version
+		`UPDATE found_files SET status = 'replaced' WHERE status = '' and EXISTS (
+			SELECT 1 FROM found_files newer
+			WHERE newer.source = found_files.source and newer.path = found_files.path and newer.last_checked > found_files.last_checked)`,
+	}, nil},
+	{"record missing files", []string{
+		"ALTER TABLE found_files ADD COLUMN missing_since TIMESTAMP",
+	}, nil},
+	{"index lookups by content and by size and modified time", []string{
+		"CREATE INDEX found_files_hash ON found_files (hash_algorithm, hash)",
+		"CREATE INDEX found_files_size_modified ON found_files (size, modified)",
+		"CREATE INDEX file_hashes_digest ON file_hashes (algorithm, digest)",
+	}, nil},
+	{"record index runs and the files they couldn't read", []string{`
+		CREATE TABLE index_runs (
+			id INTEGER PRIMARY KEY,
+			source TEXT NOT NULL,
+			root TEXT NOT NULL,
+			started TIMESTAMP NOT NULL,
+			finished TIMESTAMP
+	    )`, `
+		CREATE TABLE index_errors (
+			run_id int NOT NULL REFERENCES index_runs (id),
+			source TEXT NOT NULL,
+			path TEXT NOT NULL,
+			op TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			message TEXT NOT NULL,
+			occurred TIMESTAMP NOT NULL
+	    )`,
+		"CREATE INDEX index_errors_run ON index_errors (run_id)",
+	}, nil},
+	{"record index run flags, status, checkpoint and counts", []string{
+		"ALTER TABLE index_runs ADD COLUMN flags TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE index_runs ADD COLUMN status TEXT NOT NULL DEFAULT 'running'",
+		// checkpoint is the last file in walk order saved along with every file before it
+		"ALTER TABLE index_runs ADD COLUMN checkpoint TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE index_runs ADD COLUMN num_found int NOT NULL DEFAULT 0",
+		"ALTER TABLE index_runs ADD COLUMN num_skipped int NOT NULL DEFAULT 0",
+		"ALTER TABLE index_runs ADD COLUMN num_new int NOT NULL DEFAULT 0",
+		"ALTER TABLE index_runs ADD COLUMN num_previous int NOT NULL DEFAULT 0",
+		"ALTER TABLE index_runs ADD COLUMN num_errors int NOT NULL DEFAULT 0",
+		"ALTER TABLE index_runs ADD COLUMN num_missing int NOT NULL DEFAULT 0",
+		"ALTER TABLE index_runs ADD COLUMN size_total int NOT NULL DEFAULT 0",
+		`UPDATE index_runs SET status = CASE WHEN finished IS NULL THEN 'interrupted' ELSE 'complete' END`,
+		`UPDATE index_runs SET num_errors = (SELECT count(*) FROM index_errors WHERE run_id = index_runs.id)`,
+	}, nil},
+	{"register sources", []string{`
+		CREATE TABLE sources (
+			name TEXT PRIMARY KEY,
+			description TEXT NOT NULL DEFAULT '',
+			kind TEXT NOT NULL DEFAULT '',
+			root TEXT NOT NULL DEFAULT '',
+			created TIMESTAMP NOT NULL
+	    )`,
+		// Register every source already used, as it was first seen
+		`INSERT INTO sources (name, created) SELECT source, min(discovered) FROM found_files GROUP BY source`,
+		`INSERT OR IGNORE INTO sources (name, created) SELECT source, min(started) FROM index_runs GROUP BY source`,
+	}, nil},
+	{"store paths relative to the source root", nil, relativizePaths},
+	{"record the volume of sources", []string{
+		"ALTER TABLE sources ADD COLUMN volume_uuid TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE sources ADD COLUMN volume_label TEXT NOT NULL DEFAULT ''",
+	}, nil},
+	{"tag files in their own table", []string{
+		// Tags belong to the path, so they stay with a file as it changes
+		`CREATE TABLE file_tags (
+			source TEXT NOT NULL,
+			path TEXT NOT NULL,
+			tag TEXT NOT NULL,
+			unique(source, path, tag)
+	    )`,
+		"CREATE INDEX file_tags_tag ON file_tags (tag)",
+	}, moveTags},
+	{"record the content type of files", []string{
+		"ALTER TABLE found_files ADD COLUMN mime TEXT NOT NULL DEFAULT ''",
+	}, nil},
+}
+
+// LatestSchemaVersion is the schema version this version of fileinventory migrates databases to
+func LatestSchemaVersion() int {
+	return len(migrations)
+}
+
+// Migration describes a schema migration applied to a database
+type Migration struct {
+	Version int
+	Name    string
+	Applied time.Time
+}
+
+func (s *SQLiteStore) createSchemaMigrationTable(ctx context.Context) error {
+	const sql = `
+		CREATE TABLE if not exists schema_migrations (
+			version int PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied TIMESTAMP NOT NULL
+	    )`
+	_, err := s.db.ExecContext(ctx, sql)
+	return err
+}
+
+// SchemaVersion returns the number of migrations applied to the database
+func (s *SQLiteStore) SchemaVersion(ctx context.Context) (int, error) {
+	hasMigrations, err := s.tableExists(ctx, "schema_migrations")
+	if err != nil {
+		return 0, err
+	}
+	if !hasMigrations {
+		return s.detectUnversionedSchema(ctx)
+	}
+	var version int
+	err = s.db.QueryRowContext(ctx, "SELECT coalesce(max(version), 0) FROM schema_migrations").Scan(&version)
+	return version, err
+}
+
+// AppliedMigrations returns the migrations applied to the database, oldest first
+func (s *SQLiteStore) AppliedMigrations(ctx context.Context) ([]Migration, error) {
+	hasMigrations, err := s.tableExists(ctx, "schema_migrations")
+	if err != nil || !hasMigrations {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, "SELECT version, name, applied FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ms []Migration
+	for rows.Next() {
+		var m Migration
+		if err := rows.Scan(&m.Version, &m.Name, &m.Applied); err != nil {
+			return nil, err
+		}
+		ms = append(ms, m)
+	}
+	return ms, rows.Err()
+}
+
+// Migrate applies any pending migrations, each in its own transaction, and returns the ones applied.
+// Returns ErrSchemaTooNew if the database has migrations this version doesn't know about.
+func (s *SQLiteStore) Migrate(ctx context.Context) ([]Migration, error) {
+	hasMigrations, err := s.tableExists(ctx, "schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	if !hasMigrations {
+		if err := s.adoptUnversionedSchema(ctx); err != nil {
+			return nil, err
+		}
+	}
+	version, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if version > len(migrations) {
+		return nil, fmt.Errorf("%w: schema version %d, supported %d", ErrSchemaTooNew, version, len(migrations))
+	}
+	var applied []Migration
+	for i := version; i < len(migrations); i++ {
+		m := Migration{Version: i + 1, Name: migrations[i].name, Applied: time.Now()}
+		if err := s.applyMigration(ctx, m, migrations[i]); err != nil {
+			return applied, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+func (s *SQLiteStore) applyMigration(ctx context.Context, m Migration, mig migration) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range mig.stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if mig.fn != nil {
+		if err := mig.fn(ctx, tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	const sql = `INSERT INTO schema_migrations (version, name, applied) VALUES (?, ?, ?)`
+	if _, err := tx.ExecContext(ctx, sql, m.Version, m.Name, m.Applied); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// adoptUnversionedSchema creates the schema_migrations table for databases created before schema
+// versions were recorded, marking the migrations their tables already reflect as applied
+func (s *SQLiteStore) adoptUnversionedSchema(ctx context.Context) error {
+	version, err := s.detectUnversionedSchema(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.createSchemaMigrationTable(ctx); err != nil {
+		return err
+	}
+	for i := 0; i < version; i++ {
+		m := Migration{Version: i + 1, Name: migrations[i].name, Applied: time.Now()}
+		if err := s.applyMigration(ctx, m, migration{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) detectUnversionedSchema(ctx context.Context) (int, error) {
+	hasFoundFiles, err := s.tableExists(ctx, "found_files")
+	if err != nil || !hasFoundFiles {
+		return 0, err
+	}
+	hasFileVersions, err := s.tableExists(ctx, "file_versions")
+	if err != nil {
+		return 0, err
+	}
+	rows, err := s.db.QueryContext(ctx, "SELECT name FROM pragma_table_info('found_files')")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	columns := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return 0, err
+		}
+		columns[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	switch {
+	case columns["md5hash"]:
+		return 1, nil
+	case !columns["last_verified"]:
+		return 2, nil
+	case !hasFileVersions:
+		return 3, nil
+	case !columns["missing_since"]:
+		return 4, nil
+	default:
+		// Indexes were added after schema versions were recorded
+		return 5, nil
+	}
+}
+
+// relativizePaths rewrites the absolute paths stored for each source relative to its root. Sources
+// without a root are rooted at the deepest folder containing everything indexed from them.
+func relativizePaths(ctx context.Context, tx *sql.Tx) error {
+	roots := map[string]string{}
+	rows, err := tx.QueryContext(ctx, "SELECT name, root FROM sources")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name, root string
+		if err := rows.Scan(&name, &root); err != nil {
+			rows.Close()
+			return err
+		}
+		roots[name] = root
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for name, root := range roots {
+		if root == "" {
+			if root, err = indexedFolder(ctx, tx, name); err != nil {
+				return err
+			}
+			if root == "" {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, "UPDATE sources SET root = ? WHERE name = ?", root, name); err != nil {
+				return err
+			}
+		}
+		for _, c := range []struct{ table, column string }{
+			{"found_files", "path"},
+			{"file_hashes", "path"},
+			{"file_versions", "path"},
+			{"index_errors", "path"},
+			{"index_runs", "root"},
+			{"index_runs", "checkpoint"},
+		} {
+			if err := relativizeColumn(ctx, tx, c.table, c.column, name, root); err != nil {
+				return fmt.Errorf("%s.%s: %w", c.table, c.column, err)
+			}
+		}
+	}
+	return nil
+}
+
+// indexedFolder returns the deepest folder containing every file and index run of source, or an
+// empty string if nothing was indexed from it
+func indexedFolder(ctx context.Context, tx *sql.Tx, source string) (string, error) {
+	const query = `
+		SELECT DISTINCT path, 1 FROM found_files WHERE source = ?
+		UNION ALL SELECT root, 0 FROM index_runs WHERE source = ?`
+	rows, err := tx.QueryContext(ctx, query, source, source)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	folder := ""
+	for rows.Next() {
+		var path string
+		var isFile bool
+		if err := rows.Scan(&path, &isFile); err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(path) {
+			continue
+		}
+		if isFile {
+			path = filepath.Dir(path)
+		}
+		if folder == "" {
+			folder = path
+		}
+		for !relUnder(path, folder) {
+			folder = filepath.Dir(folder)
+		}
+	}
+	return folder, rows.Err()
+}
+
+// relativizeColumn rewrites the absolute paths in column relative to root, a batch of rows at a time
+func relativizeColumn(ctx context.Context, tx *sql.Tx, table string, column string, source string, root string) error {
+	return rewriteColumn(ctx, tx, table, column, source, func(path string) (string, error) {
+		if !filepath.IsAbs(path) {
+			return path, nil
+		}
+		return filepath.Rel(root, path)
+	})
+}
+
+// rewriteColumn replaces the paths in column of the rows of source with what rewrite returns for
+// them, a batch of rows at a time
+func rewriteColumn(ctx context.Context, tx *sql.Tx, table string, column string, source string, rewrite func(string) (string, error)) error {
+	type row struct {
+		id   int64
+		path string
+	}
+	query := "SELECT rowid, " + column + " FROM " + table + " WHERE source = ? and rowid > ? ORDER BY rowid LIMIT 10000"
+	update := "UPDATE " + table + " SET " + column + " = ? WHERE rowid = ?"
+	var last int64
+	for {
+		rows, err := tx.QueryContext(ctx, query, source, last)
+		if err != nil {
+			return err
+		}
+		var batch []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.path); err != nil {
+				rows.Close()
+				return err
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		for _, r := range batch {
+			path, err := rewrite(r.path)
+			if err != nil {
+				return err
+			}
+			if path == r.path {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, update, path, r.id); err != nil {
+				return err
+			}
+		}
+		last = batch[len(batch)-1].id
+	}
+}
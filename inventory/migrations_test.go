@@ -0,0 +1,165 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrateUnversionedDatabase(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "index.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A database created before schema versions were recorded
+	stmts := append(migrations[0].stmts, `
+		INSERT INTO found_files (source, path, md5hash, name, size, modified, extension, discovered, last_checked)
+		VALUES ('laptop', '/a.txt', 'aaa', 'a.txt', 5, '2020-01-02 03:04:05', 'txt', '2020-01-02 03:04:05', '2020-01-02 03:04:05')`)
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	store, err := Init(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	version, err := store.SchemaVersion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != LatestSchemaVersion() {
+		t.Errorf("SchemaVersion() == %d, want %d", version, LatestSchemaVersion())
+	}
+	rooted := NewRootedStore(store)
+	ff, err := rooted.GetFoundFile(ctx, "laptop", "/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ff == nil || ff.Hash != "aaa" || ff.HashAlgorithm != "md5" {
+		t.Errorf("GetFoundFile() == %+v, want md5 aaa", ff)
+	}
+	hashes, err := rooted.GetFoundFileHashes(ctx, "laptop", "/a.txt", "aaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashes["md5"] != "aaa" {
+		t.Errorf("GetFoundFileHashes() == %v, want md5 aaa", hashes)
+	}
+	src, err := store.GetSource(ctx, "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src == nil || src.NumFiles != 1 || src.Root != "/" {
+		t.Errorf("GetSource(laptop) == %+v, want the registered source rooted at / with 1 file", src)
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "index.db")
+	store, err := Init(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.db.Exec("INSERT INTO schema_migrations (version, name, applied) VALUES (?, 'from the future', '2030-01-01 00:00:00')", LatestSchemaVersion()+1)
+	store.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Init(ctx, path)
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Init() error == %v, want ErrSchemaTooNew", err)
+	}
+}
+
+func TestMigrateRelativizesPaths(t *testing.T) {
+	ctx := context.Background()
+	store, err := Open(ctx, filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.createSchemaMigrationTable(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// A database from before paths were stored relative to the source root
+	for i, mig := range migrations[:9] {
+		if err := store.applyMigration(ctx, Migration{Version: i + 1, Name: mig.name}, mig); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, path := range []string{"/media/alice/Backup/photos/a.jpg", "/media/alice/Backup/docs/b.txt"} {
+		_, err := store.db.Exec(`
+			INSERT INTO found_files (source, path, hash, name, size, modified, extension, discovered, last_checked)
+			VALUES ('backup', ?, ?, 'a', 5, '2020-01-02 03:04:05', '', '2020-01-02 03:04:05', '2020-01-02 03:04:05')`, path, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.db.Exec("INSERT INTO sources (name, created) VALUES ('backup', '2020-01-02 03:04:05')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := store.GetSource(ctx, "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src == nil || src.Root != "/media/alice/Backup" {
+		t.Errorf("GetSource(backup) == %+v, want root /media/alice/Backup", src)
+	}
+	ff, err := store.GetFoundFile(ctx, "backup", filepath.Join("photos", "a.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ff == nil {
+		t.Error("GetFoundFile(photos/a.jpg) == nil, want the file stored relative to the root")
+	}
+}
+
+func TestMigrateMovesTags(t *testing.T) {
+	ctx := context.Background()
+	store, err := Open(ctx, filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.createSchemaMigrationTable(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// A database from before tags had their own table
+	for i, mig := range migrations[:11] {
+		if err := store.applyMigration(ctx, Migration{Version: i + 1, Name: mig.name}, mig); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = store.db.Exec(`
+		INSERT INTO found_files (source, path, hash, name, size, modified, extension, tags, discovered, last_checked)
+		VALUES ('laptop', 'a.jpg', 'aaa', 'a.jpg', 5, '2020-01-02 03:04:05', 'jpg', 'vacation, beach,', '2020-01-02 03:04:05', '2020-01-02 03:04:05')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	ff, err := store.GetFoundFile(ctx, "laptop", "a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ff == nil || strings.Join(ff.Tags, ",") != "beach,vacation" {
+		t.Errorf("GetFoundFile(a.jpg) == %+v, want tags beach and vacation", ff)
+	}
+}
@@ -0,0 +1,398 @@
+package inventory
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query is a search of indexed files written as terms a file needs to match all of, i.e.
+//
+//	type:image size>10MB modified<2015 -source:laptop copies<2 tag:vacation
+//
+// A term is a field, an operator and a value. The operators are : and = for equal, != for not
+// equal, and <, <=, > and >= for sizes, counts and dates. A - in front of a term negates it, and a
+// word without a field matches file names containing it. Values with spaces are quoted, i.e.
+// label:"summer trip". name, path and mime are globs, path relative to the root of the source. Dates
+// are a year, a month or a day, i.e. 2015, 2015-06 or 2015-06-01 in local time, or a time in RFC
+// 3339, and compare as the whole period, so modified>2015 is from 2016 on. copies counts the sources
+// with the content of the file, its own included.
+type Query struct {
+	terms []queryTerm
+}
+
+// Kinds of value of query fields
+const (
+	queryString = iota
+	queryGlob
+	queryTag
+	queryHash
+	querySize
+	queryCount
+	queryDate
+)
+
+// queryFields are the fields of queries, with the column they are stored in
+var queryFields = map[string]struct {
+	column string
+	kind   int
+}{
+	"source":      {"source", queryString},
+	"name":        {"name", queryGlob},
+	"path":        {"path", queryGlob},
+	"ext":         {"extension", queryString},
+	"extension":   {"extension", queryString},
+	"type":        {"type", queryString},
+	"mime":        {"mime", queryGlob},
+	"category":    {"category", queryString},
+	"subcategory": {"subcategory", queryString},
+	"label":       {"label", queryString},
+	"tag":         {"tags", queryTag},
+	"hash":        {"hash", queryHash},
+	"size":        {"size", querySize},
+	"copies":      {"copies", queryCount},
+	"modified":    {"modified", queryDate},
+	"discovered":  {"discovered", queryDate},
+}
+
+// queryOperators are the operators of terms, longest first so <= isn't read as <
+var queryOperators = []string{"<=", ">=", "!=", ":", "=", "<", ">"}
+
+// queryTerm is a term of a query, with its value parsed for the kind of field. The operator is
+// one of =, <, <=, > and >=, != is parsed as a negated =.
+type queryTerm struct {
+	field  string
+	op     string
+	negate bool
+	value  string
+	glob   *regexp.Regexp
+	n      int64
+	// from and to are the period of a date, to excluded
+	from time.Time
+	to   time.Time
+}
+
+// ParseQuery parses the terms of a query, see Query for the syntax
+func ParseQuery(s string) (*Query, error) {
+	words, err := splitQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	q := &Query{}
+	for _, w := range words {
+		t, err := parseQueryTerm(w.text)
+		if err != nil {
+			return nil, fmt.Errorf("column %d: %s: %w", w.column, w.text, err)
+		}
+		q.terms = append(q.terms, t)
+	}
+	return q, nil
+}
+
+type queryWord struct {
+	text   string
+	column int
+}
+
+// splitQuery splits s into its terms at spaces outside quotes
+func splitQuery(s string) ([]queryWord, error) {
+	var words []queryWord
+	start, quote := -1, -1
+	for i, r := range s + " " {
+		switch {
+		case r == '"':
+			if quote >= 0 {
+				quote = -1
+			} else {
+				quote = i
+			}
+			if start < 0 {
+				start = i
+			}
+		case quote >= 0:
+		case r == ' ' || r == '\t' || r == '\n':
+			if start >= 0 {
+				words = append(words, queryWord{s[start:i], start + 1})
+				start = -1
+			}
+		case start < 0:
+			start = i
+		}
+	}
+	if quote >= 0 {
+		return nil, fmt.Errorf("column %d: quote isn't closed", quote+1)
+	}
+	return words, nil
+}
+
+func parseQueryTerm(s string) (queryTerm, error) {
+	var t queryTerm
+	if strings.HasPrefix(s, "-") && len(s) > 1 {
+		t.negate = true
+		s = s[1:]
+	}
+	end := len(s)
+	if i := strings.IndexAny(s, `:=<>!"`); i >= 0 {
+		end = i
+	}
+	field := s[:end]
+	rest := s[end:]
+	for _, op := range queryOperators {
+		if strings.HasPrefix(rest, op) {
+			t.op = op
+			break
+		}
+	}
+	if t.op == "" {
+		// A word on its own matches names containing it
+		field, t.op, rest = "name", ":", ":*"+s+"*"
+	} else if _, ok := queryFields[field]; !ok {
+		names := make([]string, 0, len(queryFields))
+		for name := range queryFields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return t, fmt.Errorf("unknown field %q, expected one of %s", field, strings.Join(names, ", "))
+	}
+	t.field = field
+	t.value = strings.ReplaceAll(rest[len(t.op):], `"`, "")
+	switch t.op {
+	case ":":
+		t.op = "="
+	case "!=":
+		t.op, t.negate = "=", !t.negate
+	}
+	if t.value == "" {
+		return t, fmt.Errorf("%s has no value", field)
+	}
+
+	var err error
+	switch kind := queryFields[field].kind; kind {
+	case queryString, queryGlob, queryTag, queryHash:
+		if t.op != "=" {
+			return t, fmt.Errorf("%s can't be compared with %s, only with : or !=", field, t.op)
+		}
+		if field == "ext" || field == "extension" {
+			t.value = strings.TrimPrefix(strings.ToLower(t.value), ".")
+		}
+		if kind == queryGlob {
+			if t.glob, err = globRegexp(t.value); err != nil {
+				return t, err
+			}
+		}
+	case querySize:
+		if t.n, err = ParseSize(t.value); err != nil {
+			return t, err
+		}
+	case queryCount:
+		n, err := strconv.Atoi(t.value)
+		if err != nil || n < 0 {
+			return t, fmt.Errorf("invalid count %q", t.value)
+		}
+		t.n = int64(n)
+	case queryDate:
+		if t.from, t.to, err = parseQueryDate(t.value); err != nil {
+			return t, err
+		}
+	}
+	return t, nil
+}
+
+// parseQueryDate returns the period of a year, a month, a day or a second
+func parseQueryDate(s string) (time.Time, time.Time, error) {
+	for _, l := range []struct {
+		layout string
+		years  int
+		months int
+		days   int
+	}{{"2006", 1, 0, 0}, {"2006-01", 0, 1, 0}, {"2006-01-02", 0, 0, 1}} {
+		if t, err := time.ParseInLocation(l.layout, s, time.Local); err == nil {
+			return t, t.AddDate(l.years, l.months, l.days), nil
+		}
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, t, fmt.Errorf("invalid date %q, expected i.e. 2015, 2015-06, 2015-06-01 or 2015-06-01T15:04:05Z", s)
+	}
+	return t, t.Add(time.Second), nil
+}
+
+// globRegexp returns a regexp matching what the glob pattern matches in SQLite, where * and ? match
+// any character, / included, and [...] a set of characters
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid pattern %q, [ isn't closed", pattern)
+			}
+			set := pattern[i+1 : i+1+end]
+			b.WriteString("[" + strings.ReplaceAll(set, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// copiesSQL counts the sources with the content of the current row of found_files, like
+// GetFoundFileOtherSourcesWithSameContent
+const copiesSQL = `(1 + (SELECT count(DISTINCT o.source)
+	FROM file_hashes mine JOIN file_hashes other ON other.algorithm = mine.algorithm and other.digest = mine.digest
+		JOIN found_files o ON o.source = other.source and o.path = other.path and o.hash = other.hash and o.status = ''
+	WHERE mine.source = found_files.source and mine.path = found_files.path and mine.hash = found_files.hash
+		and o.source != found_files.source))`
+
+// hasTagSQL is the condition that the current row of found_files has a tag
+const hasTagSQL = "EXISTS (SELECT 1 FROM file_tags WHERE file_tags.source = found_files.source and file_tags.path = found_files.path and tag = ?)"
+
+// sql returns the condition of the term on found_files and its arguments. Values are only ever
+// passed as arguments.
+func (t *queryTerm) sql() (cond string, args []interface{}) {
+	column := queryFields[t.field].column
+	switch queryFields[t.field].kind {
+	case queryTag:
+		cond, args = hasTagSQL, []interface{}{t.value}
+	case queryString:
+		cond, args = column+" = ?", []interface{}{t.value}
+	case queryGlob:
+		cond, args = column+" GLOB ?", []interface{}{t.value}
+	case queryHash:
+		cond = "(hash = ? or (source, path, hash) IN (SELECT source, path, hash FROM file_hashes WHERE digest = ?))"
+		args = []interface{}{t.value, t.value}
+	case querySize, queryCount:
+		if column == "copies" {
+			column = copiesSQL
+		}
+		cond, args = column+" "+t.op+" ?", []interface{}{t.n}
+	case queryDate:
+		// Times are stored as text with the offset they had, so compare them as julian days
+		day := "julianday(" + column + ")"
+		switch t.op {
+		case "=":
+			cond, args = "("+day+" >= julianday(?) and "+day+" < julianday(?))", []interface{}{t.from, t.to}
+		case "<":
+			cond, args = day+" < julianday(?)", []interface{}{t.from}
+		case "<=":
+			cond, args = day+" < julianday(?)", []interface{}{t.to}
+		case ">":
+			cond, args = day+" >= julianday(?)", []interface{}{t.to}
+		case ">=":
+			cond, args = day+" >= julianday(?)", []interface{}{t.from}
+		}
+	}
+	if t.negate {
+		cond = "NOT " + cond
+	}
+	return cond, args
+}
+
+// match reports whether ff matches the term, hashes are its digests and copies counts the sources
+// with its content
+func (t *queryTerm) match(ff *FoundFile, hashes map[string]string, copies func() int) bool {
+	return t.matches(ff, hashes, copies) != t.negate
+}
+
+func (t *queryTerm) matches(ff *FoundFile, hashes map[string]string, copies func() int) bool {
+	switch t.field {
+	case "source":
+		return ff.Source == t.value
+	case "name":
+		return t.glob.MatchString(ff.Name)
+	case "path":
+		return t.glob.MatchString(ff.Path)
+	case "ext", "extension":
+		return ff.Extension == t.value
+	case "type":
+		return ff.Type == t.value
+	case "mime":
+		return t.glob.MatchString(ff.MIME)
+	case "category":
+		return ff.Category == t.value
+	case "subcategory":
+		return ff.Subcategory == t.value
+	case "label":
+		return ff.Label == t.value
+	case "tag":
+		return hasTags(ff.Tags, []string{t.value})
+	case "hash":
+		if ff.Hash == t.value {
+			return true
+		}
+		for _, digest := range hashes {
+			if digest == t.value {
+				return true
+			}
+		}
+		return false
+	case "size":
+		return compare(ff.Size, t.op, t.n)
+	case "copies":
+		return compare(int64(copies()), t.op, t.n)
+	case "modified", "discovered":
+		d := ff.Modified
+		if t.field == "discovered" {
+			d = ff.Discovered
+		}
+		switch t.op {
+		case "=":
+			return inRange(d, t.from, t.to)
+		case "<":
+			return d.Before(t.from)
+		case "<=":
+			return d.Before(t.to)
+		case ">":
+			return !d.Before(t.to)
+		case ">=":
+			return !d.Before(t.from)
+		}
+	}
+	return false
+}
+
+func compare(a int64, op string, b int64) bool {
+	switch op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return a == b
+}
+
+// sql returns the conditions of the terms and their arguments
+func (q *Query) sql() ([]string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	for i := range q.terms {
+		cond, a := q.terms[i].sql()
+		conds = append(conds, cond)
+		args = append(args, a...)
+	}
+	return conds, args
+}
+
+func (q *Query) match(ff *FoundFile, hashes map[string]string, copies func() int) bool {
+	for i := range q.terms {
+		if !q.terms[i].match(ff, hashes, copies) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package inventory
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	q, err := ParseQuery(`type:image  size>10MB -source:laptop label:"summer trip" ext:.JPG copies!=2 holiday modified<=2015-06`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, term := range q.terms {
+		s := term.field + term.op + term.value
+		if term.negate {
+			s = "-" + s
+		}
+		got = append(got, s)
+	}
+	want := "type=image size>10MB -source=laptop label=summer trip ext=jpg -copies=2 name=*holiday* modified<=2015-06"
+	if strings.Join(got, " ") != want {
+		t.Errorf("terms == %q, want %q", strings.Join(got, " "), want)
+	}
+	if term := q.terms[7]; !term.to.Equal(time.Date(2015, 7, 1, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("modified<=2015-06 ends at %v, want July", term.to)
+	}
+
+	errs := []struct {
+		query string
+		want  string
+	}{
+		{"sise>10MB", `column 1: sise>10MB: unknown field "sise", expected one of`},
+		{"type:image size>big", `column 12: size>big: invalid size "big"`},
+		{"type<image", "type can't be compared with <"},
+		{"modified>yesterday", `invalid date "yesterday"`},
+		{"label:", "label has no value"},
+		{`label:"summer trip`, "column 7: quote isn't closed"},
+		{"copies<-1", `invalid count "-1"`},
+		{"name:[abc", "[ isn't closed"},
+	}
+	for _, c := range errs {
+		_, err := ParseQuery(c.query)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("ParseQuery(%q) error == %v, want %q", c.query, err, c.want)
+		}
+	}
+}
+
+func TestStoreFindFoundFilesWhere(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		photo := testFile("laptop", "Pictures/2014/IMG_1.jpg", "aaa")
+		photo.Extension, photo.Type, photo.Size, photo.Tags, photo.Modified = "jpg", "image", 20*1000*1000, []string{"beach", "vacation"}, time.Date(2014, 8, 1, 0, 0, 0, 0, time.UTC)
+		photo.Hashes = map[string]string{"md5": "aaa"}
+		backup := testFile("backup", "IMG_1.jpg", "aaa")
+		backup.Extension, backup.Type, backup.Size, backup.Modified = "jpg", "image", photo.Size, photo.Modified
+		backup.Hashes = map[string]string{"md5": "aaa"}
+		small := testFile("phone", "DCIM/IMG_2.jpg", "bbb")
+		small.Extension, small.Type, small.Size, small.Tags = "jpg", "image", 1000, []string{"vacation"}
+		small.Hashes = map[string]string{"md5": "bbb"}
+		doc := testFile("laptop", "Documents/notes.txt", "ccc")
+		doc.Type, doc.MIME, doc.Label = "document", "text/plain", "summer trip"
+		doc.Hashes = map[string]string{"md5": "ccc"}
+		for _, ff := range []*FoundFile{photo, backup, small, doc} {
+			if err := store.Save(ctx, ff); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		cases := []struct {
+			query string
+			want  string
+		}{
+			{"", "backup:IMG_1.jpg laptop:Documents/notes.txt laptop:Pictures/2014/IMG_1.jpg phone:DCIM/IMG_2.jpg"},
+			{"type:image size>10MB modified<2015 -source:backup", "laptop:Pictures/2014/IMG_1.jpg"},
+			{"type:image copies<2", "phone:DCIM/IMG_2.jpg"},
+			{"copies>=2", "backup:IMG_1.jpg laptop:Pictures/2014/IMG_1.jpg"},
+			{"tag:vacation -tag:beach", "phone:DCIM/IMG_2.jpg"},
+			{"path:Pictures/* modified:2014-08", "laptop:Pictures/2014/IMG_1.jpg"},
+			{"modified>2014", "laptop:Documents/notes.txt phone:DCIM/IMG_2.jpg"},
+			{`label:"summer trip"`, "laptop:Documents/notes.txt"},
+			{"mime:text/*", "laptop:Documents/notes.txt"},
+			{"IMG hash:aaa size<=20MB", "backup:IMG_1.jpg laptop:Pictures/2014/IMG_1.jpg"},
+			{"source!=laptop ext:JPG", "backup:IMG_1.jpg phone:DCIM/IMG_2.jpg"},
+			{`source:"laptop' or 1=1 --"`, ""},
+		}
+		for _, c := range cases {
+			where, err := ParseQuery(c.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ffs, err := store.FindFoundFiles(ctx, FileQuery{Where: where})
+			if err != nil {
+				t.Fatalf("FindFoundFiles(%s): %v", c.query, err)
+			}
+			var got []string
+			for _, ff := range ffs {
+				got = append(got, ff.Source+":"+ff.Path)
+			}
+			if strings.Join(got, " ") != c.want {
+				t.Errorf("FindFoundFiles(%s) == %v, want %v", c.query, got, c.want)
+			}
+		}
+	})
+}
@@ -0,0 +1,551 @@
+package inventory
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RootedStore is a Store that stores paths relative to the root of their source, so the same files
+// are recognised wherever the source is mounted. Paths passed to and returned by it are absolute,
+// resolved against the current root of each source. Sources without a root are rooted at /.
+// Paths outside the root are stored relative to it too, starting with "..".
+type RootedStore struct {
+	store Store
+	mu    sync.Mutex
+	// roots caches the root of each registered source, nil until loaded
+	roots map[string]string
+}
+
+// NewRootedStore returns a RootedStore storing paths in store
+func NewRootedStore(store Store) *RootedStore {
+	return &RootedStore{store: store}
+}
+
+// pathPrefix returns the prefix of the paths below root. Every relative path is below ".", the root
+// of a source, including those outside it starting with "..", which callers must filter out.
+func pathPrefix(root string) string {
+	if root == "." {
+		return ""
+	}
+	return strings.TrimSuffix(root, string(filepath.Separator)) + string(filepath.Separator)
+}
+
+// loadRoots returns the root of each registered source, loading them if needed
+func (s *RootedStore) loadRoots(ctx context.Context) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.roots != nil {
+		return s.roots, nil
+	}
+	srcs, err := s.store.GetSources(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.roots = map[string]string{}
+	for _, src := range srcs {
+		s.roots[src.Name] = src.Root
+	}
+	return s.roots, nil
+}
+
+func (s *RootedStore) resetRoots() {
+	s.mu.Lock()
+	s.roots = nil
+	s.mu.Unlock()
+}
+
+// Root returns the folder the paths of source are relative to
+func (s *RootedStore) Root(ctx context.Context, source string) (string, error) {
+	roots, err := s.loadRoots(ctx)
+	if err != nil {
+		return "", err
+	}
+	if root := roots[source]; root != "" {
+		return root, nil
+	}
+	return string(filepath.Separator), nil
+}
+
+// rel returns path relative to the root of source
+func (s *RootedStore) rel(ctx context.Context, source string, path string) (string, error) {
+	root, err := s.Root(ctx, source)
+	if err != nil || !filepath.IsAbs(path) {
+		return path, err
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return path, nil
+	}
+	return rel, nil
+}
+
+// abs returns path, relative to the root of source, as an absolute path
+func (s *RootedStore) abs(ctx context.Context, source string, path string) (string, error) {
+	root, err := s.Root(ctx, source)
+	if err != nil || path == "" || filepath.IsAbs(path) {
+		return path, err
+	}
+	return filepath.Join(root, path), nil
+}
+
+// absFiles makes the paths of ffs absolute
+func (s *RootedStore) absFiles(ctx context.Context, ffs []FoundFile) error {
+	for i := range ffs {
+		var err error
+		if ffs[i].Path, err = s.abs(ctx, ffs[i].Source, ffs[i].Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RootedStore) absFile(ctx context.Context, ff *FoundFile, err error) (*FoundFile, error) {
+	if err != nil || ff == nil {
+		return nil, err
+	}
+	ff.Path, err = s.abs(ctx, ff.Source, ff.Path)
+	return ff, err
+}
+
+// withRelPaths calls f with the paths of ffs relative to their source root, restoring them afterwards
+func (s *RootedStore) withRelPaths(ctx context.Context, ffs []*FoundFile, f func() error) error {
+	paths := make([]string, len(ffs))
+	defer func() {
+		for i, ff := range ffs {
+			if paths[i] != "" {
+				ff.Path = paths[i]
+			}
+		}
+	}()
+	for i, ff := range ffs {
+		rel, err := s.rel(ctx, ff.Source, ff.Path)
+		if err != nil {
+			return err
+		}
+		paths[i], ff.Path = ff.Path, rel
+	}
+	return f()
+}
+
+// relRoot returns root relative to the root of source, for querying the paths under it. When root
+// contains the root of source it is ".", matching every path, and callers filter out those not under root.
+func (s *RootedStore) relRoot(ctx context.Context, source string, root string) (string, error) {
+	sourceRoot, err := s.Root(ctx, source)
+	if err != nil {
+		return "", err
+	}
+	if relUnder(sourceRoot, root) {
+		return ".", nil
+	}
+	return s.rel(ctx, source, root)
+}
+
+// sourcesFor returns source, or every registered source if it is empty
+func (s *RootedStore) sourcesFor(ctx context.Context, source string) ([]string, error) {
+	if source != "" {
+		return []string{source}, nil
+	}
+	roots, err := s.loadRoots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var sources []string
+	for name := range roots {
+		sources = append(sources, name)
+	}
+	sort.Strings(sources)
+	return sources, nil
+}
+
+// relUnder reports whether path is root or inside it, comparing them as strings so it works on
+// the relative paths stored as well as absolute ones
+func relUnder(path string, root string) bool {
+	return path == root || strings.HasPrefix(path, pathPrefix(root))
+}
+
+// GetFoundFile ...
+func (s *RootedStore) GetFoundFile(ctx context.Context, source string, path string) (*FoundFile, error) {
+	rel, err := s.rel(ctx, source, path)
+	if err != nil {
+		return nil, err
+	}
+	ff, err := s.store.GetFoundFile(ctx, source, rel)
+	return s.absFile(ctx, ff, err)
+}
+
+// GetFoundFileWithHash ...
+func (s *RootedStore) GetFoundFileWithHash(ctx context.Context, source string, path string, hash string) (*FoundFile, error) {
+	rel, err := s.rel(ctx, source, path)
+	if err != nil {
+		return nil, err
+	}
+	ff, err := s.store.GetFoundFileWithHash(ctx, source, rel, hash)
+	return s.absFile(ctx, ff, err)
+}
+
+// GetFoundFileWithHashes ...
+func (s *RootedStore) GetFoundFileWithHashes(ctx context.Context, source string, path string, hashes map[string]string) (*FoundFile, error) {
+	rel, err := s.rel(ctx, source, path)
+	if err != nil {
+		return nil, err
+	}
+	ff, err := s.store.GetFoundFileWithHashes(ctx, source, rel, hashes)
+	return s.absFile(ctx, ff, err)
+}
+
+// GetFoundFileHashes ...
+func (s *RootedStore) GetFoundFileHashes(ctx context.Context, source string, path string, hash string) (map[string]string, error) {
+	rel, err := s.rel(ctx, source, path)
+	if err != nil {
+		return nil, err
+	}
+	return s.store.GetFoundFileHashes(ctx, source, rel, hash)
+}
+
+// GetFoundFileWithSizeAndModified ...
+func (s *RootedStore) GetFoundFileWithSizeAndModified(ctx context.Context, source string, path string, size int64, modified time.Time) (*FoundFile, error) {
+	rel, err := s.rel(ctx, source, path)
+	if err != nil {
+		return nil, err
+	}
+	ff, err := s.store.GetFoundFileWithSizeAndModified(ctx, source, rel, size, modified)
+	return s.absFile(ctx, ff, err)
+}
+
+// GetFoundFileOtherSourcesWithSameContent ...
+func (s *RootedStore) GetFoundFileOtherSourcesWithSameContent(ctx context.Context, ff *FoundFile) ([]FoundFile, error) {
+	var ffs []FoundFile
+	err := s.withRelPaths(ctx, []*FoundFile{ff}, func() error {
+		var err error
+		ffs, err = s.store.GetFoundFileOtherSourcesWithSameContent(ctx, ff)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ffs, s.absFiles(ctx, ffs)
+}
+
+// GetSimilarFoundFileSourcesWithSizeAndModified ...
+func (s *RootedStore) GetSimilarFoundFileSourcesWithSizeAndModified(ctx context.Context, size int64, modified time.Time) ([]FoundFile, error) {
+	ffs, err := s.store.GetSimilarFoundFileSourcesWithSizeAndModified(ctx, size, modified)
+	if err != nil {
+		return nil, err
+	}
+	return ffs, s.absFiles(ctx, ffs)
+}
+
+// GetFoundFilesUnderPath ...
+func (s *RootedStore) GetFoundFilesUnderPath(ctx context.Context, source string, root string) ([]FoundFile, error) {
+	return s.getFoundFilesUnderPath(ctx, source, root, s.store.GetFoundFilesUnderPath)
+}
+
+// GetFoundFilesUnderPathAfter pages through the paths in the order they are stored, relative to the
+// root of source, so the files outside it come first. Those outside root too are skipped, without
+// returning an empty page before the end.
+func (s *RootedStore) GetFoundFilesUnderPathAfter(ctx context.Context, source string, root string, after string, limit int) ([]FoundFile, error) {
+	rel, err := s.relRoot(ctx, source, root)
+	if err != nil {
+		return nil, err
+	}
+	if after, err = s.rel(ctx, source, after); err != nil {
+		return nil, err
+	}
+	for {
+		found, err := s.store.GetFoundFilesUnderPathAfter(ctx, source, rel, after, limit)
+		if err != nil || len(found) == 0 {
+			return nil, err
+		}
+		after = found[len(found)-1].Path
+		if err := s.absFiles(ctx, found); err != nil {
+			return nil, err
+		}
+		var ffs []FoundFile
+		for _, ff := range found {
+			if relUnder(ff.Path, root) {
+				ffs = append(ffs, ff)
+			}
+		}
+		if len(ffs) > 0 {
+			return ffs, nil
+		}
+	}
+}
+
+// GetMissingFoundFilesUnderPath ...
+func (s *RootedStore) GetMissingFoundFilesUnderPath(ctx context.Context, source string, root string) ([]FoundFile, error) {
+	return s.getFoundFilesUnderPath(ctx, source, root, s.store.GetMissingFoundFilesUnderPath)
+}
+
+// getFoundFilesUnderPath queries each source separately, as root is relative to a different folder in each
+func (s *RootedStore) getFoundFilesUnderPath(ctx context.Context, source string, root string, get func(ctx context.Context, source string, root string) ([]FoundFile, error)) ([]FoundFile, error) {
+	sources, err := s.sourcesFor(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	var ffs []FoundFile
+	for _, source := range sources {
+		rel, err := s.relRoot(ctx, source, root)
+		if err != nil {
+			return nil, err
+		}
+		found, err := get(ctx, source, rel)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.absFiles(ctx, found); err != nil {
+			return nil, err
+		}
+		for _, ff := range found {
+			if relUnder(ff.Path, root) {
+				ffs = append(ffs, ff)
+			}
+		}
+	}
+	sort.SliceStable(ffs, func(i, j int) bool { return ffs[i].Path < ffs[j].Path })
+	return ffs, nil
+}
+
+// GetDuplicateGroups ...
+func (s *RootedStore) GetDuplicateGroups(ctx context.Context, source string, minSize int64, fileType string) ([]DuplicateGroup, error) {
+	groups, err := s.store.GetDuplicateGroups(ctx, source, minSize, fileType)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range groups {
+		if err := s.absFiles(ctx, g.Files); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// FindFoundFiles ...
+func (s *RootedStore) FindFoundFiles(ctx context.Context, q FileQuery) ([]FoundFile, error) {
+	ffs, err := s.store.FindFoundFiles(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return ffs, s.absFiles(ctx, ffs)
+}
+
+// GetFileVersions ...
+func (s *RootedStore) GetFileVersions(ctx context.Context, source string, path string) ([]FileVersion, error) {
+	sources, err := s.sourcesFor(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	var fvs []FileVersion
+	for _, source := range sources {
+		rel, err := s.rel(ctx, source, path)
+		if err != nil {
+			return nil, err
+		}
+		found, err := s.store.GetFileVersions(ctx, source, rel)
+		if err != nil {
+			return nil, err
+		}
+		for _, fv := range found {
+			fv.Path = path
+			fvs = append(fvs, fv)
+		}
+	}
+	return fvs, nil
+}
+
+// absRun makes the root and checkpoint of run absolute
+func (s *RootedStore) absRun(ctx context.Context, run *IndexRun) error {
+	var err error
+	if run.Root, err = s.abs(ctx, run.Source, run.Root); err != nil {
+		return err
+	}
+	run.Checkpoint, err = s.abs(ctx, run.Source, run.Checkpoint)
+	return err
+}
+
+// GetIndexRuns ...
+func (s *RootedStore) GetIndexRuns(ctx context.Context, source string) ([]IndexRun, error) {
+	runs, err := s.store.GetIndexRuns(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	for i := range runs {
+		if err := s.absRun(ctx, &runs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return runs, nil
+}
+
+// GetResumableIndexRun ...
+func (s *RootedStore) GetResumableIndexRun(ctx context.Context, source string) (*IndexRun, error) {
+	run, err := s.store.GetResumableIndexRun(ctx, source)
+	if err != nil || run == nil {
+		return nil, err
+	}
+	return run, s.absRun(ctx, run)
+}
+
+// GetIndexErrorsUnderPath ...
+func (s *RootedStore) GetIndexErrorsUnderPath(ctx context.Context, source string, root string) ([]IndexError, error) {
+	sources, err := s.sourcesFor(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	var es []IndexError
+	for _, source := range sources {
+		rel, err := s.relRoot(ctx, source, root)
+		if err != nil {
+			return nil, err
+		}
+		found, err := s.store.GetIndexErrorsUnderPath(ctx, source, rel)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range found {
+			if e.Path, err = s.abs(ctx, e.Source, e.Path); err != nil {
+				return nil, err
+			}
+			if relUnder(e.Path, root) {
+				es = append(es, e)
+			}
+		}
+	}
+	sort.SliceStable(es, func(i, j int) bool { return es[i].Path < es[j].Path })
+	return es, nil
+}
+
+// Save ...
+func (s *RootedStore) Save(ctx context.Context, ff *FoundFile) error {
+	return s.SaveAll(ctx, []*FoundFile{ff})
+}
+
+// SaveAll ...
+func (s *RootedStore) SaveAll(ctx context.Context, ffs []*FoundFile) error {
+	return s.withRelPaths(ctx, ffs, func() error {
+		return s.store.SaveAll(ctx, ffs)
+	})
+}
+
+// SaveVerified ...
+func (s *RootedStore) SaveVerified(ctx context.Context, ff *FoundFile, status string, verified time.Time) error {
+	return s.withRelPaths(ctx, []*FoundFile{ff}, func() error {
+		return s.store.SaveVerified(ctx, ff, status, verified)
+	})
+}
+
+// SaveMissing ...
+func (s *RootedStore) SaveMissing(ctx context.Context, ff *FoundFile, missingSince time.Time) error {
+	return s.withRelPaths(ctx, []*FoundFile{ff}, func() error {
+		return s.store.SaveMissing(ctx, ff, missingSince)
+	})
+}
+
+// SaveCategories ...
+func (s *RootedStore) SaveCategories(ctx context.Context, ffs []*FoundFile) error {
+	return s.withRelPaths(ctx, ffs, func() error {
+		return s.store.SaveCategories(ctx, ffs)
+	})
+}
+
+// GetTags ...
+func (s *RootedStore) GetTags(ctx context.Context, source string) ([]Tag, error) {
+	return s.store.GetTags(ctx, source)
+}
+
+// AddTags ...
+func (s *RootedStore) AddTags(ctx context.Context, ffs []*FoundFile, tags []string) error {
+	return s.withRelPaths(ctx, ffs, func() error {
+		return s.store.AddTags(ctx, ffs, tags)
+	})
+}
+
+// RemoveTags ...
+func (s *RootedStore) RemoveTags(ctx context.Context, ffs []*FoundFile, tags []string) error {
+	return s.withRelPaths(ctx, ffs, func() error {
+		return s.store.RemoveTags(ctx, ffs, tags)
+	})
+}
+
+// CreateIndexRun ...
+func (s *RootedStore) CreateIndexRun(ctx context.Context, run *IndexRun) error {
+	saved := *run
+	var err error
+	if saved.Root, err = s.rel(ctx, run.Source, run.Root); err != nil {
+		return err
+	}
+	if err := s.store.CreateIndexRun(ctx, &saved); err != nil {
+		return err
+	}
+	run.ID = saved.ID
+	return nil
+}
+
+// UpdateIndexRun ...
+func (s *RootedStore) UpdateIndexRun(ctx context.Context, run *IndexRun) error {
+	saved := *run
+	var err error
+	if saved.Root, err = s.rel(ctx, run.Source, run.Root); err != nil {
+		return err
+	}
+	if run.Checkpoint != "" {
+		if saved.Checkpoint, err = s.rel(ctx, run.Source, run.Checkpoint); err != nil {
+			return err
+		}
+	}
+	return s.store.UpdateIndexRun(ctx, &saved)
+}
+
+// SaveIndexError ...
+func (s *RootedStore) SaveIndexError(ctx context.Context, e *IndexError) error {
+	saved := *e
+	var err error
+	if saved.Path, err = s.rel(ctx, e.Source, e.Path); err != nil {
+		return err
+	}
+	return s.store.SaveIndexError(ctx, &saved)
+}
+
+// GetSources ...
+func (s *RootedStore) GetSources(ctx context.Context) ([]Source, error) {
+	return s.store.GetSources(ctx)
+}
+
+// GetSource ...
+func (s *RootedStore) GetSource(ctx context.Context, name string) (*Source, error) {
+	return s.store.GetSource(ctx, name)
+}
+
+// AddSource ...
+func (s *RootedStore) AddSource(ctx context.Context, src *Source) error {
+	defer s.resetRoots()
+	return s.store.AddSource(ctx, src)
+}
+
+// UpdateSource changes the root of src without moving its files, as when a drive is mounted somewhere
+// else. A source without a root has its files rebased on the root set, so they stay where they are.
+func (s *RootedStore) UpdateSource(ctx context.Context, src *Source) error {
+	defer s.resetRoots()
+	return s.store.UpdateSource(ctx, src)
+}
+
+// RenameSource ...
+func (s *RootedStore) RenameSource(ctx context.Context, name string, newName string) error {
+	defer s.resetRoots()
+	return s.store.RenameSource(ctx, name, newName)
+}
+
+// RemoveSource ...
+func (s *RootedStore) RemoveSource(ctx context.Context, name string) error {
+	defer s.resetRoots()
+	return s.store.RemoveSource(ctx, name)
+}
+
+// Close ...
+func (s *RootedStore) Close() error {
+	return s.store.Close()
+}
@@ -0,0 +1,41 @@
+package inventory
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ParseSize parses a size in bytes with an optional decimal unit, i.e. "500", "10KB", "1.5GB"
+func ParseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   float64
+	}{
+		{"TB", 1000 * 1000 * 1000 * 1000},
+		{"GB", 1000 * 1000 * 1000},
+		{"MB", 1000 * 1000},
+		{"KB", 1000},
+		{"T", 1000 * 1000 * 1000 * 1000},
+		{"G", 1000 * 1000 * 1000},
+		{"M", 1000 * 1000},
+		{"K", 1000},
+		{"B", 1},
+	}
+	n := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := float64(1)
+	for _, u := range units {
+		if strings.HasSuffix(n, u.suffix) {
+			n = strings.TrimSpace(strings.TrimSuffix(n, u.suffix))
+			multiplier = u.size
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(n, 64)
+	// float64(math.MaxInt64) rounds up to 2^63, which doesn't fit in an int64 either
+	if err != nil || f < 0 || math.IsNaN(f) || math.IsInf(f, 0) || f*multiplier >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(f * multiplier), nil
+}
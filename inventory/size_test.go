@@ -0,0 +1,19 @@
+package inventory
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	cases := []struct {
+		s       string
+		want    int64
+		wantErr bool
+	}{{"0", 0, false}, {"500", 500, false}, {"10KB", 10000, false}, {"10mb", 10000000, false}, {"1.5G", 1500000000, false}, {"2 TB", 2000000000000, false}, {"", 0, true}, {"MB", 0, true}, {"-1", 0, true},
+		{"NaN", 0, true}, {"Inf", 0, true}, {"+InfKB", 0, true}, {"1e30", 0, true}, {"10000000TB", 0, true}, {"9223372036854775807", 0, true}}
+
+	for _, c := range cases {
+		got, err := ParseSize(c.s)
+		if (err != nil) != c.wantErr || got != c.want {
+			t.Errorf("ParseSize(%q) == %v, %v, want %v", c.s, got, err, c.want)
+		}
+	}
+}
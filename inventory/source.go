@@ -0,0 +1,216 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// Kinds of Source
+const (
+	SourceLaptop   = "laptop"
+	SourceNAS      = "nas"
+	SourceExternal = "external"
+	SourceCloud    = "cloud"
+)
+
+// SourceKinds are the kinds a Source can have, besides none
+var SourceKinds = []string{SourceLaptop, SourceNAS, SourceExternal, SourceCloud}
+
+var (
+	// ErrSourceNotFound is returned when changing a source that isn't registered
+	ErrSourceNotFound = errors.New("source not found")
+	// ErrSourceExists is returned when adding or renaming to a source that is already registered
+	ErrSourceExists = errors.New("source already exists")
+)
+
+// Source is a registered device or location whose files are indexed
+type Source struct {
+	Name        string
+	Description string
+	Kind        string
+	// Root is the folder paths on the source are stored relative to
+	Root string
+	// VolumeUUID and VolumeLabel identify the filesystem of a removable drive
+	VolumeUUID  string
+	VolumeLabel string
+	Created     time.Time
+	// LastIndexed is when the latest complete index run of the source finished, NumFiles and SizeTotal
+	// count its current files. They are computed when loading the source and ignored when saving it.
+	LastIndexed time.Time
+	NumFiles    int
+	SizeTotal   int64
+}
+
+const sourceQuery = `
+	SELECT s.name, s.description, s.kind, s.root, s.volume_uuid, s.volume_label, s.created, r.finished, coalesce(f.num_files, 0), coalesce(f.size_total, 0)
+	FROM sources s
+	LEFT JOIN index_runs r ON r.id = (SELECT max(id) FROM index_runs WHERE source = s.name and status = 'complete')
+	LEFT JOIN (
+		SELECT source, count(*) AS num_files, sum(size) AS size_total FROM found_files WHERE status = '' GROUP BY source
+	) f ON f.source = s.name`
+
+// GetSources returns every registered source, ordered by name
+func (s *SQLiteStore) GetSources(ctx context.Context) ([]Source, error) {
+	return s.querySources(ctx, sourceQuery+" ORDER BY s.name")
+}
+
+// GetSource returns the registered source called name, or nil
+func (s *SQLiteStore) GetSource(ctx context.Context, name string) (*Source, error) {
+	srcs, err := s.querySources(ctx, sourceQuery+" WHERE s.name = ?", name)
+	if err != nil || len(srcs) == 0 {
+		return nil, err
+	}
+	return &srcs[0], nil
+}
+
+func (s *SQLiteStore) querySources(ctx context.Context, query string, args ...interface{}) ([]Source, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var srcs []Source
+	for rows.Next() {
+		var src Source
+		var lastIndexed sql.NullTime
+		err := rows.Scan(&src.Name, &src.Description, &src.Kind, &src.Root, &src.VolumeUUID, &src.VolumeLabel, &src.Created, &lastIndexed, &src.NumFiles, &src.SizeTotal)
+		if err != nil {
+			return nil, err
+		}
+		src.LastIndexed = lastIndexed.Time
+		srcs = append(srcs, src)
+	}
+	return srcs, rows.Err()
+}
+
+// AddSource registers src, returning ErrSourceExists if a source with its name already is
+func (s *SQLiteStore) AddSource(ctx context.Context, src *Source) error {
+	const sql = `
+		INSERT INTO sources (name, description, kind, root, volume_uuid, volume_label, created) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (name) DO NOTHING`
+	res, err := s.db.ExecContext(ctx, sql, src.Name, src.Description, src.Kind, src.Root, src.VolumeUUID, src.VolumeLabel, src.Created)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(res, ErrSourceExists)
+}
+
+// UpdateSource saves the description, kind, root and volume of src. Setting the root of a source
+// without one rewrites its paths, which were relative to /, relative to the root.
+func (s *SQLiteStore) UpdateSource(ctx context.Context, src *Source) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var root string
+	if err := tx.QueryRowContext(ctx, "SELECT root FROM sources WHERE name = ?", src.Name).Scan(&root); err == sql.ErrNoRows {
+		return ErrSourceNotFound
+	} else if err != nil {
+		return err
+	}
+	const sql = `UPDATE sources SET description = ?, kind = ?, root = ?, volume_uuid = ?, volume_label = ? WHERE name = ?`
+	if _, err := tx.ExecContext(ctx, sql, src.Description, src.Kind, src.Root, src.VolumeUUID, src.VolumeLabel, src.Name); err != nil {
+		return err
+	}
+	if root == "" && src.Root != "" {
+		for _, c := range pathColumns {
+			err := rewriteColumn(ctx, tx, c.table, c.column, src.Name, func(path string) (string, error) {
+				return rebasePath(path, src.Root)
+			})
+			if err != nil {
+				return fmt.Errorf("%s.%s: %w", c.table, c.column, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// pathColumns are the columns with paths relative to the root of their source
+var pathColumns = []struct{ table, column string }{
+	{"found_files", "path"},
+	{"file_hashes", "path"},
+	{"file_versions", "path"},
+	{"file_tags", "path"},
+	{"index_errors", "path"},
+	{"index_runs", "root"},
+	{"index_runs", "checkpoint"},
+}
+
+// rebasePath returns path, relative to / as the paths of sources without a root are, relative to root
+func rebasePath(path string, root string) (string, error) {
+	if path == "" || filepath.IsAbs(path) {
+		return path, nil
+	}
+	return filepath.Rel(root, filepath.Join(string(filepath.Separator), path))
+}
+
+// sourceTables are the tables with a source column, other than sources itself
+var sourceTables = []string{"found_files", "file_hashes", "file_versions", "index_runs", "index_errors", "file_tags"}
+
+// RenameSource renames the source and moves everything recorded about it to the new name
+func (s *SQLiteStore) RenameSource(ctx context.Context, name string, newName string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var exists bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM sources WHERE name = ?)", newName).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return ErrSourceExists
+	}
+	res, err := tx.ExecContext(ctx, "UPDATE sources SET name = ? WHERE name = ?", newName, name)
+	if err != nil {
+		return err
+	}
+	if err := expectOneRow(res, ErrSourceNotFound); err != nil {
+		return err
+	}
+	for _, table := range sourceTables {
+		if _, err := tx.ExecContext(ctx, "UPDATE "+table+" SET source = ? WHERE source = ?", newName, name); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RemoveSource unregisters the source and deletes everything recorded about it
+func (s *SQLiteStore) RemoveSource(ctx context.Context, name string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, "DELETE FROM sources WHERE name = ?", name)
+	if err != nil {
+		return err
+	}
+	if err := expectOneRow(res, ErrSourceNotFound); err != nil {
+		return err
+	}
+	for _, table := range sourceTables {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE source = ?", name); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// expectOneRow returns errNone if res didn't affect any rows
+func expectOneRow(res sql.Result, errNone error) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errNone
+	}
+	return nil
+}
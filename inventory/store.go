@@ -0,0 +1,91 @@
+package inventory
+
+import (
+	"context"
+	"time"
+)
+
+// Store persists found files and answers the queries used to index and check sources
+type Store interface {
+	// GetFoundFile returns the current version of the file at path, or nil
+	GetFoundFile(ctx context.Context, source string, path string) (*FoundFile, error)
+	// GetFoundFileWithHash returns the version of the file at path identified by hash, or nil
+	GetFoundFileWithHash(ctx context.Context, source string, path string, hash string) (*FoundFile, error)
+	// GetFoundFileWithHashes returns the version of the file at path with any of the digests, keyed by algorithm, or nil
+	GetFoundFileWithHashes(ctx context.Context, source string, path string, hashes map[string]string) (*FoundFile, error)
+	// GetFoundFileHashes returns every digest stored for the file, keyed by algorithm
+	GetFoundFileHashes(ctx context.Context, source string, path string, hash string) (map[string]string, error)
+	// GetFoundFileWithSizeAndModified returns the current version of the file at path if it has the size and modified time, or nil
+	GetFoundFileWithSizeAndModified(ctx context.Context, source string, path string, size int64, modified time.Time) (*FoundFile, error)
+	// GetFoundFileOtherSourcesWithSameContent returns current files in other sources sharing a digest with ff
+	GetFoundFileOtherSourcesWithSameContent(ctx context.Context, ff *FoundFile) ([]FoundFile, error)
+	// GetSimilarFoundFileSourcesWithSizeAndModified returns current files in any source with the size and modified time
+	GetSimilarFoundFileSourcesWithSizeAndModified(ctx context.Context, size int64, modified time.Time) ([]FoundFile, error)
+	// GetFoundFilesUnderPath returns the current files at or below root, ordered by path
+	GetFoundFilesUnderPath(ctx context.Context, source string, root string) ([]FoundFile, error)
+	// GetFoundFilesUnderPathAfter returns up to limit current files of source at or below root with
+	// a path after after, ordered by path, for going through them a page at a time. It returns no
+	// files once there are none left.
+	GetFoundFilesUnderPathAfter(ctx context.Context, source string, root string, after string, limit int) ([]FoundFile, error)
+	// GetMissingFoundFilesUnderPath returns the missing files at or below root, ordered by path
+	GetMissingFoundFilesUnderPath(ctx context.Context, source string, root string) ([]FoundFile, error)
+	// GetDuplicateGroups returns the current files sharing a hash with at least one other file
+	GetDuplicateGroups(ctx context.Context, source string, minSize int64, fileType string) ([]DuplicateGroup, error)
+	// FindFoundFiles returns the files matching q in any source, ordered by source and path
+	FindFoundFiles(ctx context.Context, q FileQuery) ([]FoundFile, error)
+	// GetFileVersions returns every version seen at path
+	GetFileVersions(ctx context.Context, source string, path string) ([]FileVersion, error)
+	// GetIndexRuns returns the runs of source, most recent first
+	GetIndexRuns(ctx context.Context, source string) ([]IndexRun, error)
+	// GetResumableIndexRun returns the most recent incomplete run of source not followed by another run over the same root, or nil
+	GetResumableIndexRun(ctx context.Context, source string) (*IndexRun, error)
+	// GetIndexErrorsUnderPath returns the errors at or below root from the latest run of each source over each root
+	GetIndexErrorsUnderPath(ctx context.Context, source string, root string) ([]IndexError, error)
+
+	// Save stores ff as the current version of the file at its path
+	Save(ctx context.Context, ff *FoundFile) error
+	// SaveAll stores each file as the current version at its path, all or none of them are saved
+	SaveAll(ctx context.Context, ffs []*FoundFile) error
+	// SaveVerified records the result of verifying ff
+	SaveVerified(ctx context.Context, ff *FoundFile, status string, verified time.Time) error
+	// SaveMissing marks ff as no longer existing at its path
+	SaveMissing(ctx context.Context, ff *FoundFile, missingSince time.Time) error
+	// SaveCategories stores the category, subcategory and label of each file, all or none of them are saved
+	SaveCategories(ctx context.Context, ffs []*FoundFile) error
+
+	// GetTags returns the tags of the current files of source, or of every source if it is empty, ordered by name
+	GetTags(ctx context.Context, source string) ([]Tag, error)
+	// AddTags adds the tags to the paths of ffs
+	AddTags(ctx context.Context, ffs []*FoundFile, tags []string) error
+	// RemoveTags removes the tags from the paths of ffs
+	RemoveTags(ctx context.Context, ffs []*FoundFile, tags []string) error
+
+	// CreateIndexRun records the start of run, setting its ID
+	CreateIndexRun(ctx context.Context, run *IndexRun) error
+	// UpdateIndexRun records the status, checkpoint and counts of run
+	UpdateIndexRun(ctx context.Context, run *IndexRun) error
+	// SaveIndexError records a file or folder a run couldn't read
+	SaveIndexError(ctx context.Context, e *IndexError) error
+
+	// GetSources returns every registered source, ordered by name
+	GetSources(ctx context.Context) ([]Source, error)
+	// GetSource returns the registered source called name, or nil
+	GetSource(ctx context.Context, name string) (*Source, error)
+	// AddSource registers src, returning ErrSourceExists if a source with its name already is
+	AddSource(ctx context.Context, src *Source) error
+	// UpdateSource saves the description, kind, root and volume of src. Setting the root of a
+	// source without one rewrites its paths, which were relative to /, relative to the root.
+	UpdateSource(ctx context.Context, src *Source) error
+	// RenameSource renames the source and moves everything recorded about it to the new name
+	RenameSource(ctx context.Context, name string, newName string) error
+	// RemoveSource unregisters the source and deletes everything recorded about it
+	RemoveSource(ctx context.Context, name string) error
+
+	Close() error
+}
+
+var (
+	_ Store = (*SQLiteStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+	_ Store = (*RootedStore)(nil)
+)
@@ -0,0 +1,122 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func benchmarkStore(b *testing.B) *SQLiteStore {
+	b.Helper()
+	store, err := Init(context.Background(), filepath.Join(b.TempDir(), "index.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { store.Close() })
+	return store
+}
+
+func benchmarkFile(source string, i int) *FoundFile {
+	ff := testFile(source, fmt.Sprintf("/photos/%d.jpg", i), fmt.Sprintf("%032x", i))
+	ff.Size = int64(i)
+	ff.Modified = testModified.Add(time.Duration(i) * time.Second)
+	return ff
+}
+
+// populate saves n files in each of two sources, with the same content in both
+func populate(b *testing.B, store *SQLiteStore, n int) {
+	b.Helper()
+	for _, source := range []string{"laptop", "backup"} {
+		var ffs []*FoundFile
+		for i := 0; i < n; i++ {
+			ffs = append(ffs, benchmarkFile(source, i))
+		}
+		if err := store.SaveAll(context.Background(), ffs); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func dropIndexes(b *testing.B, store *SQLiteStore) {
+	b.Helper()
+	for _, index := range []string{"found_files_hash", "found_files_size_modified", "file_hashes_digest"} {
+		if _, err := store.db.Exec("DROP INDEX " + index); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSave(b *testing.B) {
+	store := benchmarkStore(b)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := store.Save(ctx, benchmarkFile("laptop", i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSaveAll(b *testing.B) {
+	for _, batchSize := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("batch=%d", batchSize), func(b *testing.B) {
+			store := benchmarkStore(b)
+			ctx := context.Background()
+			b.ResetTimer()
+			var batch []*FoundFile
+			for i := 0; i < b.N; i++ {
+				batch = append(batch, benchmarkFile("laptop", i))
+				if len(batch) == batchSize || i == b.N-1 {
+					if err := store.SaveAll(ctx, batch); err != nil {
+						b.Fatal(err)
+					}
+					batch = nil
+				}
+			}
+		})
+	}
+}
+
+// benchmarkLookups compares a query with and without the indexes on a database with 10000 files per source
+func benchmarkLookups(b *testing.B, lookup func(ctx context.Context, store *SQLiteStore, i int) error) {
+	for _, indexed := range []bool{true, false} {
+		b.Run(fmt.Sprintf("indexed=%v", indexed), func(b *testing.B) {
+			const n = 10000
+			store := benchmarkStore(b)
+			populate(b, store, n)
+			if !indexed {
+				dropIndexes(b, store)
+			}
+			ctx := context.Background()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := lookup(ctx, store, i%n); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetFoundFileOtherSourcesWithSameContent(b *testing.B) {
+	benchmarkLookups(b, func(ctx context.Context, store *SQLiteStore, i int) error {
+		ffs, err := store.GetFoundFileOtherSourcesWithSameContent(ctx, benchmarkFile("laptop", i))
+		if err == nil && len(ffs) != 1 {
+			err = fmt.Errorf("found %d files in other sources, want 1", len(ffs))
+		}
+		return err
+	})
+}
+
+func BenchmarkGetSimilarFoundFileSourcesWithSizeAndModified(b *testing.B) {
+	benchmarkLookups(b, func(ctx context.Context, store *SQLiteStore, i int) error {
+		ff := benchmarkFile("laptop", i)
+		ffs, err := store.GetSimilarFoundFileSourcesWithSizeAndModified(ctx, ff.Size, ff.Modified)
+		if err == nil && len(ffs) != 2 {
+			err = fmt.Errorf("found %d similar files, want 2", len(ffs))
+		}
+		return err
+	})
+}
@@ -0,0 +1,588 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// testStores runs f against every Store implementation
+func testStores(t *testing.T, f func(t *testing.T, store Store)) {
+	t.Run("sqlite", func(t *testing.T) {
+		store, err := Init(context.Background(), filepath.Join(t.TempDir(), "index.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		f(t, store)
+	})
+	t.Run("memory", func(t *testing.T) {
+		f(t, NewMemoryStore())
+	})
+}
+
+var testModified = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func testFile(source string, path string, hash string) *FoundFile {
+	return &FoundFile{Source: source, Path: path, Name: filepath.Base(path), Hash: hash, HashAlgorithm: "md5", Size: 5, Modified: testModified, Discovered: testModified, LastChecked: testModified}
+}
+
+func TestStoreSaveReplacesPreviousVersion(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		if err := store.Save(ctx, testFile("laptop", "/a.txt", "aaa")); err != nil {
+			t.Fatal(err)
+		}
+		edited := testFile("laptop", "/a.txt", "bbb")
+		edited.Modified = testModified.Add(time.Hour)
+		edited.LastChecked = testModified.Add(time.Hour)
+		if err := store.Save(ctx, edited); err != nil {
+			t.Fatal(err)
+		}
+
+		ff, err := store.GetFoundFile(ctx, "laptop", "/a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ff == nil || ff.Hash != "bbb" {
+			t.Errorf("GetFoundFile() == %+v, want hash bbb", ff)
+		}
+		old, err := store.GetFoundFileWithHash(ctx, "laptop", "/a.txt", "aaa")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if old == nil || old.Status != StatusReplaced {
+			t.Errorf("GetFoundFileWithHash(aaa) == %+v, want replaced", old)
+		}
+		unchanged, err := store.GetFoundFileWithSizeAndModified(ctx, "laptop", "/a.txt", 5, testModified)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if unchanged != nil {
+			t.Errorf("GetFoundFileWithSizeAndModified() == %+v, want nil for a replaced version", unchanged)
+		}
+		fvs, err := store.GetFileVersions(ctx, "laptop", "/a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fvs) != 2 || fvs[0].Hash != "aaa" || fvs[1].Hash != "bbb" {
+			t.Errorf("GetFileVersions() == %+v, want aaa then bbb", fvs)
+		}
+	})
+}
+
+func TestStoreSameContentMatchesAlgorithm(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		laptop := testFile("laptop", "/a.txt", "aaa")
+		laptop.Hashes = map[string]string{"md5": "aaa", "sha256": "sss"}
+		backup := testFile("backup", "/a.txt", "sss")
+		backup.HashAlgorithm = "sha256"
+		cloud := testFile("cloud", "/a.txt", "sss")
+		cloud.HashAlgorithm = "xxhash"
+		for _, ff := range []*FoundFile{laptop, backup, cloud} {
+			if err := store.Save(ctx, ff); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		others, err := store.GetFoundFileOtherSourcesWithSameContent(ctx, laptop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(others) != 1 || others[0].Source != "backup" {
+			t.Errorf("GetFoundFileOtherSourcesWithSameContent() == %+v, want only backup", others)
+		}
+
+		if err := store.SaveMissing(ctx, backup, testModified); err != nil {
+			t.Fatal(err)
+		}
+		others, err = store.GetFoundFileOtherSourcesWithSameContent(ctx, laptop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(others) != 0 {
+			t.Errorf("GetFoundFileOtherSourcesWithSameContent() == %+v, want missing files excluded", others)
+		}
+		missing, err := store.GetMissingFoundFilesUnderPath(ctx, "", "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(missing) != 1 || missing[0].Source != "backup" || !missing[0].MissingSince.Equal(testModified) {
+			t.Errorf("GetMissingFoundFilesUnderPath() == %+v, want backup", missing)
+		}
+	})
+}
+
+func TestStoreDuplicateGroups(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		for _, ff := range []*FoundFile{
+			testFile("laptop", "/a.txt", "aaa"),
+			testFile("laptop", "/copy/a.txt", "aaa"),
+			testFile("backup", "/a.txt", "aaa"),
+			testFile("laptop", "/b.txt", "bbb"),
+		} {
+			if err := store.Save(ctx, ff); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		groups, err := store.GetDuplicateGroups(ctx, "", 0, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(groups) != 1 || len(groups[0].Files) != 3 || groups[0].Wasted() != 10 {
+			t.Errorf("GetDuplicateGroups() == %+v, want one group of 3 files", groups)
+		}
+		groups, err = store.GetDuplicateGroups(ctx, "backup", 0, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(groups) != 0 {
+			t.Errorf("GetDuplicateGroups(backup) == %+v, want none", groups)
+		}
+	})
+}
+
+func TestStoreFindFoundFiles(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		photo := testFile("laptop", "/photos/IMG_1.jpg", "aaa")
+		photo.Extension, photo.Type, photo.Category, photo.Tags, photo.Size = "jpg", "image", "photos", []string{"2020", "family"}, 2000
+		photo.Hashes = map[string]string{"md5": "aaa", "sha256": "a256"}
+		backup := testFile("backup", "/IMG_1.jpg", "aaa")
+		backup.Extension, backup.Type = "jpg", "image"
+		doc := testFile("laptop", "/docs/notes.txt", "bbb")
+		doc.Extension, doc.Type, doc.Modified = "txt", "document", testModified.AddDate(1, 0, 0)
+		for _, ff := range []*FoundFile{photo, backup, doc} {
+			if err := store.Save(ctx, ff); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		cases := []struct {
+			name string
+			q    FileQuery
+			want []string
+		}{
+			{"all", FileQuery{}, []string{"backup:/IMG_1.jpg", "laptop:/docs/notes.txt", "laptop:/photos/IMG_1.jpg"}},
+			{"source", FileQuery{Source: "laptop", Type: "image"}, []string{"laptop:/photos/IMG_1.jpg"}},
+			{"glob", FileQuery{Name: "IMG_*"}, []string{"backup:/IMG_1.jpg", "laptop:/photos/IMG_1.jpg"}},
+			{"regexp", FileQuery{NameRegexp: regexp.MustCompile(`^notes\.`)}, []string{"laptop:/docs/notes.txt"}},
+			{"tags", FileQuery{Tags: []string{"2020", "family"}}, []string{"laptop:/photos/IMG_1.jpg"}},
+			{"size", FileQuery{MinSize: 10, MaxSize: 5000}, []string{"laptop:/photos/IMG_1.jpg"}},
+			{"modified", FileQuery{ModifiedAfter: testModified.AddDate(0, 6, 0).In(time.FixedZone("x", 3600))}, []string{"laptop:/docs/notes.txt"}},
+			{"before", FileQuery{Category: "photos", ModifiedBefore: testModified}, nil},
+			{"hash", FileQuery{Hash: "a256"}, []string{"laptop:/photos/IMG_1.jpg"}},
+			{"limit", FileQuery{Extension: "jpg", Limit: 1}, []string{"backup:/IMG_1.jpg"}},
+		}
+		for _, c := range cases {
+			ffs, err := store.FindFoundFiles(ctx, c.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, ff := range ffs {
+				got = append(got, ff.Source+":"+ff.Path)
+			}
+			if strings.Join(got, " ") != strings.Join(c.want, " ") {
+				t.Errorf("FindFoundFiles(%s) == %v, want %v", c.name, got, c.want)
+			}
+		}
+	})
+}
+
+func TestStoreTags(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		a := testFile("laptop", "/a.jpg", "aaa")
+		a.Tags = []string{"vacation"}
+		b := testFile("laptop", "/b.jpg", "bbb")
+		if err := store.SaveAll(ctx, []*FoundFile{a, b}); err != nil {
+			t.Fatal(err)
+		}
+		// Saving adds to the tags of the path, even for a new version of the file
+		a2 := testFile("laptop", "/a.jpg", "aa2")
+		a2.Tags = []string{"beach"}
+		if err := store.Save(ctx, a2); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Save(ctx, testFile("laptop", "/a.jpg", "aa2")); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.AddTags(ctx, []*FoundFile{a2, b}, []string{"sorted", "beach"}); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(b.Tags, ",") != "beach,sorted" {
+			t.Errorf("AddTags() set tags %v, want beach and sorted", b.Tags)
+		}
+		ff, err := store.GetFoundFile(ctx, "laptop", "/a.jpg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ff == nil || strings.Join(ff.Tags, ",") != "beach,sorted,vacation" {
+			t.Errorf("GetFoundFile(a.jpg) == %+v, want tags beach, sorted and vacation", ff)
+		}
+
+		if err := store.RemoveTags(ctx, []*FoundFile{b}, []string{"beach", "unknown"}); err != nil {
+			t.Fatal(err)
+		}
+		tags, err := store.GetTags(ctx, "laptop")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []Tag{{"beach", 1}, {"sorted", 2}, {"vacation", 1}}
+		if fmt.Sprint(tags) != fmt.Sprint(want) {
+			t.Errorf("GetTags(laptop) == %v, want %v", tags, want)
+		}
+		if tags, err := store.GetTags(ctx, "nas"); err != nil || len(tags) != 0 {
+			t.Errorf("GetTags(nas) == %v, %v, want none", tags, err)
+		}
+
+		if err := store.AddSource(ctx, &Source{Name: "laptop", Created: testModified}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.RenameSource(ctx, "laptop", "oldlaptop"); err != nil {
+			t.Fatal(err)
+		}
+		ffs, err := store.FindFoundFiles(ctx, FileQuery{Tags: []string{"sorted"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ffs) != 2 || ffs[0].Source != "oldlaptop" {
+			t.Errorf("FindFoundFiles(sorted) == %+v, want both files in the renamed source", ffs)
+		}
+	})
+}
+
+func TestStoreSaveCategories(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		old := testFile("laptop", "/a.jpg", "aaa")
+		ff := testFile("laptop", "/a.jpg", "bbb")
+		for _, f := range []*FoundFile{old, ff} {
+			if err := store.Save(ctx, f); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ff.Category, ff.Subcategory, ff.Label = "photos", "2015", "camera"
+		if err := store.SaveCategories(ctx, []*FoundFile{ff}); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.GetFoundFile(ctx, "laptop", "/a.jpg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got == nil || got.Category != "photos" || got.Subcategory != "2015" || got.Label != "camera" || got.Status != StatusCurrent {
+			t.Errorf("GetFoundFile(a.jpg) == %+v, want the current version categorized", got)
+		}
+		if got, err := store.GetFoundFileWithHash(ctx, "laptop", "/a.jpg", "aaa"); err != nil || got == nil || got.Category != "" {
+			t.Errorf("GetFoundFileWithHash(aaa) == %+v, %v, want the previous version unchanged", got, err)
+		}
+	})
+}
+
+func TestStoreIndexErrorsFromLatestRun(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		for i, path := range []string{"/photos/a.jpg", "/photos/b.jpg"} {
+			run := &IndexRun{Source: "laptop", Root: "/photos", Started: testModified.Add(time.Duration(i) * time.Hour)}
+			if err := store.CreateIndexRun(ctx, run); err != nil {
+				t.Fatal(err)
+			}
+			e := &IndexError{RunID: run.ID, Op: "hash", Source: "laptop", Path: path, Kind: ErrorPermission, Message: "permission denied", Occurred: run.Started}
+			if err := store.SaveIndexError(ctx, e); err != nil {
+				t.Fatal(err)
+			}
+			run.Status = RunComplete
+			run.Finished = run.Started.Add(time.Minute)
+			if err := store.UpdateIndexRun(ctx, run); err != nil {
+				t.Fatal(err)
+			}
+		}
+		other := &IndexRun{Source: "laptop", Root: "/music", Started: testModified.Add(2 * time.Hour)}
+		if err := store.CreateIndexRun(ctx, other); err != nil {
+			t.Fatal(err)
+		}
+
+		es, err := store.GetIndexErrorsUnderPath(ctx, "laptop", "/photos")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(es) != 1 || es[0].Path != "/photos/b.jpg" {
+			t.Errorf("GetIndexErrorsUnderPath() == %+v, want only b.jpg from the latest run", es)
+		}
+	})
+}
+
+func TestStoreResumableIndexRun(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		runs := []*IndexRun{
+			{Source: "laptop", Root: "/photos", Status: RunRunning},
+			{Source: "laptop", Root: "/music", Status: RunRunning},
+			{Source: "laptop", Root: "/music", Status: RunRunning},
+			{Source: "backup", Root: "/photos", Status: RunRunning},
+		}
+		for i, run := range runs {
+			run.Started = testModified.Add(time.Duration(i) * time.Hour)
+			if err := store.CreateIndexRun(ctx, run); err != nil {
+				t.Fatal(err)
+			}
+		}
+		runs[0].Status = RunInterrupted
+		runs[0].Checkpoint = "/photos/a.jpg"
+		runs[0].NumNew = 3
+		runs[2].Status = RunComplete
+		runs[2].Finished = runs[2].Started.Add(time.Minute)
+		for _, run := range runs {
+			if err := store.UpdateIndexRun(ctx, run); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		run, err := store.GetResumableIndexRun(ctx, "laptop")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if run == nil || run.ID != runs[0].ID || run.Checkpoint != "/photos/a.jpg" || run.NumNew != 3 {
+			t.Errorf("GetResumableIndexRun() == %+v, want the interrupted /photos run", run)
+		}
+		all, err := store.GetIndexRuns(ctx, "laptop")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != 3 || all[0].ID != runs[2].ID || all[0].Status != RunComplete || all[0].Finished.IsZero() {
+			t.Errorf("GetIndexRuns() == %+v, want 3 laptop runs, the complete /music run first", all)
+		}
+	})
+}
+
+func TestStoreSources(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		for _, name := range []string{"laptop", "nas"} {
+			if err := store.AddSource(ctx, &Source{Name: name, Kind: SourceLaptop, Created: testModified}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := store.AddSource(ctx, &Source{Name: "laptop", Created: testModified}); !errors.Is(err, ErrSourceExists) {
+			t.Errorf("AddSource(laptop) error == %v, want ErrSourceExists", err)
+		}
+		if err := store.Save(ctx, testFile("laptop", "/a.txt", "aaa")); err != nil {
+			t.Fatal(err)
+		}
+		run := &IndexRun{Source: "laptop", Root: "/", Started: testModified}
+		if err := store.CreateIndexRun(ctx, run); err != nil {
+			t.Fatal(err)
+		}
+		run.Status = RunComplete
+		run.Finished = testModified.Add(time.Minute)
+		if err := store.UpdateIndexRun(ctx, run); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.RenameSource(ctx, "laptop", "nas"); !errors.Is(err, ErrSourceExists) {
+			t.Errorf("RenameSource(laptop, nas) error == %v, want ErrSourceExists", err)
+		}
+		if err := store.RenameSource(ctx, "laptop", "oldlaptop"); err != nil {
+			t.Fatal(err)
+		}
+		src, err := store.GetSource(ctx, "oldlaptop")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if src == nil || src.Kind != SourceLaptop || src.NumFiles != 1 || src.SizeTotal != 5 || !src.LastIndexed.Equal(run.Finished) {
+			t.Errorf("GetSource(oldlaptop) == %+v, want the renamed laptop with 1 file", src)
+		}
+		if ff, err := store.GetFoundFile(ctx, "oldlaptop", "/a.txt"); err != nil || ff == nil {
+			t.Errorf("GetFoundFile(oldlaptop) == %+v, %v, want the renamed file", ff, err)
+		}
+		if hashes, err := store.GetFoundFileHashes(ctx, "oldlaptop", "/a.txt", "aaa"); err != nil || hashes["md5"] != "aaa" {
+			t.Errorf("GetFoundFileHashes(oldlaptop) == %v, %v, want md5 aaa", hashes, err)
+		}
+
+		if err := store.RemoveSource(ctx, "oldlaptop"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.RemoveSource(ctx, "oldlaptop"); !errors.Is(err, ErrSourceNotFound) {
+			t.Errorf("RemoveSource(oldlaptop) twice error == %v, want ErrSourceNotFound", err)
+		}
+		srcs, err := store.GetSources(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(srcs) != 1 || srcs[0].Name != "nas" {
+			t.Errorf("GetSources() == %+v, want only nas", srcs)
+		}
+		if ff, err := store.GetFoundFile(ctx, "oldlaptop", "/a.txt"); err != nil || ff != nil {
+			t.Errorf("GetFoundFile(oldlaptop) == %+v, %v, want nil after removing the source", ff, err)
+		}
+		if runs, err := store.GetIndexRuns(ctx, ""); err != nil || len(runs) != 0 {
+			t.Errorf("GetIndexRuns() == %+v, %v, want none after removing the source", runs, err)
+		}
+	})
+}
+
+func TestRootedStoreMovedSource(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		rooted := NewRootedStore(store)
+		src := &Source{Name: "backup", Kind: SourceExternal, Root: "/media/alice/Backup", Created: testModified}
+		if err := rooted.AddSource(ctx, src); err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range []string{"/media/alice/Backup/a.jpg", "/media/alice/b.jpg"} {
+			if err := rooted.Save(ctx, testFile("backup", path, "aaa")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ff, err := store.GetFoundFile(ctx, "backup", "a.jpg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ff == nil {
+			t.Error("GetFoundFile(a.jpg) == nil, want the path stored relative to the root")
+		}
+
+		// The drive is mounted somewhere else
+		src.Root = "/mnt/backup"
+		if err := rooted.UpdateSource(ctx, src); err != nil {
+			t.Fatal(err)
+		}
+		ff, err = rooted.GetFoundFile(ctx, "backup", "/mnt/backup/a.jpg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ff == nil || ff.Path != "/mnt/backup/a.jpg" {
+			t.Errorf("GetFoundFile(/mnt/backup/a.jpg) == %+v, want the file at its new mount", ff)
+		}
+		ffs, err := rooted.GetFoundFilesUnderPath(ctx, "", "/mnt/backup")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ffs) != 1 || ffs[0].Path != "/mnt/backup/a.jpg" {
+			t.Errorf("GetFoundFilesUnderPath(/mnt/backup) == %+v, want only a.jpg", ffs)
+		}
+		ffs, err = rooted.GetFoundFilesUnderPath(ctx, "backup", "/mnt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ffs) != 2 || ffs[0].Path != "/mnt/b.jpg" || ffs[1].Path != "/mnt/backup/a.jpg" {
+			t.Errorf("GetFoundFilesUnderPath(/mnt) == %+v, want b.jpg outside the root and a.jpg", ffs)
+		}
+	})
+}
+
+func TestRootedStoreSetRoot(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		rooted := NewRootedStore(store)
+		src := &Source{Name: "laptop", Created: testModified}
+		if err := rooted.AddSource(ctx, src); err != nil {
+			t.Fatal(err)
+		}
+		var ffs []*FoundFile
+		for _, path := range []string{"/home/alice/a.jpg", "/home/b.jpg"} {
+			ff := testFile("laptop", path, "aaa")
+			if err := rooted.Save(ctx, ff); err != nil {
+				t.Fatal(err)
+			}
+			ffs = append(ffs, ff)
+		}
+		if err := rooted.AddTags(ctx, ffs[:1], []string{"sorted"}); err != nil {
+			t.Fatal(err)
+		}
+		run := &IndexRun{Source: "laptop", Root: "/home/alice", Status: RunRunning, Started: testModified}
+		if err := rooted.CreateIndexRun(ctx, run); err != nil {
+			t.Fatal(err)
+		}
+		run.Status, run.Checkpoint = RunInterrupted, "/home/alice/a.jpg"
+		if err := rooted.UpdateIndexRun(ctx, run); err != nil {
+			t.Fatal(err)
+		}
+
+		// Setting the root of a source without one keeps its files where they are
+		src.Root = "/home/alice"
+		if err := rooted.UpdateSource(ctx, src); err != nil {
+			t.Fatal(err)
+		}
+		if ff, err := store.GetFoundFile(ctx, "laptop", "a.jpg"); err != nil || ff == nil {
+			t.Errorf("GetFoundFile(a.jpg) == %+v, %v, want the path stored relative to the new root", ff, err)
+		}
+		found, err := rooted.GetFoundFilesUnderPath(ctx, "laptop", "/home")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != 2 || found[0].Path != "/home/alice/a.jpg" || found[1].Path != "/home/b.jpg" {
+			t.Errorf("GetFoundFilesUnderPath(/home) == %+v, want a.jpg and b.jpg at their paths", found)
+		}
+		found, err = rooted.FindFoundFiles(ctx, FileQuery{Tags: []string{"sorted"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != 1 || found[0].Path != "/home/alice/a.jpg" {
+			t.Errorf("FindFoundFiles(sorted) == %+v, want a.jpg", found)
+		}
+		resumable, err := rooted.GetResumableIndexRun(ctx, "laptop")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resumable == nil || resumable.Root != "/home/alice" || resumable.Checkpoint != "/home/alice/a.jpg" {
+			t.Errorf("GetResumableIndexRun(laptop) == %+v, want the run over /home/alice", resumable)
+		}
+	})
+}
+
+func TestRootedStoreFoundFilesUnderPathAfter(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		rooted := NewRootedStore(store)
+		if err := rooted.AddSource(ctx, &Source{Name: "laptop", Root: "/home/alice", Created: testModified}); err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range []string{"/home/alice/a.jpg", "/home/alice/b/c.jpg", "/home/alice/d.jpg", "/home/bob/e.jpg", "/srv/f.jpg"} {
+			if err := rooted.Save(ctx, testFile("laptop", path, "aaa")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, c := range []struct {
+			root string
+			want string
+		}{
+			{"/home/alice", "/home/alice/a.jpg,/home/alice/b/c.jpg|/home/alice/d.jpg"},
+			// Paths outside the root are stored starting with "..", which comes first
+			{"/home", "/home/bob/e.jpg|/home/alice/a.jpg,/home/alice/b/c.jpg|/home/alice/d.jpg"},
+			{"/home/alice/b", "/home/alice/b/c.jpg"},
+		} {
+			var pages []string
+			after := ""
+			for {
+				ffs, err := rooted.GetFoundFilesUnderPathAfter(ctx, "laptop", c.root, after, 2)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(ffs) == 0 {
+					break
+				}
+				var paths []string
+				for _, ff := range ffs {
+					paths = append(paths, ff.Path)
+				}
+				pages = append(pages, strings.Join(paths, ","))
+				after = ffs[len(ffs)-1].Path
+			}
+			if got := strings.Join(pages, "|"); got != c.want {
+				t.Errorf("pages of the files under %s are %s, want %s", c.root, got, c.want)
+			}
+		}
+	})
+}
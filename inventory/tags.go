@@ -0,0 +1,157 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+)
+
+// Tag is a tag with the number of current files that have it
+type Tag struct {
+	Name     string
+	NumFiles int
+}
+
+// SplitTags returns the sorted tags in the comma separated list s
+func SplitTags(s string) []string {
+	return MergeTags(nil, strings.Split(s, ","))
+}
+
+// MergeTags returns the tags in either tags or add, sorted and without duplicates or empty tags
+func MergeTags(tags []string, add []string) []string {
+	seen := map[string]bool{}
+	var merged []string
+	for _, list := range [][]string{tags, add} {
+		for _, t := range list {
+			t = strings.TrimSpace(t)
+			if t != "" && !seen[t] {
+				seen[t] = true
+				merged = append(merged, t)
+			}
+		}
+	}
+	sort.Strings(merged)
+	return merged
+}
+
+func addTags(ctx context.Context, tx *sql.Tx, source string, path string, tags []string) error {
+	const sql = `INSERT OR IGNORE INTO file_tags (source, path, tag) VALUES (?, ?, ?)`
+	for _, tag := range tags {
+		if _, err := tx.ExecContext(ctx, sql, source, path, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveTags moves the comma separated tags of found_files into file_tags
+func moveTags(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, "SELECT DISTINCT source, path, tags FROM found_files WHERE tags != ''")
+	if err != nil {
+		return err
+	}
+	type tagged struct {
+		source, path, tags string
+	}
+	var files []tagged
+	for rows.Next() {
+		var f tagged
+		if err := rows.Scan(&f.source, &f.path, &f.tags); err != nil {
+			rows.Close()
+			return err
+		}
+		files = append(files, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := addTags(ctx, tx, f.source, f.path, SplitTags(f.tags)); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE found_files SET tags = ''")
+	return err
+}
+
+// AddTags adds the tags to the paths of ffs, in a single transaction
+func (s *SQLiteStore) AddTags(ctx context.Context, ffs []*FoundFile, tags []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, ff := range ffs {
+		if err := addTags(ctx, tx, ff.Source, ff.Path, tags); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, ff := range ffs {
+		ff.Tags = MergeTags(ff.Tags, tags)
+	}
+	return nil
+}
+
+// RemoveTags removes the tags from the paths of ffs, in a single transaction
+func (s *SQLiteStore) RemoveTags(ctx context.Context, ffs []*FoundFile, tags []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	const sql = `DELETE FROM file_tags WHERE source = ? and path = ? and tag = ?`
+	for _, ff := range ffs {
+		for _, tag := range tags {
+			if _, err := tx.ExecContext(ctx, sql, ff.Source, ff.Path, tag); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, ff := range ffs {
+		ff.Tags = removeTags(ff.Tags, tags)
+	}
+	return nil
+}
+
+// removeTags returns tags without the ones in remove
+func removeTags(tags []string, remove []string) []string {
+	var kept []string
+	for _, t := range tags {
+		if !hasTags(remove, []string{t}) {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// GetTags returns the tags of the current files of source, or of every source if it is empty,
+// ordered by name
+func (s *SQLiteStore) GetTags(ctx context.Context, source string) ([]Tag, error) {
+	const sql = `
+		SELECT tag, count(*) FROM file_tags JOIN found_files
+			ON found_files.source = file_tags.source and found_files.path = file_tags.path and found_files.status = ''
+		WHERE (? = '' or file_tags.source = ?)
+		GROUP BY tag ORDER BY tag`
+	rows, err := s.db.QueryContext(ctx, sql, source, source)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tags []Tag
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.Name, &t.NumFiles); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
@@ -1,15 +1,18 @@
 package main
 
 import (
-	"crypto/md5"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
-	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/roh/fileinventory/inventory"
@@ -17,94 +20,690 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// errCorruptFiles is returned by verify when files no longer match their stored hash
+var errCorruptFiles = errors.New("corrupt files found")
+
+// whereUsage describes the -where flag, which takes the queries of the query command
+const whereUsage = "only include indexed files matching this query, i.e. 'type:image size>10MB -tag:sorted'"
+
+// tagsUsage describes the -tags flag of the listing commands
+const tagsUsage = "only include indexed files with all of these comma separated tags"
+
+// errSourceRequired is returned by commands that need a source when none was given or found
+var errSourceRequired = errors.New("please specify a source flag, i.e. -source mylaptop, or register the source with 'sources add -root'")
+
 func main() {
-	path, err := os.Getwd()
-	if err != nil {
-		log.Fatal(err)
+	// Flags before the command apply to every command, i.e. fileinventory -format json ls
+	globalCmd := flag.NewFlagSet("fileinventory", flag.ExitOnError)
+	format := addFormatFlag(globalCmd, formatText)
+	globalCmd.Parse(os.Args[1:])
+	if globalCmd.NArg() < 1 {
+		fmt.Println("expected 'index', 'ls', 'health', 'verify', 'backup', 'dupes', 'find', 'query', 'tag', 'recategorize', 'mismatches', 'history', 'runs', 'sources' or 'db' command")
+		os.Exit(1)
 	}
-	if len(os.Args) < 2 {
-		fmt.Println("expected 'index', 'ls', or 'health' command")
+	// The first Ctrl-C cancels the command so it can save its progress, the second quits immediately
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		fmt.Fprintln(os.Stderr, "\nInterrupted, saving progress. Press Ctrl-C again to quit immediately")
+		cancel()
+		<-sigs
+		os.Exit(130)
+	}()
+	err := run(ctx, globalCmd.Arg(0), globalCmd.Args()[1:], *format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		if errors.Is(err, errCorruptFiles) {
+			os.Exit(2)
+		}
 		os.Exit(1)
 	}
+}
+
+// run runs cmd with its args, defaultFormat is the output format given before the command
+func run(ctx context.Context, cmd string, args []string, defaultFormat string) error {
+	path, err := os.Getwd()
+	if err != nil {
+		return err
+	}
 
-	switch os.Args[1] {
+	switch cmd {
 	case "index":
 		indexCmd := flag.NewFlagSet("index", flag.ExitOnError)
 		source := indexCmd.String("source", "", "")
 		label := indexCmd.String("label", "", "")
 		category := indexCmd.String("category", "", "")
 		subcategory := indexCmd.String("subcategory", "", "")
-		tags := indexCmd.String("tags", "", "")
+		tags := indexCmd.String("tags", "", "comma separated tags to add to the files, keeping the tags they have")
+		rulesFlag := indexCmd.String("rules", "", "categorization rules file - defaults to $HOMEDIR/"+rulesFileName)
 		dbPath := indexCmd.String("db", "", "database path - defaults to $HOMEDIR/index.db")
 		indexReindexDiscovered := indexCmd.Bool("reindex", false, "reindex previously discovered files that haven't changed")
-		indexCmd.Parse(os.Args[2:])
+		workers := indexCmd.Int("workers", runtime.NumCPU(), "number of files to hash concurrently")
+		batchSize := indexCmd.Int("batch", 500, "number of files saved to the database per transaction")
+		hashFlag := indexCmd.String("hash", "md5", "comma separated hash algorithms, the first identifies the file ("+strings.Join(HashAlgorithms(), ", ")+")")
+		filterFlags := addWalkFilterFlags(indexCmd)
+		resume := indexCmd.Bool("resume", false, "continue the latest incomplete run of the source with its flags")
+		format := addFormatFlag(indexCmd, defaultFormat)
+		indexCmd.Parse(args)
+		if err := checkFormat(*format); err != nil {
+			return err
+		}
 
-		inventory.Init(*dbPath)
-		defer inventory.Close()
+		store, err := openStore(ctx, *dbPath)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		vol, err := detectVolume(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Warning: couldn't detect the drive of the folder:", err)
+		}
+		*source, err = resolveVolumeSource(ctx, store, *source, path, vol)
+		if err != nil {
+			return err
+		}
 		if *source == "" {
-			log.Fatal("Please specify a source flag, i.e. -source mylaptop")
+			return errSourceRequired
+		}
+		if err := registerVolume(ctx, store, *source, path, vol); err != nil {
+			return err
+		}
+		var resumeRun *inventory.IndexRun
+		if *resume {
+			resumeRun, err = store.GetResumableIndexRun(ctx, *source)
+			if err != nil {
+				return err
+			}
+			if resumeRun == nil {
+				return fmt.Errorf("no incomplete index run of source %s to resume", *source)
+			}
+			var runArgs []string
+			if err := json.Unmarshal([]byte(resumeRun.Flags), &runArgs); err != nil {
+				return fmt.Errorf("reading the flags of index run %d: %w", resumeRun.ID, err)
+			}
+			// Use the run's flags, overridden by any given now
+			indexCmd.Parse(runArgs)
+			indexCmd.Parse(args)
+			path = resumeRun.Root
+		}
+		hs, err := ParseHashers(*hashFlag)
+		if err != nil {
+			return err
+		}
+		filter, err := filterFlags.newWalkFilter(path)
+		if err != nil {
+			return err
 		}
-		indexPath(*source, path, *category, *subcategory, *label, *tags, *indexReindexDiscovered)
+		if err := filter.setSource(ctx, store, *source); err != nil {
+			return err
+		}
+		flags, err := json.Marshal(args)
+		if err != nil {
+			return err
+		}
+		rulesFile, explicit, err := rulesPath(*rulesFlag)
+		if err != nil {
+			return err
+		}
+		rules, err := readRulesFile(rulesFile, explicit)
+		if err != nil {
+			return err
+		}
+		root, err := sourceRoot(ctx, store, *source)
+		if err != nil {
+			return err
+		}
+		opts := indexOptions{
+			rules:             rules,
+			root:              root,
+			category:          *category,
+			subcategory:       *subcategory,
+			label:             *label,
+			tags:              inventory.SplitTags(*tags),
+			reindexDiscovered: *indexReindexDiscovered,
+			workers:           *workers,
+			hashers:           hs,
+			batchSize:         *batchSize,
+			filter:            filter,
+			flags:             string(flags),
+			resume:            resumeRun,
+			format:            *format,
+		}
+		return indexPath(ctx, store, *source, path, opts)
 	case "ls":
 		lsCmd := flag.NewFlagSet("ls", flag.ExitOnError)
 		source := lsCmd.String("source", "", "")
 		dbPath := lsCmd.String("db", "", "database path - defaults to $HOMEDIR/index.db")
 		new := lsCmd.Bool("new", false, "")
-		lsCmd.Parse(os.Args[2:])
+		missing := lsCmd.Bool("missing", false, "list indexed files that no longer exist")
+		listErrors := lsCmd.Bool("errors", false, "list files and folders the latest index runs couldn't read")
+		filterFlags := addWalkFilterFlags(lsCmd)
+		whereQuery := lsCmd.String("where", "", whereUsage)
+		tags := lsCmd.String("tags", "", tagsUsage)
+		format := addFormatFlag(lsCmd, defaultFormat)
+		lsCmd.Parse(args)
+		if err := checkFormat(*format); err != nil {
+			return err
+		}
 
-		inventory.Init(*dbPath)
-		defer inventory.Close()
+		filter, err := filterFlags.newWalkFilter(path)
+		if err != nil {
+			return err
+		}
+		where, err := parseWhere(*whereQuery)
+		if err != nil {
+			return err
+		}
+		store, err := openStore(ctx, *dbPath)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		*source, err = resolveSource(ctx, store, *source, path)
+		if err != nil {
+			return err
+		}
+		if err := filter.setSource(ctx, store, *source); err != nil {
+			return err
+		}
+		if q := (inventory.FileQuery{Source: *source, Where: where, Tags: inventory.SplitTags(*tags)}); q.Where != nil || q.Tags != nil {
+			if filter.where, err = whereFiles(ctx, store, q); err != nil {
+				return err
+			}
+		}
 		if *new {
-			checkNewFiles(*source, path)
-		} else {
-			listFiles(*source, path)
+			return checkNewFiles(ctx, store, *source, path, filter, *format)
+		} else if *missing {
+			return listMissingFiles(ctx, store, *source, path, *format)
+		} else if *listErrors {
+			return listIndexErrors(ctx, store, *source, path, *format)
 		}
+		return listFiles(ctx, store, *source, path, filter, *format)
 	case "health":
 		healthCmd := flag.NewFlagSet("health", flag.ExitOnError)
 		source := healthCmd.String("source", "", "")
 		dbPath := healthCmd.String("db", "", "database path - defaults to $HOMEDIR/index.db")
-		healthCmd.Parse(os.Args[2:])
+		policyPath := healthCmd.String("policy", "", "redundancy policy file - defaults to $HOMEDIR/"+policyFileName)
+		filterFlags := addWalkFilterFlags(healthCmd)
+		whereQuery := healthCmd.String("where", "", whereUsage)
+		tags := healthCmd.String("tags", "", tagsUsage)
+		format := addFormatFlag(healthCmd, defaultFormat)
+		healthCmd.Parse(args)
+		if err := checkFormat(*format); err != nil {
+			return err
+		}
+
+		filter, err := filterFlags.newWalkFilter(path)
+		if err != nil {
+			return err
+		}
+		where, err := parseWhere(*whereQuery)
+		if err != nil {
+			return err
+		}
+		store, err := openStore(ctx, *dbPath)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		*source, err = resolveSource(ctx, store, *source, path)
+		if err != nil {
+			return err
+		}
+		if err := filter.setSource(ctx, store, *source); err != nil {
+			return err
+		}
+		if q := (inventory.FileQuery{Source: *source, Where: where, Tags: inventory.SplitTags(*tags)}); q.Where != nil || q.Tags != nil {
+			if filter.where, err = whereFiles(ctx, store, q); err != nil {
+				return err
+			}
+		}
+		root, err := sourceRoot(ctx, store, *source)
+		if err != nil {
+			return err
+		}
+		explicit := *policyPath != ""
+		if !explicit {
+			homeDir, err := os.UserHomeDir()
+			if err != nil {
+				return err
+			}
+			*policyPath = filepath.Join(homeDir, policyFileName)
+		}
+		policies, err := readPolicyFile(*policyPath, root, explicit)
+		if err != nil {
+			return err
+		}
+		return checkHealthFiles(ctx, store, *source, path, filter, policies, *format)
+	case "verify":
+		verifyCmd := flag.NewFlagSet("verify", flag.ExitOnError)
+		source := verifyCmd.String("source", "", "")
+		dbPath := verifyCmd.String("db", "", "database path - defaults to $HOMEDIR/index.db")
+		workers := verifyCmd.Int("workers", runtime.NumCPU(), "number of files to hash concurrently")
+		filterFlags := addWalkFilterFlags(verifyCmd)
+		format := addFormatFlag(verifyCmd, defaultFormat)
+		verifyCmd.Parse(args)
+		if err := checkFormat(*format); err != nil {
+			return err
+		}
+
+		filter, err := filterFlags.newWalkFilter(path)
+		if err != nil {
+			return err
+		}
+		store, err := openStore(ctx, *dbPath)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		*source, err = resolveSource(ctx, store, *source, path)
+		if err != nil {
+			return err
+		}
+		if err := filter.setSource(ctx, store, *source); err != nil {
+			return err
+		}
+		if *source == "" {
+			return errSourceRequired
+		}
+		return verifyPath(ctx, store, *source, path, *workers, filter, *format)
+	case "backup":
+		backupCmd := flag.NewFlagSet("backup", flag.ExitOnError)
+		source := backupCmd.String("source", "", "")
+		dbPath := backupCmd.String("db", "", "database path - defaults to $HOMEDIR/index.db")
+		to := backupCmd.String("to", "", "folder to copy the files into, keeping their path relative to the source root")
+		targetSource := backupCmd.String("target-source", "", "source the copies are indexed under")
+		dryRun := backupCmd.Bool("dry-run", false, "only print the files that would be copied")
+		filterFlags := addWalkFilterFlags(backupCmd)
+		format := addFormatFlag(backupCmd, defaultFormat)
+		backupCmd.Parse(args)
+		if err := checkFormat(*format); err != nil {
+			return err
+		}
+
+		if *to == "" {
+			return errors.New("please specify the folder to copy the files into, i.e. -to /mnt/backup")
+		}
+		*to, err = filepath.Abs(*to)
+		if err != nil {
+			return err
+		}
+		if isUnder(*to, path) {
+			return fmt.Errorf("%s is inside the folder being backed up", *to)
+		}
+		filter, err := filterFlags.newWalkFilter(path)
+		if err != nil {
+			return err
+		}
+		store, err := openStore(ctx, *dbPath)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		*source, err = resolveSource(ctx, store, *source, path)
+		if err != nil {
+			return err
+		}
+		if err := filter.setSource(ctx, store, *source); err != nil {
+			return err
+		}
+		if *source == "" {
+			return errSourceRequired
+		}
+		vol, err := detectVolume(*to)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Warning: couldn't detect the drive of the folder:", err)
+		}
+		*targetSource, err = resolveVolumeSource(ctx, store, *targetSource, *to, vol)
+		if err != nil {
+			return err
+		}
+		if *targetSource == "" {
+			return errors.New("please specify the source the copies are indexed under, i.e. -target-source backupdrive")
+		}
+		if *targetSource == *source {
+			return fmt.Errorf("the target source must be another source than %s", *source)
+		}
+		if !*dryRun {
+			if err := registerVolume(ctx, store, *targetSource, *to, vol); err != nil {
+				return err
+			}
+		}
+		return backupPath(ctx, store, *source, path, backupOptions{to: *to, targetSource: *targetSource, dryRun: *dryRun, filter: filter, format: *format})
+	case "dupes":
+		dupesCmd := flag.NewFlagSet("dupes", flag.ExitOnError)
+		source := dupesCmd.String("source", "", "only find duplicates within this source")
+		dbPath := dupesCmd.String("db", "", "database path - defaults to $HOMEDIR/index.db")
+		minSize := dupesCmd.String("min-size", "1", "ignore files smaller than this size, i.e. 10MB")
+		fileType := dupesCmd.String("type", "", "only include files of this type, i.e. image")
+		whereQuery := dupesCmd.String("where", "", whereUsage)
+		tags := dupesCmd.String("tags", "", tagsUsage)
+		format := addFormatFlag(dupesCmd, defaultFormat)
+		dupesCmd.Parse(args)
+		if err := checkFormat(*format); err != nil {
+			return err
+		}
+
+		size, err := inventory.ParseSize(*minSize)
+		if err != nil {
+			return err
+		}
+		where, err := parseWhere(*whereQuery)
+		if err != nil {
+			return err
+		}
+		store, err := openStore(ctx, *dbPath)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		if err := checkSource(ctx, store, *source); err != nil {
+			return err
+		}
+		return listDuplicates(ctx, store, *source, size, *fileType, inventory.FileQuery{Where: where, Tags: inventory.SplitTags(*tags)}, *format)
+	case "find":
+		findCmd := flag.NewFlagSet("find", flag.ExitOnError)
+		source := findCmd.String("source", "", "only find files in this source")
+		dbPath := findCmd.String("db", "", "database path - defaults to $HOMEDIR/index.db")
+		var opts findOptions
+		findCmd.StringVar(&opts.name, "name", "", "file name glob, i.e. 'IMG_*.jpg'")
+		findCmd.StringVar(&opts.regex, "regex", "", "regular expression the file name matches")
+		extension := findCmd.String("ext", "", "file extension, without the dot, i.e. jpg")
+		fileType := findCmd.String("type", "", "file type, i.e. image")
+		category := findCmd.String("category", "", "")
+		subcategory := findCmd.String("subcategory", "", "")
+		label := findCmd.String("label", "", "")
+		findCmd.StringVar(&opts.tags, "tags", "", "comma separated tags the file has all of")
+		findCmd.StringVar(&opts.minSize, "min-size", "", "smallest size, i.e. 10MB")
+		findCmd.StringVar(&opts.maxSize, "max-size", "", "largest size, i.e. 1GB")
+		findCmd.StringVar(&opts.modifiedAfter, "modified-after", "", "modified on or after this date, i.e. 2020-01-02")
+		findCmd.StringVar(&opts.modifiedBefore, "modified-before", "", "modified before this date")
+		findCmd.StringVar(&opts.discoveredAfter, "discovered-after", "", "first indexed on or after this date")
+		findCmd.StringVar(&opts.discoveredBefore, "discovered-before", "", "first indexed before this date")
+		hash := findCmd.String("hash", "", "hash of the file, with any algorithm")
+		missing := findCmd.Bool("missing", false, "include files that are no longer found")
+		limit := findCmd.Int("limit", 0, "most files to list, 0 for all")
+		format := addFormatFlag(findCmd, defaultFormat)
+		findCmd.Parse(args)
+		if err := checkFormat(*format); err != nil {
+			return err
+		}
+
+		q, err := opts.query(inventory.FileQuery{Source: *source, Extension: strings.TrimPrefix(strings.ToLower(*extension), "."), Type: *fileType, Category: *category,
+			Subcategory: *subcategory, Label: *label, Hash: *hash, Missing: *missing, Limit: *limit})
+		if err != nil {
+			return err
+		}
+		store, err := openStore(ctx, *dbPath)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		if err := checkSource(ctx, store, *source); err != nil {
+			return err
+		}
+		return findFiles(ctx, store, q, *format)
+	case "query":
+		queryCmd := flag.NewFlagSet("query", flag.ExitOnError)
+		dbPath := queryCmd.String("db", "", "database path - defaults to $HOMEDIR/index.db")
+		missing := queryCmd.Bool("missing", false, "include files that are no longer found")
+		limit := queryCmd.Int("limit", 0, "most files to list, 0 for all")
+		format := addFormatFlag(queryCmd, defaultFormat)
+		queryCmd.Parse(args)
+		if err := checkFormat(*format); err != nil {
+			return err
+		}
+
+		if queryCmd.NArg() == 0 {
+			return errors.New("please specify a query, i.e. fileinventory query type:image size>10MB, with -- before it if it starts with a -")
+		}
+		where, err := inventory.ParseQuery(strings.Join(queryCmd.Args(), " "))
+		if err != nil {
+			return err
+		}
+		store, err := openStore(ctx, *dbPath)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		return findFiles(ctx, store, inventory.FileQuery{Where: where, Missing: *missing, Limit: *limit}, *format)
+	case "tag":
+		return runTagCommand(ctx, args, defaultFormat)
+	case "recategorize":
+		recategorizeCmd := flag.NewFlagSet("recategorize", flag.ExitOnError)
+		source := recategorizeCmd.String("source", "", "")
+		dbPath := recategorizeCmd.String("db", "", "database path - defaults to $HOMEDIR/index.db")
+		rulesFlag := recategorizeCmd.String("rules", "", "categorization rules file - defaults to $HOMEDIR/"+rulesFileName)
+		whereQuery := recategorizeCmd.String("where", "", whereUsage)
+		dryRun := recategorizeCmd.Bool("dry-run", false, "print what would change without saving it")
+		format := addFormatFlag(recategorizeCmd, defaultFormat)
+		recategorizeCmd.Parse(args)
+		if err := checkFormat(*format); err != nil {
+			return err
+		}
+
+		where, err := parseWhere(*whereQuery)
+		if err != nil {
+			return err
+		}
+		rulesFile, explicit, err := rulesPath(*rulesFlag)
+		if err != nil {
+			return err
+		}
+		rules, err := readRulesFile(rulesFile, explicit)
+		if err != nil {
+			return err
+		}
+		if rules == nil {
+			return fmt.Errorf("no categorization rules in %s, see -rules", rulesFile)
+		}
+		store, err := openStore(ctx, *dbPath)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		if err := checkSource(ctx, store, *source); err != nil {
+			return err
+		}
+		return recategorize(ctx, store, *source, where, rules, *dryRun, *format)
+	case "mismatches":
+		mismatchesCmd := flag.NewFlagSet("mismatches", flag.ExitOnError)
+		source := mismatchesCmd.String("source", "", "")
+		dbPath := mismatchesCmd.String("db", "", "database path - defaults to $HOMEDIR/index.db")
+		whereQuery := mismatchesCmd.String("where", "", whereUsage)
+		format := addFormatFlag(mismatchesCmd, defaultFormat)
+		mismatchesCmd.Parse(args)
+		if err := checkFormat(*format); err != nil {
+			return err
+		}
+
+		where, err := parseWhere(*whereQuery)
+		if err != nil {
+			return err
+		}
+		store, err := openStore(ctx, *dbPath)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		if err := checkSource(ctx, store, *source); err != nil {
+			return err
+		}
+		return listMismatches(ctx, store, *source, where, *format)
+	case "history":
+		historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
+		source := historyCmd.String("source", "", "only show history from this source")
+		dbPath := historyCmd.String("db", "", "database path - defaults to $HOMEDIR/index.db")
+		format := addFormatFlag(historyCmd, defaultFormat)
+		historyCmd.Parse(args)
+		if err := checkFormat(*format); err != nil {
+			return err
+		}
 
-		inventory.Init(*dbPath)
-		defer inventory.Close()
-		checkHealthFiles(*source, path)
+		if historyCmd.NArg() != 1 {
+			return errors.New("please specify a file, i.e. fileinventory history photo.jpg")
+		}
+		filePath, err := filepath.Abs(historyCmd.Arg(0))
+		if err != nil {
+			return err
+		}
+		store, err := openStore(ctx, *dbPath)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		if err := checkSource(ctx, store, *source); err != nil {
+			return err
+		}
+		return showHistory(ctx, store, *source, filePath, *format)
+	case "runs":
+		runsCmd := flag.NewFlagSet("runs", flag.ExitOnError)
+		source := runsCmd.String("source", "", "")
+		dbPath := runsCmd.String("db", "", "database path - defaults to $HOMEDIR/index.db")
+		format := addFormatFlag(runsCmd, defaultFormat)
+		runsCmd.Parse(args)
+		if err := checkFormat(*format); err != nil {
+			return err
+		}
+
+		store, err := openStore(ctx, *dbPath)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		if err := checkSource(ctx, store, *source); err != nil {
+			return err
+		}
+		return listIndexRuns(ctx, store, *source, *format)
+	case "sources":
+		return runSourcesCommand(ctx, args, defaultFormat)
+	case "db":
+		return runDBCommand(ctx, args, defaultFormat)
 	default:
-		fmt.Println("expected 'index' subcommand")
-		os.Exit(1)
+		return fmt.Errorf("unknown command %q, expected 'index', 'ls', 'health', 'verify', 'backup', 'dupes', 'find', 'query', 'tag', 'recategorize', 'mismatches', 'history', 'runs', 'sources' or 'db'", cmd)
 	}
 }
 
-func checkHealthFiles(source string, path string) {
-	foundFiles := walkFiles(path, source)
-	var notFoundFiles []inventory.FoundFile
-	fmt.Println()
+// healthRecord is a file checked by health against its redundancy policy
+type healthRecord struct {
+	Path           string   `json:"path"`
+	Policy         string   `json:"policy"`
+	Copies         int      `json:"copies"`
+	RequiredCopies int      `json:"required_copies"`
+	RequiredKinds  []string `json:"required_kinds"`
+	// Sources are the sources with a copy of the file, including its own
+	Sources    []string `json:"sources"`
+	Healthy    bool     `json:"healthy"`
+	Violations []string `json:"violations"`
+}
+
+type healthSummary struct {
+	NumFiles      int                  `json:"num_files"`
+	NumHealthy    int                  `json:"num_healthy"`
+	Health        float64              `json:"health"`
+	NumNotIndexed int                  `json:"num_not_indexed"`
+	NumErrors     int                  `json:"num_errors"`
+	Policies      []policyHealthRecord `json:"policies"`
+}
+
+func checkHealthFiles(ctx context.Context, store inventory.Store, source string, path string, filter *walkFilter, policies []policy, format string) error {
+	srcs, err := store.GetSources(ctx)
+	if err != nil {
+		return err
+	}
+	kinds := map[string]string{}
+	for _, src := range srcs {
+		kinds[src.Name] = src.Kind
+	}
+	res := newResultWriter(format, "files", healthRecord{})
+	var violations []string
+	health := map[string]*policyHealth{}
+	if res.text() {
+		fmt.Println()
+	}
 	nFound := 0
 	nNotFound := 0
 	nNotIndexed := 0
-	for _, ff := range foundFiles {
-		previousFF := inventory.GetFoundFileWithSizeAndModified(source, ff.Path, ff.Size, ff.Modified)
+	errs := newErrorLog(nil, nil)
+	err = walkFiles(ctx, path, source, filter, func(ff inventory.FoundFile, err error) error {
+		if err != nil {
+			errs.add(ctx, source, ff.Path, "walk", err)
+			return nil
+		}
+		previousFF, err := store.GetFoundFileWithSizeAndModified(ctx, source, ff.Path, ff.Size, ff.Modified)
+		if err != nil {
+			return err
+		}
 		if previousFF == nil {
 			nNotIndexed++
-			continue
+			return nil
 		}
-		otherFFs := inventory.GetFoundFileOtherSourcesWithMd5hash(source, previousFF.Md5hash)
-		if len(otherFFs) == 0 {
-			notFoundFiles = append(notFoundFiles, ff)
+		otherFFs, err := store.GetFoundFileOtherSourcesWithSameContent(ctx, previousFF)
+		if err != nil {
+			return err
+		}
+		// The sources with a copy of the file, including its own, and their kind
+		copies := map[string]string{source: kinds[source]}
+		for _, off := range otherFFs {
+			copies[off.Source] = kinds[off.Source]
+		}
+		p := findPolicy(policies, previousFF)
+		ph, ok := health[p.Name]
+		if !ok {
+			ph = &policyHealth{policy: p}
+			health[p.Name] = ph
+		}
+		ph.files++
+		problems := p.check(copies)
+		if len(problems) > 0 {
+			violations = append(violations, fmt.Sprintf("%s    %s: %s", ff.Path, p.Name, strings.Join(problems, ", ")))
 			nNotFound++
-			continue
+		} else {
+			ph.healthy++
+			nFound++
 		}
-		nFound++
-		fmt.Println(ff.Path)
+		if !res.text() {
+			rec := healthRecord{Path: ff.Path, Policy: p.Name, Copies: len(copies), RequiredCopies: p.Copies, RequiredKinds: []string{},
+				Sources: []string{}, Healthy: len(problems) == 0, Violations: []string{}}
+			rec.RequiredKinds = append(rec.RequiredKinds, p.Kinds...)
+			rec.Violations = append(rec.Violations, problems...)
+			for name := range copies {
+				rec.Sources = append(rec.Sources, name)
+			}
+			sort.Strings(rec.Sources)
+			return res.write(rec)
+		}
+		if len(otherFFs) == 0 {
+			return nil
+		}
+		fmt.Printf("%s    %s: %d of %s\n", ff.Path, p.Name, len(copies), p)
 		for _, off := range otherFFs {
 			fmt.Printf("%-16s    %s    %s\n", off.Source, off.LastChecked.Format("2006-01-02 15:04"), off.Path)
 		}
 		fmt.Println()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	rows := sortPolicyHealth(policies, health)
+	if !res.text() {
+		errs.printSummary(res.log())
+		summary := healthSummary{NumFiles: nFound + nNotFound, NumHealthy: nFound, Health: percent(nFound, nFound+nNotFound),
+			NumNotIndexed: nNotIndexed, NumErrors: errs.count(), Policies: []policyHealthRecord{}}
+		for _, ph := range rows {
+			summary.Policies = append(summary.Policies, ph.record())
+		}
+		return res.close(summary)
 	}
+
 	fmt.Println()
-	if len(notFoundFiles) > 0 {
-		fmt.Println("Files without other sources:")
-		for _, ff := range notFoundFiles {
-			fmt.Println(ff.Path)
+	if len(violations) > 0 {
+		fmt.Println("Files not meeting their policy:")
+		for _, v := range violations {
+			fmt.Println(v)
 		}
 		fmt.Println()
 	}
@@ -113,236 +712,469 @@ func checkHealthFiles(source string, path string) {
 		fmt.Println(nNotIndexed, "files are not indexed")
 	}
 	if nFound+nNotFound > 0 {
-		fmt.Printf("Found %d out of %d files. Health is %.1f%%\n", nFound, nFound+nNotFound, float32(nFound)/(float32(nFound+nNotFound))*100)
+		printPolicyHealth(rows)
+		fmt.Println()
+		fmt.Printf("%d out of %d files meet their policy. Health is %.1f%%\n", nFound, nFound+nNotFound, percent(nFound, nFound+nNotFound))
 	}
+	errs.printSummary(os.Stdout)
+	return nil
+}
+
+// newFileRecord is a file listed by ls -new that may not have a copy in another source
+type newFileRecord struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Modified string `json:"modified"`
+	Indexed  bool   `json:"indexed"`
+	// SimilarSources are the sources of files with the same size and modified time, if it isn't indexed
+	SimilarSources []string `json:"similar_sources"`
+}
+
+type newFilesSummary struct {
+	NumFiles int `json:"num_files"`
+	// NumWithoutCopies counts the files without a copy or a similar file in another source
+	NumWithoutCopies int `json:"num_without_copies"`
+	NumErrors        int `json:"num_errors"`
 }
 
 // Searches for files with same filesize and modified timestamp
-func checkNewFiles(source string, path string) {
-	foundFiles := walkFiles(path, source)
-	var notFoundFiles []inventory.FoundFile
-	fmt.Println()
+func checkNewFiles(ctx context.Context, store inventory.Store, source string, path string, filter *walkFilter, format string) error {
+	res := newResultWriter(format, "files", newFileRecord{})
+	// The files without copies are listed at the end of the text output, the other formats only count them
 	nNotFound := 0
-	nNotIndexed := 0
-	for _, ff := range foundFiles {
-		previousFF := inventory.GetFoundFileWithSizeAndModified(source, ff.Path, ff.Size, ff.Modified)
+	var notFoundFiles []string
+	notFound := func(path string) {
+		nNotFound++
+		if res.text() {
+			notFoundFiles = append(notFoundFiles, path)
+		}
+	}
+	if res.text() {
+		fmt.Println()
+	}
+	errs := newErrorLog(nil, nil)
+	err := walkFiles(ctx, path, source, filter, func(ff inventory.FoundFile, err error) error {
+		if err != nil {
+			errs.add(ctx, source, ff.Path, "walk", err)
+			return nil
+		}
+		previousFF, err := store.GetFoundFileWithSizeAndModified(ctx, source, ff.Path, ff.Size, ff.Modified)
+		if err != nil {
+			return err
+		}
+		rec := newFileRecord{Path: ff.Path, Size: ff.Size, Modified: formatTime(ff.Modified), Indexed: previousFF != nil, SimilarSources: []string{}}
 		if previousFF != nil {
-			ff.Discovered = previousFF.Discovered
-			otherFFs := inventory.GetFoundFileOtherSourcesWithMd5hash(source, previousFF.Md5hash)
+			otherFFs, err := store.GetFoundFileOtherSourcesWithSameContent(ctx, previousFF)
+			if err != nil {
+				return err
+			}
 			if len(otherFFs) == 0 {
-				notFoundFiles = append(notFoundFiles, ff)
-				nNotFound++
+				notFound(ff.Path)
+				if !res.text() {
+					return res.write(rec)
+				}
 			}
-		} else {
-			ff.Discovered = time.Time{}
-			nNotIndexed++
-			similarFiles := inventory.GetSimilarFoundFileSourcesWithSizeAndModified(ff.Size, ff.Modified)
-			if len(similarFiles) == 0 {
-				notFoundFiles = append(notFoundFiles, ff)
-				nNotFound++
-			} else {
-				fmt.Println(ff.Path)
-				for _, f := range similarFiles {
-					fmt.Printf("%-16s    %s    %s\n", f.Source, f.LastChecked.Format("2006-01-02 15:04"), f.Path)
+			return nil
+		}
+		similarFiles, err := store.GetSimilarFoundFileSourcesWithSizeAndModified(ctx, ff.Size, ff.Modified)
+		if err != nil {
+			return err
+		}
+		if len(similarFiles) == 0 {
+			notFound(ff.Path)
+		}
+		if !res.text() {
+			for _, f := range similarFiles {
+				if !contains(rec.SimilarSources, f.Source) {
+					rec.SimilarSources = append(rec.SimilarSources, f.Source)
 				}
 			}
+			return res.write(rec)
+		}
+		if len(similarFiles) == 0 {
+			return nil
 		}
+		fmt.Println(ff.Path)
+		for _, f := range similarFiles {
+			fmt.Printf("%-16s    %s    %s\n", f.Source, f.LastChecked.Format("2006-01-02 15:04"), f.Path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !res.text() {
+		errs.printSummary(res.log())
+		return res.close(newFilesSummary{NumFiles: res.n, NumWithoutCopies: nNotFound, NumErrors: errs.count()})
 	}
-	if nNotFound > 0 {
+	if len(notFoundFiles) > 0 {
 		fmt.Printf("\n%s\n\n", strings.Repeat("-", 80))
-		fmt.Println(nNotFound, "files do not have any similar/redundant files in other sources:")
-		for _, f := range notFoundFiles {
-			fmt.Println(f.Path)
+		fmt.Println(len(notFoundFiles), "files do not have any similar/redundant files in other sources:")
+		for _, p := range notFoundFiles {
+			fmt.Println(p)
 		}
 	}
+	errs.printSummary(os.Stdout)
+	return nil
 }
 
-func listFiles(source string, path string) {
-	foundFiles := walkFiles(path, source)
-	fmt.Println()
-	var foundFiles2 []inventory.FoundFile
-	for _, ff := range foundFiles {
-		previousFF := inventory.GetFoundFileWithSizeAndModified(source, ff.Path, ff.Size, ff.Modified)
+// fileRecord is a file listed by ls
+type fileRecord struct {
+	Path     string `json:"path"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Size     int64  `json:"size"`
+	Modified string `json:"modified"`
+	// Discovered is empty when the file isn't indexed or has changed since
+	Discovered string `json:"discovered"`
+}
+
+type filesSummary struct {
+	NumFiles  int   `json:"num_files"`
+	SizeTotal int64 `json:"size_total"`
+	NumErrors int   `json:"num_errors"`
+}
+
+func listFiles(ctx context.Context, store inventory.Store, source string, path string, filter *walkFilter, format string) error {
+	res := newResultWriter(format, "files", fileRecord{})
+	if res.text() {
+		fmt.Println()
+	}
+	var summary foundFilesSummary
+	errs := newErrorLog(nil, nil)
+	err := walkFiles(ctx, path, source, filter, func(ff inventory.FoundFile, err error) error {
+		if err != nil {
+			errs.add(ctx, source, ff.Path, "walk", err)
+			return nil
+		}
+		previousFF, err := store.GetFoundFileWithSizeAndModified(ctx, source, ff.Path, ff.Size, ff.Modified)
+		if err != nil {
+			return err
+		}
 		if previousFF == nil {
 			ff.Discovered = time.Time{}
 		} else {
 			ff.Discovered = previousFF.Discovered
 		}
-		foundFiles2 = append(foundFiles2, ff)
+		if !res.text() {
+			summary.numFiles++
+			summary.sizeTotal += ff.Size
+			return res.write(fileRecord{Path: ff.Path, Name: ff.Name, Type: ff.Type, Size: ff.Size,
+				Modified: formatTime(ff.Modified), Discovered: formatTime(ff.Discovered)})
+		}
+		summary.add(ff)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !res.text() {
+		errs.printSummary(res.log())
+		return res.close(filesSummary{NumFiles: summary.numFiles, SizeTotal: summary.sizeTotal, NumErrors: errs.count()})
 	}
-	if foundFiles2 == nil {
+	if summary.numFiles == 0 {
 		fmt.Println("No new files found")
-		return
+	} else {
+		summary.finish()
 	}
-	displayFoundFilesSummary(foundFiles2)
+	errs.printSummary(os.Stdout)
+	return nil
 }
 
-func indexPath(source string, path string, category string, subcategory string, label string, tags string, reindexDiscovered bool) {
-	foundFiles := walkFiles(path, source)
-	fmt.Println()
-	if len(foundFiles) == 0 {
-		fmt.Println("No files found")
-		return
+// indexOptions are the flags of the index command
+type indexOptions struct {
+	// rules categorize the files, before the category, subcategory and label flags apply
+	rules []categoryRule
+	// root is the root of the source, rule paths are relative to it
+	root              string
+	category          string
+	subcategory       string
+	label             string
+	tags              []string
+	reindexDiscovered bool
+	workers           int
+	hashers           []Hasher
+	// batchSize is the number of files saved per transaction
+	batchSize int
+	filter    *walkFilter
+	// flags are recorded with the run so it can be resumed with them
+	flags string
+	// resume is the incomplete run to continue, if any
+	resume *inventory.IndexRun
+	format string
+}
+
+// indexSummary is the result of index, the counts of the run
+type indexSummary struct {
+	RunID       int64  `json:"run_id"`
+	Source      string `json:"source"`
+	Root        string `json:"root"`
+	NumFound    int    `json:"num_found"`
+	NumNew      int    `json:"num_new"`
+	NumPrevious int    `json:"num_previous"`
+	NumSkipped  int    `json:"num_skipped"`
+	NumMissing  int    `json:"num_missing"`
+	NumErrors   int    `json:"num_errors"`
+	SizeTotal   int64  `json:"size_total"`
+	// Changed are the files whose content changed without a change in size or modified time
+	Changed []string `json:"changed"`
+}
+
+// indexPath walks path and hashes the files as they are found, so hashing starts straight away.
+// The run is recorded along with a checkpoint, so if ctx is cancelled the files already hashed are
+// saved and the run can be resumed.
+func indexPath(ctx context.Context, store inventory.Store, source string, path string, opts indexOptions) error {
+	// Cancelling ctx stops the walk and the hashing, saves use their own context so they still complete
+	saveCtx := context.Background()
+	walkCtx, cancelWalk := context.WithCancel(ctx)
+	defer cancelWalk()
+	res := newResultWriter(opts.format, "", nil)
+
+	run := opts.resume
+	if run == nil {
+		run = &inventory.IndexRun{Source: source, Root: path, Flags: opts.flags, Status: inventory.RunRunning, Started: time.Now()}
+		if err := store.CreateIndexRun(saveCtx, run); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintf(res.log(), "Resuming index run %d of %s", run.ID, run.Root)
+		if run.Checkpoint != "" {
+			fmt.Fprintf(res.log(), " after %s", run.Checkpoint)
+		}
+		fmt.Fprintln(res.log())
+		run.Status = inventory.RunRunning
 	}
-	numSkipped, numProcessed, numTotal := 0, 0, len(foundFiles)
-	var sizeSkipped, sizeProcessed, sizeTotal float32
-	for _, ff := range foundFiles {
-		sizeTotal += float32(ff.Size)
+	// The counts of the run before it was resumed
+	base := *run
+	cp := newCheckpoint(run.Checkpoint)
+
+	var algorithms []string
+	for _, h := range opts.hashers {
+		algorithms = append(algorithms, h.Algorithm)
 	}
-	if !reindexDiscovered {
-		var foundFiles2 []inventory.FoundFile
-		for _, ff := range foundFiles {
-			previousFF := inventory.GetFoundFileWithSizeAndModified(source, ff.Path, ff.Size, ff.Modified)
+	fmt.Fprintf(res.log(), "\nCalculating %s sums and adding to database...\n", strings.Join(algorithms, ", "))
+
+	errs := newErrorLog(store, run)
+	progress := newIndexProgress(res.log())
+	// The EXIF dates are read by the hash workers, only when a rule needs them
+	taken := needsTakenTime(opts.rules)
+	jobs, walkErr := walkJobs(walkCtx, path, run.Checkpoint, source, opts.filter, progress, errs, func(ff inventory.FoundFile) (*hashJob, error) {
+		if !opts.reindexDiscovered {
+			previousFF, err := store.GetFoundFileWithSizeAndModified(walkCtx, source, ff.Path, ff.Size, ff.Modified)
+			if err != nil {
+				return nil, err
+			}
 			if previousFF != nil {
-				numSkipped++
-				sizeSkipped += float32(ff.Size)
-			} else {
-				foundFiles2 = append(foundFiles2, ff)
+				cp.walked(ff.Path, ff.Size, false)
+				return nil, nil
 			}
 		}
-		if foundFiles2 == nil {
-			fmt.Println("No new files found")
-			return
-		}
-		foundFiles = foundFiles2
+		return &hashJob{ff: ff, hs: opts.hashers, seq: cp.walked(ff.Path, ff.Size, true), taken: taken}, nil
+	})
+	prev, new := 0, 0
+	var warnings []string
+	var batch []*inventory.FoundFile
+	var batchSeqs []int
+	var batchOutcomes []string
+	// updateRun records the status, checkpoint and counts of the run so far. Only the files up to the
+	// checkpoint are counted, as the files after it are walked again when the run is resumed.
+	updateRun := func(status string) error {
+		pos := cp.position()
+		run.Status = status
+		run.Checkpoint = pos.path
+		run.NumFound = base.NumFound + pos.counts.numFound
+		run.NumSkipped = base.NumSkipped + pos.counts.numSkipped
+		run.NumNew = base.NumNew + pos.counts.numNew
+		run.NumPrevious = base.NumPrevious + pos.counts.numPrevious
+		run.NumErrors = base.NumErrors + errs.count()
+		run.SizeTotal = base.SizeTotal + pos.counts.sizeTotal
+		return store.UpdateIndexRun(saveCtx, run)
 	}
-	fmt.Println("\nCalculating md5 sums and adding to database...")
-	unit, unitName := bestUnit(int64(sizeTotal))
-	if numSkipped == 1 {
-		fmt.Printf("\nSkipping 1 file, size %.f %s\n", sizeSkipped, unitName)
-	} else if numSkipped >= 2 {
-		fmt.Printf("\nSkipping %d files, size %.f %s\n", numSkipped, sizeSkipped/unit, unitName)
+	saveBatch := func() error {
+		if err := store.SaveAll(saveCtx, batch); err != nil {
+			return err
+		}
+		for i, seq := range batchSeqs {
+			cp.saved(seq, batchOutcomes[i])
+		}
+		batch, batchSeqs, batchOutcomes = nil, nil, nil
+		return updateRun(inventory.RunRunning)
 	}
-	prev, new := 0, 0
-	start := time.Now()
-	for _, ff := range foundFiles {
-		// sizeRemaining := sizeTotal - sizeSkipped - sizeProcessed
-		timeElapsed := time.Since(start).Seconds()
-		speed := float32(0)
-		remaining := float32(0)
-		if timeElapsed > 0 && numProcessed > 0 && sizeProcessed > 1000 {
-			speed = sizeProcessed / float32(timeElapsed)
-			remaining = (sizeTotal - sizeSkipped - sizeProcessed) / speed
-		}
-		l := fmt.Sprintf("(%1d/%1d): %s", numProcessed+1, numTotal-numSkipped, ff.Name)
-		fmt.Printf("\n%-80s", l)
-		fmt.Printf("\n%-80s", "")
-		fmt.Printf("\nProgress  %.1f%%  %.f/%.f %-40s", (sizeSkipped+sizeProcessed)/sizeTotal*100, (sizeSkipped+sizeProcessed)/unit, sizeTotal/unit, unitName)
-		speedFmt := ""
-		if speed > 0 {
-			// TODO: Use a window to get a more accurate estimate
-			speedFmt = fmt.Sprintf("Speed: %.1f MB/s Remaining %.fs", speed*0.000001, remaining)
-		}
-		fmt.Printf("\nTime elapsed: %s %s", time.Since(start), speedFmt)
-		md5hash := getMd5hash(ff.Path)
-		previousFF := inventory.GetFoundFileWithMd5hash(source, ff.Path, md5hash)
+	err := hashFiles(ctx, jobs, opts.workers, progress, func(r hashResult) error {
+		ff := r.ff
+		if r.err != nil {
+			errs.add(saveCtx, source, ff.Path, "hash", r.err)
+			cp.saved(r.seq, savedError)
+			return nil
+		}
+		ff.MIME = r.mime
+		if ff.Type == "" {
+			ff.Type = mimeFileType(r.mime)
+		}
+		previousFF, err := store.GetFoundFileWithHashes(saveCtx, source, ff.Path, r.hashes)
+		if err != nil {
+			return err
+		}
+		outcome := savedNew
 		if previousFF != nil {
-			// File is "new" if md5hash is different
+			// File is "new" if none of its hashes match
 			previousFF.LastChecked = ff.LastChecked
 			previousFF.Type = ff.Type
+			previousFF.MIME = ff.MIME
 			previousFF.Size = ff.Size
 			previousFF.Modified = ff.Modified
 			ff = *previousFF
 			prev++
-			// TODO: Detect md5 hash changes and warn
+			outcome = savedPrevious
 		} else {
 			new++
-		}
-		ff.Md5hash = md5hash
-		if len(category) > 0 {
-			ff.Category = category
-			if len(subcategory) > 0 {
-				ff.Subcategory = subcategory
+			changedFF, err := store.GetFoundFileWithSizeAndModified(saveCtx, source, ff.Path, ff.Size, ff.Modified)
+			if err != nil {
+				return err
+			}
+			if changedFF != nil {
+				warnings = append(warnings, ff.Path)
 			}
+			ff.HashAlgorithm = opts.hashers[0].Algorithm
+			ff.Hash = r.hashes[ff.HashAlgorithm]
 		}
-		if len(label) > 0 {
-			ff.Label = label
+		ff.Hashes = r.hashes
+		ff.Tags = nil
+		if rule, err := findRule(opts.rules, &ff, opts.root, func() (time.Time, error) { return r.takenTime, r.takenErr }); err != nil {
+			errs.add(saveCtx, source, ff.Path, "categorize", err)
+		} else if rule != nil {
+			rule.apply(&ff)
 		}
-		if len(tags) > 0 {
-			ff.Tags = tags
+		if len(opts.category) > 0 {
+			ff.Category = opts.category
+			if len(opts.subcategory) > 0 {
+				ff.Subcategory = opts.subcategory
+			}
+		}
+		if len(opts.label) > 0 {
+			ff.Label = opts.label
 		}
+		ff.Tags = inventory.MergeTags(ff.Tags, opts.tags)
 		ff.LastChecked = time.Now()
-		ff.Save()
-		numProcessed++
-		sizeProcessed += float32(ff.Size)
-		fmt.Print("\u001b[1000D\u001b[3A")
-	}
-	fmt.Printf("\n%-80.80s", "")
-	l := fmt.Sprintf("Complete!")
-	fmt.Printf("\n%-80.80s\n", l)
-	l = fmt.Sprintf("Processed %d new and %d previous files", new, prev)
-	fmt.Printf("%-80.80s\n", l)
-	fmt.Printf("Processed size: %.f %s\n", sizeTotal/unit, unitName)
-}
-
-func walkFiles(path string, source string) []inventory.FoundFile {
-	var foundFiles []inventory.FoundFile
-	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			if IsHidden(info.Name()) {
-				fmt.Println("Skipping folder", info.Name())
-				return filepath.SkipDir
-			}
-			fmt.Printf("Scanning folder %s\n", info.Name())
-		} else if IsHidden(info.Name()) {
+		batch = append(batch, &ff)
+		batchSeqs = append(batchSeqs, r.seq)
+		batchOutcomes = append(batchOutcomes, outcome)
+		if len(batch) < opts.batchSize {
 			return nil
-		} else {
-			ff := inventory.FoundFile{Source: source, Path: path}
-			ff.Name = info.Name()
-			ff.Extension = GetNormalizedExtension(path)
-			ff.Type = GetFileType(path)
-			ff.Size = info.Size()
-			ff.Modified = info.ModTime()
-			ff.Discovered = time.Now()
-			foundFiles = append(foundFiles, ff)
 		}
-		return nil
+		return saveBatch()
 	})
+	interrupted := ctx.Err() != nil
+	if (err == nil || interrupted) && len(batch) > 0 {
+		if serr := saveBatch(); serr != nil {
+			err = serr
+			interrupted = false
+		}
+	}
+	// Stop the walk if hashing failed, the walk's own error is only reported if hashing succeeded
+	cancelWalk()
+	if werr := <-walkErr; err == nil {
+		err = werr
+	}
+	if err == nil {
+		err = errs.saveErr
+	}
+	if interrupted {
+		if err := updateRun(inventory.RunInterrupted); err != nil {
+			return err
+		}
+		fmt.Fprintf(res.log(), "\nInterrupted after processing %d new and %d previous files\n", new, prev)
+		errs.printSummary(res.log())
+		return fmt.Errorf("index interrupted, run index -resume -source %s to continue", source)
+	}
 	if err != nil {
-		panic(err)
+		// The run is left resumable, the error is more useful than any error recording it
+		updateRun(inventory.RunFailed)
+		return err
 	}
-	sort.SliceStable(foundFiles, func(i, j int) bool {
-		p1, p2 := foundFiles[i].Path, foundFiles[j].Path
-		d1, d2 := filepath.Dir(p1), filepath.Dir(p2)
-		return d1 < d2
-	})
-	return foundFiles
-}
-
-func getMd5hash(path string) string {
-	f, err := os.Open(path)
+	nMissing, err := markMissingFiles(saveCtx, store, source, path, opts.filter)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	run.NumMissing = nMissing
+	run.Finished = time.Now()
+	if err := updateRun(inventory.RunComplete); err != nil {
+		return err
+	}
+	if !res.text() {
+		errs.printSummary(res.log())
+		if warnings == nil {
+			warnings = []string{}
+		}
+		return res.close(indexSummary{RunID: run.ID, Source: source, Root: path, NumFound: run.NumFound, NumNew: run.NumNew,
+			NumPrevious: run.NumPrevious, NumSkipped: run.NumSkipped, NumMissing: run.NumMissing, NumErrors: run.NumErrors,
+			SizeTotal: run.SizeTotal, Changed: warnings})
+	}
+
+	fmt.Println()
+	if progress.numFound == 0 {
+		fmt.Println("No files found")
+	} else if progress.numSkipped == progress.numFound {
+		fmt.Println("No new files found")
+	} else {
+		l := fmt.Sprintf("Complete!")
+		fmt.Printf("%-80.80s\n", l)
+		l = fmt.Sprintf("Processed %d new and %d previous files", new, prev)
+		fmt.Printf("%-80.80s\n", l)
+	}
+	unit, unitName := bestUnit(int64(progress.sizeTotal))
+	if progress.numSkipped > 0 {
+		fmt.Printf("Skipped %d unchanged files, size %.f %s\n", progress.numSkipped, progress.sizeSkipped/unit, unitName)
+	}
+	if progress.numFound > 0 {
+		fmt.Printf("Processed size: %.f %s\n", progress.sizeTotal/unit, unitName)
+	}
+	if nMissing > 0 {
+		fmt.Printf("%d previously indexed files are missing, see ls -missing\n", nMissing)
+	}
+	if len(warnings) > 0 {
+		fmt.Println("\nWARNING: Content changed without a change in size or modified time, run verify to check for corruption:")
+		for _, p := range warnings {
+			fmt.Println(p)
+		}
 	}
-	defer f.Close()
+	errs.printSummary(os.Stdout)
+	return nil
+}
+
+// foundFilesSummary prints files grouped by folder as they are added, relying on the walk
+// visiting each folder's files together
+type foundFilesSummary struct {
+	dir       string
+	dirFiles  []inventory.FoundFile
+	numFiles  int
+	sizeTotal int64
+}
 
-	h := md5.New()
-	if _, err := io.Copy(h, f); err != nil {
-		log.Fatal(err)
+func (s *foundFilesSummary) add(ff inventory.FoundFile) {
+	dir := filepath.Dir(ff.Path)
+	if dir != s.dir && len(s.dirFiles) > 0 {
+		s.flush()
 	}
-	return fmt.Sprintf("%x", h.Sum(nil))
+	s.dir = dir
+	s.dirFiles = append(s.dirFiles, ff)
+	s.numFiles++
+	s.sizeTotal += ff.Size
 }
 
-func displayFoundFilesSummary(foundFiles []inventory.FoundFile) {
-	var dir string
-	lastDir := filepath.Dir(foundFiles[0].Path)
-	var dirFiles []inventory.FoundFile
-	var sizeTotal int64
-	for _, ff := range foundFiles {
-		sizeTotal += ff.Size
-		dir = filepath.Dir(ff.Path)
-		if dir != lastDir {
-			fmt.Printf("\n%s\n", lastDir)
-			displayFoundFileList(dirFiles)
-			lastDir = dir
-			dirFiles = nil
-		}
-		dirFiles = append(dirFiles, ff)
-	}
-	fmt.Printf("\n%s\n", dir)
-	displayFoundFileList(dirFiles)
-	fmt.Println("\nFound", len(foundFiles), "files")
-	fmt.Printf("Total Size: %d\n", sizeTotal)
+func (s *foundFilesSummary) flush() {
+	fmt.Printf("\n%s\n", s.dir)
+	displayFoundFileList(s.dirFiles)
+	s.dirFiles = nil
+}
+
+// finish prints the last folder and the totals
+func (s *foundFilesSummary) finish() {
+	if len(s.dirFiles) > 0 {
+		s.flush()
+	}
+	fmt.Println("\nFound", s.numFiles, "files")
+	fmt.Printf("Total Size: %d\n", s.sizeTotal)
 }
 
 func displayFoundFileList(foundFiles []inventory.FoundFile) {
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+// captureOutput returns what f prints to stdout
+func captureOutput(t *testing.T, f func() error) string {
+	t.Helper()
+	return capture(t, &os.Stdout, f)
+}
+
+// captureStderr returns what f prints to stderr
+func captureStderr(t *testing.T, f func() error) string {
+	t.Helper()
+	return capture(t, &os.Stderr, f)
+}
+
+// capture returns what f writes to file, stdout or stderr
+func capture(t *testing.T, file **os.File, f func() error) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := *file
+	*file = w
+	out := make(chan string)
+	go func() {
+		var buf bytes.Buffer
+		io.Copy(&buf, r)
+		out <- buf.String()
+	}()
+	err = f()
+	w.Close()
+	*file = saved
+	s := <-out
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func writeFile(t *testing.T, path string, contents string, modified time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testFilter returns a walkFilter for root that ignores the global ignore file and those above root
+func testFilter(root string) *walkFilter {
+	return &walkFilter{root: root, top: root, dirRules: map[string][]*ignoreRule{}}
+}
+
+func index(t *testing.T, store inventory.Store, source string, path string) {
+	t.Helper()
+	hs, _ := ParseHashers("md5")
+	captureOutput(t, func() error {
+		return indexPath(context.Background(), store, source, path, indexOptions{workers: 2, hashers: hs, batchSize: 2, filter: testFilter(path)})
+	})
+}
+
+func TestIndexPath(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeFile(t, filepath.Join(dir, "a.txt"), "hello", modified)
+	writeFile(t, filepath.Join(dir, "sub", "b.txt"), "world", modified)
+	writeFile(t, filepath.Join(dir, ".hidden"), "secret", modified)
+	store := inventory.NewMemoryStore()
+
+	index(t, store, "laptop", dir)
+	ffs, err := store.GetFoundFilesUnderPath(ctx, "laptop", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ffs) != 2 {
+		t.Fatalf("indexed %d files, want 2", len(ffs))
+	}
+	if ffs[0].Name != "a.txt" || ffs[0].HashAlgorithm != "md5" || ffs[0].Hash != "5d41402abc4b2a76b9719d911017c592" {
+		t.Errorf("indexed %+v, want a.txt with md5 5d41402abc4b2a76b9719d911017c592", ffs[0])
+	}
+
+	writeFile(t, filepath.Join(dir, "a.txt"), "hello again", modified.Add(time.Hour))
+	index(t, store, "laptop", dir)
+	fvs, err := store.GetFileVersions(ctx, "laptop", filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fvs) != 2 {
+		t.Errorf("a.txt has %d versions, want 2", len(fvs))
+	}
+	ff, err := store.GetFoundFile(ctx, "laptop", filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ff == nil || ff.Size != int64(len("hello again")) {
+		t.Errorf("current version of a.txt is %+v, want the edited file", ff)
+	}
+}
+
+func TestVerifyPath(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeFile(t, filepath.Join(dir, "a.txt"), "hello", modified)
+	writeFile(t, filepath.Join(dir, "b.txt"), "world", modified)
+	store := inventory.NewMemoryStore()
+	index(t, store, "laptop", dir)
+	writeFile(t, filepath.Join(dir, "c.txt"), "not indexed", modified)
+	writeFile(t, filepath.Join(dir, "d.txt"), "old hash", modified)
+	old := &inventory.FoundFile{Source: "laptop", Path: filepath.Join(dir, "d.txt"), Name: "d.txt", HashAlgorithm: "crc32", Hash: "1234",
+		Size: int64(len("old hash")), Modified: modified, Discovered: modified, LastChecked: modified}
+	if err := store.Save(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// Flip the contents of b.txt without changing its size or modified time
+	b := filepath.Join(dir, "b.txt")
+	if err := ioutil.WriteFile(b, []byte("w0rld"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(b, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	var verifyErr error
+	out := captureOutput(t, func() error {
+		verifyErr = verifyPath(ctx, store, "laptop", dir, 2, testFilter(dir), formatText)
+		return nil
+	})
+	if verifyErr != errCorruptFiles {
+		t.Errorf("verifyPath() error == %v, want %v", verifyErr, errCorruptFiles)
+	}
+	for _, want := range []string{
+		"Skipped " + filepath.Join(dir, "d.txt") + `, unknown hash algorithm "crc32"`,
+		"Verified 2 files",
+		"1 files are not indexed or have changed since they were indexed",
+		"1 files have different contents than when they were indexed:\n" + b + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("verifyPath() output:\n%s\nwant %q", out, want)
+		}
+	}
+	for name, want := range map[string]string{"a.txt": inventory.VerifyOK, "b.txt": inventory.VerifyCorrupt, "d.txt": ""} {
+		ff, err := store.GetFoundFile(ctx, "laptop", filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ff == nil || ff.VerifyStatus != want {
+			t.Errorf("%s was saved as %+v, want verify status %q", name, ff, want)
+		}
+	}
+
+	// Indexing the changed file again warns that its content changed
+	hs, _ := ParseHashers("md5")
+	out = captureOutput(t, func() error {
+		return indexPath(ctx, store, "laptop", dir, indexOptions{workers: 2, hashers: hs, batchSize: 2, filter: testFilter(dir), reindexDiscovered: true})
+	})
+	warning := "Content changed without a change in size or modified time, run verify to check for corruption:\n"
+	if i := strings.Index(out, warning); i < 0 || !strings.Contains(out[i:], "\n"+b+"\n") {
+		t.Errorf("index output:\n%s\nwant a warning that %s changed", out, b)
+	}
+}
+
+func TestCheckHealthFiles(t *testing.T) {
+	laptop, backup := t.TempDir(), t.TempDir()
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeFile(t, filepath.Join(laptop, "a.txt"), "hello", modified)
+	writeFile(t, filepath.Join(laptop, "b.txt"), "world", modified)
+	writeFile(t, filepath.Join(backup, "copy of a.txt"), "hello", modified)
+	store := inventory.NewMemoryStore()
+	index(t, store, "laptop", laptop)
+	index(t, store, "backup", backup)
+
+	out := captureOutput(t, func() error {
+		return checkHealthFiles(context.Background(), store, "laptop", laptop, testFilter(laptop), nil, formatText)
+	})
+	if !strings.Contains(out, "1 out of 2 files meet their policy. Health is 50.0%") {
+		t.Errorf("checkHealthFiles output:\n%s\nwant 50%% health", out)
+	}
+	if !strings.Contains(out, "Files not meeting their policy:\n"+filepath.Join(laptop, "b.txt")+"    default: 1 of 2 copies") {
+		t.Errorf("checkHealthFiles output:\n%s\nwant b.txt without other sources", out)
+	}
+}
+
+func TestCheckNewFiles(t *testing.T) {
+	laptop, camera := t.TempDir(), t.TempDir()
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeFile(t, filepath.Join(laptop, "a.jpg"), "hello", modified)
+	store := inventory.NewMemoryStore()
+	index(t, store, "laptop", laptop)
+
+	writeFile(t, filepath.Join(camera, "IMG_1.jpg"), "hello", modified)
+	writeFile(t, filepath.Join(camera, "IMG_2.jpg"), "new photo", modified)
+	out := captureOutput(t, func() error {
+		return checkNewFiles(context.Background(), store, "camera", camera, testFilter(camera), formatText)
+	})
+	if !strings.Contains(out, "1 files do not have any similar/redundant files in other sources:\n"+filepath.Join(camera, "IMG_2.jpg")) {
+		t.Errorf("checkNewFiles output:\n%s\nwant only IMG_2.jpg to be new", out)
+	}
+}
+
+func TestRunDupes(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	db := filepath.Join(dir, "index.db")
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	small, medium, large := strings.Repeat("s", 500), strings.Repeat("m", 2000), strings.Repeat("l", 5000)
+	for name, contents := range map[string]string{
+		"laptop/a.txt": small, "laptop/b.txt": small,
+		"laptop/c.jpg": medium, "nas/c.jpg": medium,
+		"laptop/d.mov": large, "laptop/e.mov": large, "nas/d.mov": large,
+	} {
+		writeFile(t, filepath.Join(dir, filepath.FromSlash(name)), contents, modified)
+	}
+	store, err := openStore(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"laptop", "nas"} {
+		if err := store.AddSource(ctx, &inventory.Source{Name: name, Created: modified}); err != nil {
+			t.Fatal(err)
+		}
+		index(t, store, name, filepath.Join(dir, name))
+	}
+	store.Close()
+
+	// -min-size takes a size with a unit, leaving out the small files, and the group reclaiming the
+	// most space comes first
+	out := captureOutput(t, func() error {
+		return run(ctx, "dupes", []string{"-db", db, "-min-size", "1KB"}, formatJSON)
+	})
+	var got struct {
+		Groups  []dupeGroupJSON
+		Summary dupesSummary
+	}
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("dupes -format json output:\n%s\n%v", out, err)
+	}
+	if len(got.Groups) != 2 || got.Groups[0].Size != 5000 || got.Groups[0].Wasted != 10000 || got.Groups[1].Size != 2000 || got.Groups[1].Wasted != 2000 {
+		t.Errorf("dupes -min-size 1KB groups == %+v, want the 5000 then the 2000 byte files", got.Groups)
+	}
+	if want := (dupesSummary{NumGroups: 2, NumFiles: 5, Reclaimable: 12000}); got.Summary != want {
+		t.Errorf("dupes -min-size 1KB summary == %+v, want %+v", got.Summary, want)
+	}
+
+	out = captureOutput(t, func() error {
+		return run(ctx, "dupes", []string{"-db", db}, formatText)
+	})
+	if !strings.HasPrefix(out, "3 copies of md5:") || !strings.Contains(out, "Found 7 files in 3 duplicate groups\nReclaimable space: 12500 bytes\n") {
+		t.Errorf("dupes output:\n%s\nwant 3 groups reclaiming 12500 bytes, the largest first", out)
+	}
+
+	if err := run(ctx, "dupes", []string{"-db", db, "-min-size", "big"}, formatText); err == nil {
+		t.Error("dupes -min-size big error == nil, want an error")
+	}
+}
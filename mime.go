@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+// sniffLen is the number of bytes at the start of files their media type is detected from
+const sniffLen = 512
+
+// headWriter keeps the first sniffLen bytes written to it
+type headWriter struct {
+	b []byte
+}
+
+func (w *headWriter) Write(p []byte) (int, error) {
+	if n := sniffLen - len(w.b); n > 0 {
+		if len(p) < n {
+			n = len(p)
+		}
+		w.b = append(w.b, p[:n]...)
+	}
+	return len(p), nil
+}
+
+// mimeSignatures are the formats http.DetectContentType doesn't detect, by the magic bytes at offset
+var mimeSignatures = []struct {
+	offset int
+	magic  string
+	mime   string
+}{
+	{0, "II*\x00", "image/tiff"},
+	{0, "MM\x00*", "image/tiff"},
+	{0, "7z\xbc\xaf\x27\x1c", "application/x-7z-compressed"},
+	{0, "\xfd7zXZ\x00", "application/x-xz"},
+	{0, "BZh", "application/x-bzip2"},
+	{0, "fLaC", "audio/flac"},
+	{0, "SQLite format 3\x00", "application/vnd.sqlite3"},
+	{0, "\x7fELF", "application/x-executable"},
+	{257, "ustar", "application/x-tar"},
+	// EPUBs are zip files starting with an uncompressed mimetype file
+	{30, "mimetypeapplication/epub+zip", "application/epub+zip"},
+}
+
+// ftypBrands are the media types of ISO base media files by their major brand, the ones not listed
+// are detected by http.DetectContentType
+var ftypBrands = map[string]string{
+	"qt  ": "video/quicktime",
+	"M4A ": "audio/mp4",
+	"M4B ": "audio/mp4",
+	"3gp4": "video/3gpp",
+	"3gp5": "video/3gpp",
+	"heic": "image/heic",
+	"heix": "image/heic",
+	"mif1": "image/heic",
+	"avif": "image/avif",
+	"crx ": "image/x-canon-cr3",
+}
+
+// sniffMIME returns the media type of the content starting with head, without parameters such as
+// the charset, or an empty string if it isn't known
+func sniffMIME(head []byte) string {
+	if len(head) == 0 {
+		return ""
+	}
+	for _, sig := range mimeSignatures {
+		if end := sig.offset + len(sig.magic); end <= len(head) && string(head[sig.offset:end]) == sig.magic {
+			return sig.mime
+		}
+	}
+	if len(head) >= 12 && string(head[4:8]) == "ftyp" {
+		if mime, ok := ftypBrands[string(head[8:12])]; ok {
+			return mime
+		}
+	}
+	mime := strings.TrimSpace(strings.SplitN(http.DetectContentType(head), ";", 2)[0])
+	if mime == "application/octet-stream" {
+		return ""
+	}
+	return mime
+}
+
+// mimeTypes are the file types of media types that aren't image, audio, video or font types
+var mimeTypes = map[string]string{
+	"application/pdf":               "document",
+	"application/postscript":        "document",
+	"application/epub+zip":          "document",
+	"application/zip":               "archive",
+	"application/x-gzip":            "archive",
+	"application/x-rar-compressed":  "archive",
+	"application/x-7z-compressed":   "archive",
+	"application/x-xz":              "archive",
+	"application/x-bzip2":           "archive",
+	"application/x-tar":             "archive",
+	"application/ogg":               "audio",
+	"application/vnd.ms-fontobject": "font",
+	"application/vnd.sqlite3":       "data",
+	"text/plain":                    "document",
+	"text/html":                     "code",
+	"text/xml":                      "code",
+}
+
+// mimeFileType returns the file type of the media type mime, or an empty string if it has none
+func mimeFileType(mime string) string {
+	if t, ok := mimeTypes[mime]; ok {
+		return t
+	}
+	switch t := strings.SplitN(mime, "/", 2)[0]; t {
+	case "image", "audio", "video", "font":
+		return t
+	}
+	return ""
+}
+
+// compatibleTypes are the other file types whose formats have content of a media type, i.e. Office
+// documents are zip files
+var compatibleTypes = map[string][]string{
+	"text/plain":             {"code", "data", "book"},
+	"text/html":              {"document", "book"},
+	"text/xml":               {"data", "document", "book", "image"},
+	"application/zip":        {"document", "book", "data"},
+	"application/pdf":        {"book", "image"},
+	"application/postscript": {"image"},
+	"application/ogg":        {"video"},
+	"audio/mp4":              {"video"},
+	"video/mp4":              {"audio"},
+	"video/webm":             {"audio"},
+}
+
+// textExtensions are the extensions of images stored as text
+var textExtensions = map[string]bool{"svg": true}
+
+// extensionMIMEs are the media types of extensions with a single format, content of another
+// format of the same file type contradicts them, i.e. a PNG named .jpg
+var extensionMIMEs = map[string]string{
+	"jpg":  "image/jpeg",
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"webp": "image/webp",
+	"bmp":  "image/bmp",
+	"tif":  "image/tiff",
+	"tiff": "image/tiff",
+	"heic": "image/heic",
+	"pdf":  "application/pdf",
+	"7z":   "application/x-7z-compressed",
+	"rar":  "application/x-rar-compressed",
+	"gz":   "application/x-gzip",
+	"bz2":  "application/x-bzip2",
+	"xz":   "application/x-xz",
+	"flac": "audio/flac",
+	"wav":  "audio/wave",
+}
+
+// contentMismatch reports whether the extension of ff contradicts its content, giving it another
+// file type or another format. Files whose extension or content has no known type never mismatch.
+func contentMismatch(ff *inventory.FoundFile) bool {
+	extType, contentType := fileExtToType[ff.Extension], mimeFileType(ff.MIME)
+	if extType == "" || contentType == "" {
+		return false
+	}
+	if extType == contentType {
+		want, ok := extensionMIMEs[ff.Extension]
+		return ok && ff.MIME != want
+	}
+	if contains(compatibleTypes[ff.MIME], extType) {
+		return false
+	}
+	return !(textExtensions[ff.Extension] && strings.HasPrefix(ff.MIME, "text/"))
+}
+
+// mismatchRecord is a file whose extension contradicts its content
+type mismatchRecord struct {
+	Source        string `json:"source"`
+	Path          string `json:"path"`
+	Extension     string `json:"extension"`
+	ExtensionType string `json:"extension_type"`
+	MIME          string `json:"mime"`
+	ContentType   string `json:"content_type"`
+}
+
+type mismatchSummary struct {
+	NumFiles      int `json:"num_files"`
+	NumMismatched int `json:"num_mismatched"`
+	// NumUnknown counts the files without a known media type, including the ones indexed before it
+	// was detected
+	NumUnknown int `json:"num_unknown"`
+}
+
+// listMismatches prints the current indexed files of source, or of every source, matching where if
+// it is set, whose extension contradicts their content
+func listMismatches(ctx context.Context, store inventory.Store, source string, where *inventory.Query, format string) error {
+	ffs, err := store.FindFoundFiles(ctx, inventory.FileQuery{Source: source, Where: where})
+	if err != nil {
+		return err
+	}
+	res := newResultWriter(format, "files", mismatchRecord{})
+	nMismatched, nUnknown := 0, 0
+	for i := range ffs {
+		ff := &ffs[i]
+		if ff.MIME == "" {
+			nUnknown++
+		}
+		if !contentMismatch(ff) {
+			continue
+		}
+		nMismatched++
+		rec := mismatchRecord{Source: ff.Source, Path: ff.Path, Extension: ff.Extension, ExtensionType: fileExtToType[ff.Extension],
+			MIME: ff.MIME, ContentType: mimeFileType(ff.MIME)}
+		if !res.text() {
+			if err := res.write(rec); err != nil {
+				return err
+			}
+			continue
+		}
+		if nMismatched == 1 {
+			fmt.Printf("%-16s    %-8s    %-8s    %-24s    %s\n", "Source", "Ext", "Content", "MIME", "Path")
+		}
+		fmt.Printf("%-16s    %-8s    %-8s    %-24s    %s\n", rec.Source, rec.Extension, rec.ContentType, rec.MIME, rec.Path)
+	}
+	if !res.text() {
+		return res.close(mismatchSummary{NumFiles: len(ffs), NumMismatched: nMismatched, NumUnknown: nUnknown})
+	}
+	if nMismatched > 0 {
+		fmt.Println()
+	}
+	fmt.Printf("%d of %d files have an extension that doesn't match their content\n", nMismatched, len(ffs))
+	if nUnknown > 0 {
+		fmt.Printf("%d files have no known content type, files indexed before it was detected need index -reindex\n", nUnknown)
+	}
+	return nil
+}
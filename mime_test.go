@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+const testPNG = "\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"
+
+func TestSniffMIME(t *testing.T) {
+	cases := []struct {
+		head string
+		want string
+	}{
+		{"", ""},
+		{"plain text\n", "text/plain"},
+		{testPNG, "image/png"},
+		{testJPEG("2019:07:14 10:30:00"), "image/jpeg"},
+		{"II*\x00\x08\x00\x00\x00", "image/tiff"},
+		{"\x00\x00\x00\x20ftypM4A \x00\x00\x00\x00", "audio/mp4"},
+		{"\x00\x00\x00\x18ftypheic\x00\x00\x00\x00", "image/heic"},
+		{"PK\x03\x04\x14\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x08\x00\x00\x00mimetypeapplication/epub+zip", "application/epub+zip"},
+		{strings.Repeat("\x00", 257) + "ustar\x0000", "application/x-tar"},
+		{"\x00\x01\x02\x03\x04", ""},
+	}
+	for _, c := range cases {
+		if got := sniffMIME([]byte(c.head)); got != c.want {
+			t.Errorf("sniffMIME(%q) == %q, want %q", c.head, got, c.want)
+		}
+	}
+}
+
+func TestListMismatches(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeFile(t, filepath.Join(dir, "photo.jpg"), testJPEG("2019:07:14 10:30:00"), modified)
+	writeFile(t, filepath.Join(dir, "screenshot.jpg"), testPNG, modified)
+	writeFile(t, filepath.Join(dir, "notes"), "no extension\n", modified)
+	writeFile(t, filepath.Join(dir, "report.docx"), "PK\x03\x04", modified)
+	writeFile(t, filepath.Join(dir, "logo.svg"), "<svg></svg>", modified)
+	writeFile(t, filepath.Join(dir, "song.mp3"), "not a song\n", modified)
+	store := inventory.NewMemoryStore()
+	if err := store.AddSource(ctx, &inventory.Source{Name: "laptop", Root: dir, Created: modified}); err != nil {
+		t.Fatal(err)
+	}
+	hs, _ := ParseHashers("md5")
+	opts := indexOptions{workers: 2, hashers: hs, batchSize: 2, filter: testFilter(dir)}
+	captureOutput(t, func() error {
+		return indexPath(ctx, store, "laptop", dir, opts)
+	})
+
+	ffs, err := store.FindFoundFiles(ctx, inventory.FileQuery{Source: "laptop", Name: "notes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ffs) != 1 || ffs[0].MIME != "text/plain" || ffs[0].Type != "document" {
+		t.Fatalf("notes was indexed as %+v, want a text/plain document", ffs)
+	}
+
+	out := captureOutput(t, func() error {
+		return listMismatches(ctx, store, "laptop", nil, formatCSV)
+	})
+	want := "source,path,extension,extension_type,mime,content_type\n" +
+		"laptop," + filepath.Join(dir, "screenshot.jpg") + ",jpg,image,image/png,image\n" +
+		"laptop," + filepath.Join(dir, "song.mp3") + ",mp3,audio,text/plain,document\n"
+	if out != want {
+		t.Errorf("mismatches output:\n%s\nwant:\n%s", out, want)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+// missingPageSize is the number of indexed files markMissingFiles loads at a time
+const missingPageSize = 10000
+
+// markMissingFiles marks indexed files under path that no longer exist as missing. It checks each
+// indexed file rather than remembering what the walk found, loading them a page at a time, so
+// neither the walk nor the check has to hold every path.
+// Files skipped by the filter are left alone, so adding an ignore rule doesn't mark them missing.
+// Returns the number of files newly marked as missing.
+func markMissingFiles(ctx context.Context, store inventory.Store, source string, path string, filter *walkFilter) (int, error) {
+	now := time.Now()
+	nMissing := 0
+	after := ""
+	for {
+		ffs, err := store.GetFoundFilesUnderPathAfter(ctx, source, path, after, missingPageSize)
+		if err != nil {
+			return nMissing, err
+		}
+		if len(ffs) == 0 {
+			return nMissing, nil
+		}
+		after = ffs[len(ffs)-1].Path
+		for _, ff := range ffs {
+			if filter.ignored(ff.Path) {
+				continue
+			}
+			if _, err := os.Lstat(ff.Path); !os.IsNotExist(err) {
+				continue
+			}
+			if err := store.SaveMissing(ctx, &ff, now); err != nil {
+				return nMissing, err
+			}
+			nMissing++
+		}
+	}
+}
+
+// missingFileRecord is a file listed by ls -missing
+type missingFileRecord struct {
+	Source       string `json:"source"`
+	Path         string `json:"path"`
+	Name         string `json:"name"`
+	Size         int64  `json:"size"`
+	MissingSince string `json:"missing_since"`
+	LastChecked  string `json:"last_checked"`
+}
+
+type missingFilesSummary struct {
+	NumFiles  int   `json:"num_files"`
+	SizeTotal int64 `json:"size_total"`
+}
+
+func listMissingFiles(ctx context.Context, store inventory.Store, source string, path string, format string) error {
+	ffs, err := store.GetMissingFoundFilesUnderPath(ctx, source, path)
+	if err != nil {
+		return err
+	}
+	res := newResultWriter(format, "files", missingFileRecord{})
+	if !res.text() {
+		var sizeTotal int64
+		for _, ff := range ffs {
+			sizeTotal += ff.Size
+			err := res.write(missingFileRecord{Source: ff.Source, Path: ff.Path, Name: ff.Name, Size: ff.Size,
+				MissingSince: formatTime(ff.MissingSince), LastChecked: formatTime(ff.LastChecked)})
+			if err != nil {
+				return err
+			}
+		}
+		return res.close(missingFilesSummary{NumFiles: len(ffs), SizeTotal: sizeTotal})
+	}
+	if len(ffs) == 0 {
+		fmt.Println("No missing files found")
+		return nil
+	}
+	lastDir := ""
+	var sizeTotal int64
+	for _, ff := range ffs {
+		sizeTotal += ff.Size
+		if dir := filepath.Dir(ff.Path); dir != lastDir {
+			fmt.Printf("\n%s\n", dir)
+			fmt.Print("Missing since       Last checked        Source              Size (KB)    Name\n")
+			lastDir = dir
+		}
+		s := float32(ff.Size) / 1000
+		fmt.Printf("%s    %s    %-16s    %9.f    %s\n", ff.MissingSince.Format("2006-01-02 15:04"), ff.LastChecked.Format("2006-01-02 15:04"), ff.Source, s, ff.Name)
+	}
+	fmt.Println("\nMissing", len(ffs), "files")
+	fmt.Printf("Total Size: %d\n", sizeTotal)
+	return nil
+}
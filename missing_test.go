@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+func TestIndexPathMarksMissingFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeFile(t, filepath.Join(dir, "a.txt"), "hello", modified)
+	writeFile(t, filepath.Join(dir, "sub", "b.txt"), "world", modified)
+	store := inventory.NewMemoryStore()
+	index(t, store, "laptop", dir)
+
+	if err := os.RemoveAll(filepath.Join(dir, "sub")); err != nil {
+		t.Fatal(err)
+	}
+	index(t, store, "laptop", dir)
+	missing, err := store.GetMissingFoundFilesUnderPath(ctx, "laptop", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 1 || missing[0].Name != "b.txt" {
+		t.Errorf("missing files are %v, want b.txt", missing)
+	}
+}
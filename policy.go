@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+// policyFileName is the name of the file in the home folder listing the redundancy policies
+const policyFileName = ".inventorypolicy.json"
+
+// defaultPolicy applies to files no policy matches, they need a copy in one other source
+var defaultPolicy = policy{Name: "default", Copies: 2}
+
+// policy is the redundancy files need, i.e.
+//
+//	{"name": "photos", "paths": ["Pictures/"], "types": ["image"], "copies": 3, "kinds": ["cloud"]}
+//
+// A file matches a policy when it matches one of the values of each of paths, types and categories
+// that are set, so a policy without any applies to every file.
+type policy struct {
+	Name string `json:"name"`
+	// Paths are gitignore style patterns, relative to the root of the source
+	Paths      []string `json:"paths,omitempty"`
+	Types      []string `json:"types,omitempty"`
+	Categories []string `json:"categories,omitempty"`
+	// Copies is the number of sources the file needs to be in, counting its own
+	Copies int `json:"copies"`
+	// Kinds are the kinds of source that need to have a copy, i.e. cloud for an offsite copy
+	Kinds []string `json:"kinds,omitempty"`
+
+	rules []*ignoreRule
+}
+
+// policyFile is the format of the policy file, the first policy matching a file applies to it
+type policyFile struct {
+	Policies []policy `json:"policies"`
+}
+
+// readPolicyFile returns the policies in the file at path, with their path patterns relative to
+// root. A missing file has no policies, unless it was explicitly given.
+func readPolicyFile(path string, root string, explicit bool) ([]policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var pf policyFile
+	if err := json.Unmarshal(data, &pf); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	names := map[string]bool{}
+	for i := range pf.Policies {
+		p := &pf.Policies[i]
+		if p.Name == "" {
+			return nil, fmt.Errorf("%s: policy %d has no name", path, i+1)
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("%s: policy %s is listed twice", path, p.Name)
+		}
+		names[p.Name] = true
+		if p.Copies < 1 {
+			return nil, fmt.Errorf("%s: policy %s needs a positive number of copies", path, p.Name)
+		}
+		for _, kind := range p.Kinds {
+			if !contains(inventory.SourceKinds, kind) {
+				return nil, fmt.Errorf("%s: policy %s has unknown source kind %q, expected one of %s", path, p.Name, kind, strings.Join(inventory.SourceKinds, ", "))
+			}
+		}
+		for _, pattern := range p.Paths {
+			r, err := parseIgnoreRule(root, pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%s: policy %s: %w", path, p.Name, err)
+			}
+			if r != nil {
+				p.rules = append(p.rules, r)
+			}
+		}
+	}
+	return pf.Policies, nil
+}
+
+// match reports whether the policy applies to ff
+func (p *policy) match(ff *inventory.FoundFile) bool {
+	if len(p.Types) > 0 && !contains(p.Types, ff.Type) {
+		return false
+	}
+	if len(p.Categories) > 0 && !contains(p.Categories, ff.Category) {
+		return false
+	}
+	if len(p.Paths) == 0 {
+		return true
+	}
+	for _, r := range p.rules {
+		if r.negate {
+			continue
+		}
+		if r.match(ff.Path, false) {
+			return true
+		}
+		// Folder patterns match the files inside them
+		for dir := filepath.Dir(ff.Path); dir != r.base && isUnder(dir, r.base); dir = filepath.Dir(dir) {
+			if r.match(dir, true) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// check returns how the copies of a file, the sources it is in with their kind, fall short of the policy
+func (p *policy) check(copies map[string]string) []string {
+	var violations []string
+	if len(copies) < p.Copies {
+		violations = append(violations, fmt.Sprintf("%d of %d copies", len(copies), p.Copies))
+	}
+	for _, kind := range p.Kinds {
+		found := false
+		for _, k := range copies {
+			found = found || k == kind
+		}
+		if !found {
+			violations = append(violations, fmt.Sprintf("no copy on a %s source", kind))
+		}
+	}
+	return violations
+}
+
+// String describes what the policy needs, i.e. "3 copies, on cloud"
+func (p *policy) String() string {
+	s := fmt.Sprintf("%d copies", p.Copies)
+	if len(p.Kinds) > 0 {
+		s += ", on " + strings.Join(p.Kinds, ", ")
+	}
+	return s
+}
+
+// findPolicy returns the first of policies matching ff, or the default policy
+func findPolicy(policies []policy, ff *inventory.FoundFile) *policy {
+	for i := range policies {
+		if policies[i].match(ff) {
+			return &policies[i]
+		}
+	}
+	return &defaultPolicy
+}
+
+// policyHealth counts the files a policy applies to, and how many of them meet it
+type policyHealth struct {
+	policy  *policy
+	files   int
+	healthy int
+}
+
+// policyHealthRecord is the health of a policy in the summary of health
+type policyHealthRecord struct {
+	Name       string   `json:"name"`
+	Copies     int      `json:"copies"`
+	Kinds      []string `json:"kinds"`
+	NumFiles   int      `json:"num_files"`
+	NumHealthy int      `json:"num_healthy"`
+	Health     float64  `json:"health"`
+}
+
+func (ph *policyHealth) record() policyHealthRecord {
+	kinds := ph.policy.Kinds
+	if kinds == nil {
+		kinds = []string{}
+	}
+	return policyHealthRecord{Name: ph.policy.Name, Copies: ph.policy.Copies, Kinds: kinds, NumFiles: ph.files, NumHealthy: ph.healthy,
+		Health: percent(ph.healthy, ph.files)}
+}
+
+// sortPolicyHealth returns the health of each policy that applies to files, in the order they're listed
+func sortPolicyHealth(policies []policy, health map[string]*policyHealth) []*policyHealth {
+	var rows []*policyHealth
+	for _, ph := range health {
+		rows = append(rows, ph)
+	}
+	order := map[string]int{}
+	for i, p := range policies {
+		order[p.Name] = i
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		oi, ok := order[rows[i].policy.Name]
+		if !ok {
+			oi = len(policies)
+		}
+		oj, ok := order[rows[j].policy.Name]
+		if !ok {
+			oj = len(policies)
+		}
+		return oi < oj
+	})
+	return rows
+}
+
+func printPolicyHealth(rows []*policyHealth) {
+	fmt.Printf("%-16s    %-24s    %8s    %8s    %6s\n", "Policy", "Needs", "Files", "Healthy", "Health")
+	for _, ph := range rows {
+		fmt.Printf("%-16s    %-24s    %8d    %8d    %5.1f%%\n", ph.policy.Name, ph.policy, ph.files, ph.healthy, percent(ph.healthy, ph.files))
+	}
+}
+
+// percent returns n as a percentage of total
+func percent(n int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total) * 100
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+const testPolicyFile = `{"policies": [
+	{"name": "downloads", "paths": ["Downloads/"], "copies": 1},
+	{"name": "photos", "paths": ["Pictures/**/*.jpg"], "categories": ["family"], "copies": 3, "kinds": ["cloud"]},
+	{"name": "images", "types": ["image"], "copies": 2}
+]}`
+
+func TestFindPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), policyFileName)
+	writeFile(t, path, testPolicyFile, time.Now())
+	policies, err := readPolicyFile(path, "/home/alice", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		path     string
+		typ      string
+		category string
+		want     string
+	}{
+		{"/home/alice/Downloads/setup.exe", "exec", "", "downloads"},
+		{"/home/alice/Downloads/sub/a.jpg", "image", "family", "downloads"},
+		{"/home/alice/Pictures/2020/a.jpg", "image", "family", "photos"},
+		{"/home/alice/Pictures/2020/a.jpg", "image", "", "images"},
+		{"/home/alice/Documents/a.txt", "text", "family", "default"},
+		{"/srv/Downloads/a.txt", "text", "", "default"},
+	}
+	for _, c := range cases {
+		ff := &inventory.FoundFile{Path: c.path, Type: c.typ, Category: c.category}
+		if got := findPolicy(policies, ff); got.Name != c.want {
+			t.Errorf("findPolicy(%s, %s, %s) == %s, want %s", c.path, c.typ, c.category, got.Name, c.want)
+		}
+	}
+
+	if policies, err := readPolicyFile(filepath.Join(t.TempDir(), policyFileName), "/", false); err != nil || policies != nil {
+		t.Errorf("readPolicyFile() of a missing file == %v, %v, want no policies", policies, err)
+	}
+	writeFile(t, path, `{"policies": [{"name": "offsite", "copies": 2, "kinds": ["offsite"]}]}`, time.Now())
+	if _, err := readPolicyFile(path, "/", true); err == nil {
+		t.Error("readPolicyFile() with an unknown source kind error == nil, want an error")
+	}
+	writeFile(t, path, `{"policies": [{"name": "scratch", "copies": 0}]}`, time.Now())
+	if _, err := readPolicyFile(path, "/", true); err == nil {
+		t.Error("readPolicyFile() with 0 copies error == nil, want an error")
+	}
+}
+
+func TestCheckHealthFilesSourceRoot(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	drive, nas := filepath.Join(dir, "drive"), filepath.Join(dir, "nas")
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeFile(t, filepath.Join(drive, "Pictures", "a.jpg"), "on the nas", modified)
+	writeFile(t, filepath.Join(drive, "Pictures", "b.jpg"), "only on the drive", modified)
+	writeFile(t, filepath.Join(nas, "a.jpg"), "on the nas", modified)
+	store := inventory.NewRootedStore(inventory.NewMemoryStore())
+	for _, src := range []inventory.Source{{Name: "drive", Root: drive}, {Name: "nas", Root: nas}} {
+		src.Created = time.Now()
+		if err := store.AddSource(ctx, &src); err != nil {
+			t.Fatal(err)
+		}
+		index(t, store, src.Name, filepath.Join(dir, src.Name))
+	}
+
+	// Like health, the paths are relative to the root of the source, not the folder walked
+	root, err := sourceRoot(ctx, store, "drive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policyPath := filepath.Join(dir, policyFileName)
+	writeFile(t, policyPath, `{"policies": [{"name": "photos", "paths": ["/Pictures/"], "copies": 2}]}`, time.Now())
+	policies, err := readPolicyFile(policyPath, root, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pictures := filepath.Join(drive, "Pictures")
+	out := captureOutput(t, func() error {
+		return checkHealthFiles(ctx, store, "drive", pictures, testFilter(pictures), policies, formatJSONL)
+	})
+	records := strings.Split(strings.TrimSpace(out), "\n")
+	if len(records) != 3 || !strings.Contains(records[0], `"policy":"photos","copies":2`) ||
+		!strings.Contains(records[1], `"policy":"photos","copies":1`) {
+		t.Errorf("checkHealthFiles output:\n%s\nwant a.jpg and b.jpg checked against the photos policy", out)
+	}
+}
+
+func TestCheckHealthFilesPolicies(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	laptop, nas, cloud := filepath.Join(dir, "laptop"), filepath.Join(dir, "nas"), filepath.Join(dir, "cloud")
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeFile(t, filepath.Join(laptop, "Pictures", "a.jpg"), "on every source", modified)
+	writeFile(t, filepath.Join(laptop, "Pictures", "b.jpg"), "on the nas", modified)
+	writeFile(t, filepath.Join(laptop, "Downloads", "c.zip"), "only on the laptop", modified)
+	for _, d := range []string{nas, cloud} {
+		writeFile(t, filepath.Join(d, "a.jpg"), "on every source", modified)
+	}
+	writeFile(t, filepath.Join(nas, "b.jpg"), "on the nas", modified)
+	store := inventory.NewMemoryStore()
+	for _, src := range []inventory.Source{
+		{Name: "laptop", Kind: inventory.SourceLaptop},
+		{Name: "nas", Kind: inventory.SourceNAS},
+		{Name: "cloud", Kind: inventory.SourceCloud},
+	} {
+		src.Created = time.Now()
+		if err := store.AddSource(ctx, &src); err != nil {
+			t.Fatal(err)
+		}
+		index(t, store, src.Name, filepath.Join(dir, src.Name))
+	}
+	policyPath := filepath.Join(dir, policyFileName)
+	writeFile(t, policyPath, `{"policies": [
+		{"name": "downloads", "paths": ["Downloads/"], "copies": 1},
+		{"name": "photos", "paths": ["Pictures/"], "copies": 3, "kinds": ["cloud"]}
+	]}`, time.Now())
+	policies, err := readPolicyFile(policyPath, laptop, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := captureOutput(t, func() error {
+		return checkHealthFiles(ctx, store, "laptop", laptop, testFilter(laptop), policies, formatText)
+	})
+	for _, want := range []string{
+		"Files not meeting their policy:\n" + filepath.Join(laptop, "Pictures", "b.jpg") + "    photos: 2 of 3 copies, no copy on a cloud source\n\n",
+		"2 out of 3 files meet their policy. Health is 66.7%",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("checkHealthFiles output:\n%s\nwant %q", out, want)
+		}
+	}
+	if !strings.Contains(out, "photos  ") || !strings.Contains(out, "50.0%") || !strings.Contains(out, "100.0%") {
+		t.Errorf("checkHealthFiles output:\n%s\nwant the health of the photos and downloads policies", out)
+	}
+}
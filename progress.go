@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// indexProgress tracks the progress of the walk and the hashing workers so it can be redrawn in place.
+// The totals grow as the walk finds files.
+type indexProgress struct {
+	mu            sync.Mutex
+	start         time.Time
+	current       []string
+	walking       bool
+	scanning      string
+	numFound      int
+	numSkipped    int
+	numProcessed  int
+	sizeProcessed float32
+	sizeSkipped   float32
+	sizeTotal     float32
+	lines         int
+	// out is where the progress is drawn
+	out io.Writer
+}
+
+func newIndexProgress(out io.Writer) *indexProgress {
+	return &indexProgress{
+		start:   time.Now(),
+		walking: true,
+		out:     out,
+	}
+}
+
+// fileFound counts a file found by the walk in dir, skipped files won't be hashed
+func (p *indexProgress) fileFound(dir string, size int64, skipped bool) {
+	p.mu.Lock()
+	p.scanning = dir
+	p.numFound++
+	p.sizeTotal += float32(size)
+	if skipped {
+		p.numSkipped++
+		p.sizeSkipped += float32(size)
+	}
+	p.mu.Unlock()
+}
+
+func (p *indexProgress) walkDone() {
+	p.mu.Lock()
+	p.walking = false
+	p.mu.Unlock()
+}
+
+func (p *indexProgress) setCurrent(w int, name string) {
+	p.mu.Lock()
+	p.current[w] = name
+	p.mu.Unlock()
+}
+
+func (p *indexProgress) fileSaved(size int64) {
+	p.mu.Lock()
+	p.numProcessed++
+	p.sizeProcessed += float32(size)
+	p.mu.Unlock()
+}
+
+// display redraws the progress lines, moving the cursor back up so the next call overwrites them
+func (p *indexProgress) display() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	timeElapsed := time.Since(p.start).Seconds()
+	speed := float32(0)
+	remaining := float32(0)
+	if timeElapsed > 0 && p.numProcessed > 0 && p.sizeProcessed > 1000 {
+		speed = p.sizeProcessed / float32(timeElapsed)
+		remaining = (p.sizeTotal - p.sizeSkipped - p.sizeProcessed) / speed
+	}
+	l := fmt.Sprintf("(%1d/%1d) files hashed", p.numProcessed, p.numFound-p.numSkipped)
+	if p.walking {
+		l += ", scanning " + p.scanning
+	}
+	fmt.Fprintf(p.out, "\n%-80.80s", l)
+	for w, name := range p.current {
+		l = fmt.Sprintf("Worker %2d: %s", w+1, name)
+		fmt.Fprintf(p.out, "\n%-80.80s", l)
+	}
+	sizeDone := p.sizeSkipped + p.sizeProcessed
+	percent := float32(100)
+	if p.sizeTotal > 0 {
+		percent = sizeDone / p.sizeTotal * 100
+	}
+	unit, unitName := bestUnit(int64(p.sizeTotal))
+	fmt.Fprintf(p.out, "\nProgress  %.1f%%  %.f/%.f %-40s", percent, sizeDone/unit, p.sizeTotal/unit, unitName)
+	speedFmt := ""
+	if speed > 0 {
+		// TODO: Use a window to get a more accurate estimate
+		speedFmt = fmt.Sprintf("Speed: %.1f MB/s Remaining %.fs", speed*0.000001, remaining)
+	}
+	fmt.Fprintf(p.out, "\n%-80.80s", fmt.Sprintf("Time elapsed: %s %s", time.Since(p.start).Round(time.Second), speedFmt))
+	p.lines = len(p.current) + 3
+	fmt.Fprintf(p.out, "\u001b[1000D\u001b[%dA", p.lines)
+}
+
+// clear blanks out the lines written by display
+func (p *indexProgress) clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := 0; i < p.lines; i++ {
+		fmt.Fprintf(p.out, "\n%-80.80s", "")
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// walkCounts are the number and size of the files walked
+type walkCounts struct {
+	numFound    int
+	numSkipped  int
+	numNew      int
+	numPrevious int
+	sizeTotal   int64
+}
+
+// walkPosition is a file in the walk along with the counts of the files up to and including it
+type walkPosition struct {
+	path   string
+	counts walkCounts
+}
+
+// Outcomes of saving a file
+const (
+	savedNew      = "new"
+	savedPrevious = "previous"
+	savedError    = "error"
+)
+
+type pendingFile struct {
+	// before is the position of the file walked before it
+	before  walkPosition
+	outcome string
+}
+
+// checkpoint tracks the last file in walk order that has been saved along with every file before it,
+// so an interrupted run can resume after it. Hashed files are saved out of order, so only the files
+// still waiting to be saved, or saved after one that is waiting, are remembered.
+type checkpoint struct {
+	mu      sync.Mutex
+	seq     int
+	last    walkPosition
+	pending map[int]*pendingFile
+	// numNew and numPrevious count the saved files before the first pending file
+	numNew      int
+	numPrevious int
+}
+
+// newCheckpoint returns a checkpoint starting after the file at path, which can be empty
+func newCheckpoint(path string) *checkpoint {
+	return &checkpoint{last: walkPosition{path: path}, pending: map[int]*pendingFile{}}
+}
+
+// walked records the next file found by the walk, returning its sequence number.
+// Unless pending the file is already saved, i.e. it was skipped because it hasn't changed.
+func (c *checkpoint) walked(path string, size int64, pending bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seq := c.seq
+	c.seq++
+	if pending {
+		c.pending[seq] = &pendingFile{before: c.last}
+	}
+	c.last.path = path
+	c.last.counts.numFound++
+	c.last.counts.sizeTotal += size
+	if !pending {
+		c.last.counts.numSkipped++
+	}
+	return seq
+}
+
+// saved records the outcome of saving the file with the sequence number, i.e. savedNew
+func (c *checkpoint) saved(seq int, outcome string) {
+	c.mu.Lock()
+	if p, ok := c.pending[seq]; ok {
+		p.outcome = outcome
+	}
+	c.mu.Unlock()
+}
+
+// position returns the last file saved along with every file before it, and the counts of the files up to it
+func (c *checkpoint) position() walkPosition {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var seqs []int
+	for seq := range c.pending {
+		seqs = append(seqs, seq)
+	}
+	sort.Ints(seqs)
+	pos := c.last
+	for _, seq := range seqs {
+		p := c.pending[seq]
+		if p.outcome == "" {
+			pos = p.before
+			break
+		}
+		switch p.outcome {
+		case savedNew:
+			c.numNew++
+		case savedPrevious:
+			c.numPrevious++
+		}
+		delete(c.pending, seq)
+	}
+	pos.counts.numNew = c.numNew
+	pos.counts.numPrevious = c.numPrevious
+	return pos
+}
+
+// walkedBefore reports whether the walk visits path, and everything under it if it is a folder,
+// before the file at checkpoint. Both paths must be under the root of the walk.
+func walkedBefore(path string, isDir bool, checkpoint string) bool {
+	p := strings.Split(filepath.Clean(path), string(filepath.Separator))
+	c := strings.Split(filepath.Clean(checkpoint), string(filepath.Separator))
+	for i := 0; i < len(p) && i < len(c); i++ {
+		if p[i] == c[i] {
+			continue
+		}
+		// A folder's files are walked before its subfolders, both sorted by name
+		pIsFile := i == len(p)-1 && !isDir
+		cIsFile := i == len(c)-1
+		if pIsFile != cIsFile {
+			return pIsFile
+		}
+		return p[i] < c[i]
+	}
+	// path is either the checkpoint itself or a folder containing it
+	return len(p) == len(c) && !isDir
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+func TestWalkedBefore(t *testing.T) {
+	checkpoint := filepath.FromSlash("/root/b/c.txt")
+	cases := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"/root/z.txt", false, true},
+		{"/root/a", true, true},
+		{"/root/b", true, false},
+		{"/root/b/a.txt", false, true},
+		{"/root/b/c.txt", false, true},
+		{"/root/b/d.txt", false, false},
+		{"/root/b/a", true, false},
+		{"/root/c", true, false},
+	}
+
+	for _, c := range cases {
+		got := walkedBefore(filepath.FromSlash(c.path), c.isDir, checkpoint)
+		if got != c.want {
+			t.Errorf("walkedBefore(%q, %v, %q) == %v, want %v", c.path, c.isDir, checkpoint, got, c.want)
+		}
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	cp := newCheckpoint("")
+	a := cp.walked("a", 1, true)
+	cp.walked("b", 2, false)
+	c := cp.walked("c", 4, true)
+	if got := cp.position(); got.path != "" || got.counts != (walkCounts{}) {
+		t.Errorf("checkpoint before saving == %+v, want none", got)
+	}
+	cp.saved(c, savedNew)
+	if got := cp.position(); got.path != "" {
+		t.Errorf("checkpoint after saving c == %+v, want none as a is pending", got)
+	}
+	cp.saved(a, savedPrevious)
+	if got := cp.position(); got.path != "c" || got.counts != (walkCounts{numFound: 3, numSkipped: 1, numNew: 1, numPrevious: 1, sizeTotal: 7}) {
+		t.Errorf("checkpoint after saving a == %+v, want c after 3 files", got)
+	}
+}
+
+func TestIndexPathResume(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, name := range []string{"a.txt", "b/c.txt", "b/d.txt", "e/f.txt"} {
+		writeFile(t, filepath.Join(dir, filepath.FromSlash(name)), name, modified)
+	}
+	store := inventory.NewMemoryStore()
+	hs, _ := ParseHashers("md5")
+	opts := indexOptions{workers: 2, hashers: hs, batchSize: 2, filter: testFilter(dir)}
+
+	// An interrupted run stops before doing anything
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	var err error
+	captureOutput(t, func() error {
+		err = indexPath(cancelled, store, "laptop", dir, opts)
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "index -resume") {
+		t.Fatalf("indexPath() with a cancelled context == %v, want interrupted", err)
+	}
+	run, err := store.GetResumableIndexRun(ctx, "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run == nil || run.Status != inventory.RunInterrupted {
+		t.Fatalf("GetResumableIndexRun() == %+v, want the interrupted run", run)
+	}
+
+	run.Checkpoint = filepath.Join(dir, "b", "c.txt")
+	run.NumNew = 2
+	opts.resume = run
+	captureOutput(t, func() error {
+		return indexPath(ctx, store, "laptop", dir, opts)
+	})
+	ffs, err := store.GetFoundFilesUnderPath(ctx, "laptop", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, ff := range ffs {
+		names = append(names, ff.Name)
+	}
+	if strings.Join(names, ",") != "d.txt,f.txt" {
+		t.Errorf("resumed run indexed %v, want only the files after the checkpoint", names)
+	}
+	runs, err := store.GetIndexRuns(ctx, "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].Status != inventory.RunComplete || runs[0].NumNew != 4 || runs[0].Checkpoint != filepath.Join(dir, "e", "f.txt") {
+		t.Errorf("runs == %+v, want one complete run with 4 new files", runs)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+// indexRunRecord is an index run listed by runs
+type indexRunRecord struct {
+	ID          int64  `json:"id"`
+	Source      string `json:"source"`
+	Root        string `json:"root"`
+	Status      string `json:"status"`
+	Started     string `json:"started"`
+	Finished    string `json:"finished"`
+	NumFound    int    `json:"num_found"`
+	NumNew      int    `json:"num_new"`
+	NumPrevious int    `json:"num_previous"`
+	NumSkipped  int    `json:"num_skipped"`
+	NumErrors   int    `json:"num_errors"`
+	NumMissing  int    `json:"num_missing"`
+	SizeTotal   int64  `json:"size_total"`
+}
+
+type indexRunsSummary struct {
+	NumRuns int `json:"num_runs"`
+}
+
+// listIndexRuns prints the index runs of source, most recent first, with their counts
+func listIndexRuns(ctx context.Context, store inventory.Store, source string, format string) error {
+	runs, err := store.GetIndexRuns(ctx, source)
+	if err != nil {
+		return err
+	}
+	res := newResultWriter(format, "runs", indexRunRecord{})
+	if !res.text() {
+		for _, run := range runs {
+			err := res.write(indexRunRecord{ID: run.ID, Source: run.Source, Root: run.Root, Status: run.Status,
+				Started: formatTime(run.Started), Finished: formatTime(run.Finished), NumFound: run.NumFound, NumNew: run.NumNew,
+				NumPrevious: run.NumPrevious, NumSkipped: run.NumSkipped, NumErrors: run.NumErrors, NumMissing: run.NumMissing, SizeTotal: run.SizeTotal})
+			if err != nil {
+				return err
+			}
+		}
+		return res.close(indexRunsSummary{NumRuns: len(runs)})
+	}
+	if len(runs) == 0 {
+		fmt.Println("No index runs found")
+		return nil
+	}
+	fmt.Print("   ID    Started             Duration    Status         Found      New    Previous    Skipped    Errors    Missing    Source              Root\n")
+	for _, run := range runs {
+		duration := ""
+		if !run.Finished.IsZero() {
+			duration = run.Finished.Sub(run.Started).Round(time.Second).String()
+		}
+		fmt.Printf("%5d    %s    %8s    %-11s    %5d    %5d    %8d    %7d    %6d    %7d    %-16s    %s\n",
+			run.ID, run.Started.Format("2006-01-02 15:04"), duration, run.Status, run.NumFound, run.NumNew,
+			run.NumPrevious, run.NumSkipped, run.NumErrors, run.NumMissing, run.Source, run.Root)
+	}
+	return nil
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+// runSourcesCommand runs the subcommands managing the registered sources, i.e. fileinventory sources list
+func runSourcesCommand(ctx context.Context, args []string, defaultFormat string) error {
+	if len(args) < 1 {
+		return errors.New("expected 'sources list', 'add', 'set', 'rename', 'rm' or 'info'")
+	}
+	sourcesCmd := flag.NewFlagSet("sources "+args[0], flag.ExitOnError)
+	dbPath := sourcesCmd.String("db", "", "database path - defaults to $HOMEDIR/index.db")
+	format := addFormatFlag(sourcesCmd, defaultFormat)
+	var description, kind, root, volumeFlag *string
+	var force *bool
+	switch args[0] {
+	case "add", "set":
+		description = sourcesCmd.String("description", "", "what the source is, i.e. \"Work laptop\"")
+		kind = sourcesCmd.String("kind", "", "kind of source ("+strings.Join(inventory.SourceKinds, ", ")+")")
+		root = sourcesCmd.String("root", "", "folder paths are stored relative to, i.e. where the drive is mounted - defaults to the first folder indexed")
+		volumeFlag = sourcesCmd.String("volume", "", "filesystem UUID of the drive, or auto to detect the drive of the root or working directory")
+	case "rm":
+		force = sourcesCmd.Bool("force", false, "remove the source even if it has indexed files, deleting them")
+	}
+	sourcesCmd.Parse(args[1:])
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	res := newResultWriter(*format, "sources", sourceRecord{})
+
+	store, err := openStore(ctx, *dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	switch args[0] {
+	case "list":
+		return listSources(ctx, store, res)
+	case "add":
+		if sourcesCmd.NArg() != 1 {
+			return errors.New("please specify the source name, i.e. fileinventory sources add -kind laptop mylaptop")
+		}
+		src := &inventory.Source{Name: sourcesCmd.Arg(0), Description: *description, Kind: *kind, Root: *root, Created: time.Now()}
+		if err := checkSourceFields(src); err != nil {
+			return err
+		}
+		if err := setSourceVolume(src, *volumeFlag); err != nil {
+			return err
+		}
+		if err := store.AddSource(ctx, src); err != nil {
+			return fmt.Errorf("adding source %s: %w", src.Name, err)
+		}
+		fmt.Fprintf(res.log(), "Added source %s\n", src.Name)
+		return writeSource(res, src)
+	case "set":
+		if sourcesCmd.NArg() != 1 {
+			return errors.New("please specify the source name, i.e. fileinventory sources set -kind nas mynas")
+		}
+		src, err := getSource(ctx, store, sourcesCmd.Arg(0))
+		if err != nil {
+			return err
+		}
+		oldRoot := src.Root
+		// Only change the fields given
+		sourcesCmd.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "description":
+				src.Description = *description
+			case "kind":
+				src.Kind = *kind
+			case "root":
+				src.Root = *root
+			}
+		})
+		if err := checkSourceFields(src); err != nil {
+			return err
+		}
+		if err := setSourceVolume(src, *volumeFlag); err != nil {
+			return err
+		}
+		if err := store.UpdateSource(ctx, src); err != nil {
+			return err
+		}
+		if oldRoot == "" && src.Root != "" && src.NumFiles > 0 {
+			fmt.Fprintf(res.log(), "Rewrote the paths of the %d files of source %s relative to %s\n", src.NumFiles, src.Name, src.Root)
+		}
+		fmt.Fprintf(res.log(), "Updated source %s\n", src.Name)
+		return writeSource(res, src)
+	case "rename":
+		if sourcesCmd.NArg() != 2 {
+			return errors.New("please specify the source and its new name, i.e. fileinventory sources rename laptop oldlaptop")
+		}
+		name, newName := sourcesCmd.Arg(0), sourcesCmd.Arg(1)
+		if err := store.RenameSource(ctx, name, newName); err != nil {
+			return fmt.Errorf("renaming source %s to %s: %w", name, newName, err)
+		}
+		fmt.Fprintf(res.log(), "Renamed source %s to %s\n", name, newName)
+		src, err := getSource(ctx, store, newName)
+		if err != nil {
+			return err
+		}
+		return writeSource(res, src)
+	case "rm":
+		if sourcesCmd.NArg() != 1 {
+			return errors.New("please specify the source name, i.e. fileinventory sources rm oldlaptop")
+		}
+		src, err := getSource(ctx, store, sourcesCmd.Arg(0))
+		if err != nil {
+			return err
+		}
+		if src.NumFiles > 0 && !*force {
+			return fmt.Errorf("source %s has %d indexed files, use -force to remove them along with the source", src.Name, src.NumFiles)
+		}
+		if err := store.RemoveSource(ctx, src.Name); err != nil {
+			return err
+		}
+		fmt.Fprintf(res.log(), "Removed source %s\n", src.Name)
+		return writeSource(res, src)
+	case "info":
+		if sourcesCmd.NArg() != 1 {
+			return errors.New("please specify the source name, i.e. fileinventory sources info mylaptop")
+		}
+		src, err := getSource(ctx, store, sourcesCmd.Arg(0))
+		if err != nil {
+			return err
+		}
+		if !res.text() {
+			return writeSource(res, src)
+		}
+		showSource(src)
+		return nil
+	default:
+		return fmt.Errorf("unknown sources command %q, expected 'list', 'add', 'set', 'rename', 'rm' or 'info'", args[0])
+	}
+}
+
+// openStore opens the inventory database, translating between absolute paths and the root-relative
+// paths stored for each source
+func openStore(ctx context.Context, dbPath string) (inventory.Store, error) {
+	store, err := inventory.Init(ctx, dbPath)
+	if err != nil {
+		return nil, err
+	}
+	return inventory.NewRootedStore(store), nil
+}
+
+// checkSourceFields validates the kind of src and makes its root absolute
+func checkSourceFields(src *inventory.Source) error {
+	if src.Name == "" || strings.TrimSpace(src.Name) != src.Name {
+		return fmt.Errorf("invalid source name %q", src.Name)
+	}
+	if src.Kind != "" {
+		valid := false
+		for _, kind := range inventory.SourceKinds {
+			valid = valid || src.Kind == kind
+		}
+		if !valid {
+			return fmt.Errorf("unknown source kind %q, expected %s", src.Kind, strings.Join(inventory.SourceKinds, ", "))
+		}
+	}
+	if src.Root != "" {
+		root, err := filepath.Abs(src.Root)
+		if err != nil {
+			return err
+		}
+		src.Root = root
+	}
+	return nil
+}
+
+// setSourceVolume sets the volume of src to the UUID given by flag, detecting it if flag is "auto"
+func setSourceVolume(src *inventory.Source, flag string) error {
+	if flag != "auto" {
+		if flag != "" {
+			src.VolumeUUID, src.VolumeLabel = flag, ""
+		}
+		return nil
+	}
+	path := src.Root
+	if path == "" {
+		var err error
+		if path, err = os.Getwd(); err != nil {
+			return err
+		}
+	}
+	vol, err := detectVolume(path)
+	if err != nil {
+		return err
+	}
+	if vol == nil || vol.UUID == "" {
+		return fmt.Errorf("couldn't find the filesystem UUID of the drive %s is on", path)
+	}
+	src.VolumeUUID, src.VolumeLabel = vol.UUID, vol.Label
+	return nil
+}
+
+// getSource returns the registered source called name, or an error suggesting how to add it
+func getSource(ctx context.Context, store inventory.Store, name string) (*inventory.Source, error) {
+	src, err := store.GetSource(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if src == nil {
+		return nil, fmt.Errorf("unknown source %q, see 'sources list' or register it with 'sources add %s'", name, name)
+	}
+	return src, nil
+}
+
+// sourceRoot returns the root of the source called name, or the root of the filesystem if it has
+// none or name is empty
+func sourceRoot(ctx context.Context, store inventory.Store, name string) (string, error) {
+	if name == "" {
+		return string(filepath.Separator), nil
+	}
+	src, err := getSource(ctx, store, name)
+	if err != nil {
+		return "", err
+	}
+	if src.Root == "" {
+		return string(filepath.Separator), nil
+	}
+	return src.Root, nil
+}
+
+// checkSource returns an error if name is set and isn't a registered source
+func checkSource(ctx context.Context, store inventory.Store, name string) error {
+	if name == "" {
+		return nil
+	}
+	_, err := getSource(ctx, store, name)
+	return err
+}
+
+// resolveSource checks the source called name is registered. Without a name, it returns the source
+// whose root contains path, the deepest if there are several, or an empty name if there are none.
+func resolveSource(ctx context.Context, store inventory.Store, name string, path string) (string, error) {
+	if name != "" {
+		return name, checkSource(ctx, store, name)
+	}
+	srcs, err := store.GetSources(ctx)
+	if err != nil {
+		return "", err
+	}
+	root := ""
+	for _, src := range srcs {
+		if src.Root != "" && isUnder(path, src.Root) && len(src.Root) > len(root) {
+			name, root = src.Name, src.Root
+		}
+	}
+	return name, nil
+}
+
+// isUnder reports whether path is root or inside it
+func isUnder(path string, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// sourceRecord is a source listed by sources list, or shown by sources info, add and set
+type sourceRecord struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Kind        string `json:"kind"`
+	Root        string `json:"root"`
+	VolumeUUID  string `json:"volume_uuid"`
+	VolumeLabel string `json:"volume_label"`
+	Created     string `json:"created"`
+	LastIndexed string `json:"last_indexed"`
+	NumFiles    int    `json:"num_files"`
+	SizeTotal   int64  `json:"size_total"`
+}
+
+func newSourceRecord(src *inventory.Source) sourceRecord {
+	return sourceRecord{Name: src.Name, Description: src.Description, Kind: src.Kind, Root: src.Root, VolumeUUID: src.VolumeUUID,
+		VolumeLabel: src.VolumeLabel, Created: formatTime(src.Created), LastIndexed: formatTime(src.LastIndexed),
+		NumFiles: src.NumFiles, SizeTotal: src.SizeTotal}
+}
+
+// writeSource writes src as the only record, for the machine readable formats
+func writeSource(res *resultWriter, src *inventory.Source) error {
+	if res.text() {
+		return nil
+	}
+	if err := res.write(newSourceRecord(src)); err != nil {
+		return err
+	}
+	return res.close(nil)
+}
+
+func listSources(ctx context.Context, store inventory.Store, res *resultWriter) error {
+	srcs, err := store.GetSources(ctx)
+	if err != nil {
+		return err
+	}
+	if !res.text() {
+		for i := range srcs {
+			if err := res.write(newSourceRecord(&srcs[i])); err != nil {
+				return err
+			}
+		}
+		return res.close(nil)
+	}
+	if len(srcs) == 0 {
+		fmt.Println("No sources registered, add one with 'sources add'")
+		return nil
+	}
+	const row = "%-16s    %-8s    %7v    %12s    %-16s    %s\n"
+	fmt.Printf(row, "Name", "Kind", "Files", "Size", "Last indexed", "Description")
+	for _, src := range srcs {
+		fmt.Printf(row, src.Name, src.Kind, src.NumFiles, formatSize(src.SizeTotal),
+			formatLastIndexed(src.LastIndexed), src.Description)
+	}
+	return nil
+}
+
+func showSource(src *inventory.Source) {
+	fmt.Printf("Name:          %s\n", src.Name)
+	fmt.Printf("Description:   %s\n", src.Description)
+	fmt.Printf("Kind:          %s\n", src.Kind)
+	fmt.Printf("Root:          %s\n", src.Root)
+	if src.VolumeUUID != "" {
+		vol := &volume{UUID: src.VolumeUUID, Label: src.VolumeLabel}
+		fmt.Printf("Drive:         %s\n", vol)
+	}
+	fmt.Printf("Created:       %s\n", src.Created.Format("2006-01-02 15:04"))
+	fmt.Printf("Last indexed:  %s\n", formatLastIndexed(src.LastIndexed))
+	fmt.Printf("Files:         %d\n", src.NumFiles)
+	fmt.Printf("Size:          %s\n", formatSize(src.SizeTotal))
+	if src.Root != "" {
+		if _, err := os.Stat(src.Root); err != nil {
+			fmt.Println("\nThe root isn't available on this machine")
+		}
+	}
+}
+
+func formatLastIndexed(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format("2006-01-02 15:04")
+}
+
+func formatSize(size int64) string {
+	unit, unitName := bestUnit(size)
+	return fmt.Sprintf("%.f %s", float32(size)/unit, unitName)
+}
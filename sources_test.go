@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+func TestResolveSource(t *testing.T) {
+	ctx := context.Background()
+	store := inventory.NewMemoryStore()
+	for _, src := range []inventory.Source{
+		{Name: "laptop", Root: "/home/me"},
+		{Name: "photos", Root: "/home/me/photos"},
+		{Name: "nas"},
+	} {
+		src.Created = time.Now()
+		if err := store.AddSource(ctx, &src); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"nas", "/home/me", "nas", false},
+		{"nsa", "/home/me", "", true},
+		{"", "/home/me", "laptop", false},
+		{"", "/home/me/docs", "laptop", false},
+		{"", "/home/me/photos/2020", "photos", false},
+		{"", "/home/mellon", "", false},
+		{"", "/srv", "", false},
+	}
+	for _, c := range cases {
+		got, err := resolveSource(ctx, store, c.name, c.path)
+		if (err != nil) != c.wantErr || (err == nil && got != c.want) {
+			t.Errorf("resolveSource(%q, %q) == %q, %v, want %q", c.name, c.path, got, err, c.want)
+		}
+	}
+}
+
+func TestRunSourcesCommandFormats(t *testing.T) {
+	ctx := context.Background()
+	db := filepath.Join(t.TempDir(), "index.db")
+	for _, c := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"add", "-db", db, "-kind", "laptop", "laptop"}, `"name":"laptop","description":"","kind":"laptop"`},
+		{[]string{"rename", "-db", db, "laptop", "oldlaptop"}, `"name":"oldlaptop","description":"","kind":"laptop"`},
+		{[]string{"rm", "-db", db, "oldlaptop"}, `"name":"oldlaptop"`},
+	} {
+		out := captureOutput(t, func() error {
+			return runSourcesCommand(ctx, c.args, formatJSONL)
+		})
+		if !strings.Contains(out, c.want) {
+			t.Errorf("sources %s output:\n%s\nwant %s", strings.Join(c.args, " "), out, c.want)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+// tagRecord is a tag listed by tag ls
+type tagRecord struct {
+	Tag      string `json:"tag"`
+	NumFiles int    `json:"num_files"`
+}
+
+// fileTagsRecord is a file with its tags, listed by tag ls or changed by tag add and rm
+type fileTagsRecord struct {
+	Source string   `json:"source"`
+	Path   string   `json:"path"`
+	Tags   []string `json:"tags"`
+}
+
+type tagSummary struct {
+	NumFiles int `json:"num_files"`
+}
+
+// runTagCommand runs the subcommands tagging indexed files, i.e. fileinventory tag add vacation Pictures/2019
+func runTagCommand(ctx context.Context, args []string, defaultFormat string) error {
+	if len(args) < 1 {
+		return errors.New("expected 'tag add', 'rm' or 'ls'")
+	}
+	tagCmd := flag.NewFlagSet("tag "+args[0], flag.ExitOnError)
+	source := tagCmd.String("source", "", "")
+	dbPath := tagCmd.String("db", "", "database path - defaults to $HOMEDIR/index.db")
+	whereQuery := tagCmd.String("where", "", "the indexed files matching this query, instead of or within the paths given, i.e. 'type:image modified:2019-07'")
+	format := addFormatFlag(tagCmd, defaultFormat)
+	tagCmd.Parse(args[1:])
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+
+	where, err := parseWhere(*whereQuery)
+	if err != nil {
+		return err
+	}
+	paths := tagCmd.Args()
+	var tags []string
+	switch args[0] {
+	case "add", "rm":
+		if len(paths) > 0 {
+			tags, paths = inventory.SplitTags(paths[0]), paths[1:]
+		}
+		if tags == nil {
+			return fmt.Errorf("please specify the tags, i.e. fileinventory tag %s vacation,beach Pictures/2019", args[0])
+		}
+		if len(paths) == 0 && where == nil {
+			return fmt.Errorf("please specify the files, as paths or with -where, i.e. fileinventory tag %s -where 'type:image modified:2019-07' vacation", args[0])
+		}
+	case "ls":
+	default:
+		return fmt.Errorf("unknown tag command %q, expected 'add', 'rm' or 'ls'", args[0])
+	}
+
+	store, err := openStore(ctx, *dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if len(paths) == 0 && where == nil {
+		if err := checkSource(ctx, store, *source); err != nil {
+			return err
+		}
+		return listTags(ctx, store, *source, *format)
+	}
+	ffs, err := tagFiles(ctx, store, *source, paths, where)
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "add":
+		err = store.AddTags(ctx, ffs, tags)
+	case "rm":
+		err = store.RemoveTags(ctx, ffs, tags)
+	}
+	if err != nil {
+		return err
+	}
+	return listFileTags(ffs, args[0], tags, *format)
+}
+
+// tagFiles returns the current indexed files at or below paths, or in any source when no paths are
+// given, that match where if it is set
+func tagFiles(ctx context.Context, store inventory.Store, source string, paths []string, where *inventory.Query) ([]*inventory.FoundFile, error) {
+	var found []inventory.FoundFile
+	if len(paths) == 0 {
+		if err := checkSource(ctx, store, source); err != nil {
+			return nil, err
+		}
+		ffs, err := store.FindFoundFiles(ctx, inventory.FileQuery{Source: source, Where: where})
+		if err != nil {
+			return nil, err
+		}
+		found = ffs
+	}
+	for _, p := range paths {
+		path, err := filepath.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+		src, err := resolveSource(ctx, store, source, path)
+		if err != nil {
+			return nil, err
+		}
+		if src == "" {
+			return nil, errSourceRequired
+		}
+		ffs, err := store.GetFoundFilesUnderPath(ctx, src, path)
+		if err != nil {
+			return nil, err
+		}
+		if len(ffs) == 0 {
+			return nil, fmt.Errorf("no indexed files in %s at %s", src, path)
+		}
+		if where != nil {
+			matched, err := whereFiles(ctx, store, inventory.FileQuery{Source: src, Where: where})
+			if err != nil {
+				return nil, err
+			}
+			var kept []inventory.FoundFile
+			for _, ff := range ffs {
+				if matched[ff.Path] {
+					kept = append(kept, ff)
+				}
+			}
+			ffs = kept
+		}
+		found = append(found, ffs...)
+	}
+	files := make([]*inventory.FoundFile, len(found))
+	for i := range found {
+		files[i] = &found[i]
+	}
+	return files, nil
+}
+
+// listTags prints every tag with the number of files that have it
+func listTags(ctx context.Context, store inventory.Store, source string, format string) error {
+	tags, err := store.GetTags(ctx, source)
+	if err != nil {
+		return err
+	}
+	res := newResultWriter(format, "tags", tagRecord{})
+	if !res.text() {
+		for _, t := range tags {
+			if err := res.write(tagRecord{Tag: t.Name, NumFiles: t.NumFiles}); err != nil {
+				return err
+			}
+		}
+		return res.close(nil)
+	}
+	if len(tags) == 0 {
+		fmt.Println("No tagged files found")
+		return nil
+	}
+	fmt.Printf("%-24s    %8s\n", "Tag", "Files")
+	for _, t := range tags {
+		fmt.Printf("%-24s    %8d\n", t.Name, t.NumFiles)
+	}
+	return nil
+}
+
+// listFileTags prints the tags of ffs, after cmd added or removed tags from them
+func listFileTags(ffs []*inventory.FoundFile, cmd string, tags []string, format string) error {
+	res := newResultWriter(format, "files", fileTagsRecord{})
+	if !res.text() {
+		for _, ff := range ffs {
+			tags := ff.Tags
+			if tags == nil {
+				tags = []string{}
+			}
+			if err := res.write(fileTagsRecord{Source: ff.Source, Path: ff.Path, Tags: tags}); err != nil {
+				return err
+			}
+		}
+		return res.close(tagSummary{NumFiles: len(ffs)})
+	}
+	switch cmd {
+	case "add":
+		fmt.Printf("Added %s to %d files\n", strings.Join(tags, ", "), len(ffs))
+		return nil
+	case "rm":
+		fmt.Printf("Removed %s from %d files\n", strings.Join(tags, ", "), len(ffs))
+		return nil
+	}
+	if len(ffs) == 0 {
+		fmt.Println("No matching files found")
+		return nil
+	}
+	for _, ff := range ffs {
+		fmt.Printf("%-16s    %s    %s\n", ff.Source, ff.Path, strings.Join(ff.Tags, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+func TestTagFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeFile(t, filepath.Join(dir, "2019", "a.jpg"), "a", modified)
+	writeFile(t, filepath.Join(dir, "2019", "b.txt"), "b", modified)
+	writeFile(t, filepath.Join(dir, "2020", "c.jpg"), "c", modified)
+	store := inventory.NewMemoryStore()
+	if err := store.AddSource(ctx, &inventory.Source{Name: "laptop", Root: dir, Created: modified}); err != nil {
+		t.Fatal(err)
+	}
+	hs, _ := ParseHashers("md5")
+	// Reindexing a folder with other tags, or none, keeps the tags of its files
+	for _, tags := range []string{"vacation", "sorted", ""} {
+		opts := indexOptions{workers: 2, hashers: hs, batchSize: 2, filter: testFilter(dir), tags: inventory.SplitTags(tags), reindexDiscovered: true}
+		path := dir
+		if tags == "vacation" {
+			path = filepath.Join(dir, "2019")
+		}
+		captureOutput(t, func() error {
+			return indexPath(ctx, store, "laptop", path, opts)
+		})
+	}
+
+	where, err := parseWhere("ext:jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ffs, err := tagFiles(ctx, store, "", []string{filepath.Join(dir, "2019")}, where)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ffs) != 1 || ffs[0].Name != "a.jpg" {
+		t.Fatalf("tagFiles(2019, ext:jpg) == %+v, want a.jpg", ffs)
+	}
+	if err := store.AddTags(ctx, ffs, []string{"beach"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tagFiles(ctx, store, "", []string{filepath.Join(dir, "2021")}, nil); err == nil {
+		t.Error("tagFiles(2021) succeeded, want an error as nothing is indexed there")
+	}
+
+	out := captureOutput(t, func() error {
+		return listTags(ctx, store, "laptop", formatText)
+	})
+	for _, want := range []string{"beach                              1", "sorted                             3", "vacation                           2"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("tag ls output:\n%s\nwant %q", out, want)
+		}
+	}
+
+	ffs, err = tagFiles(ctx, store, "laptop", nil, where)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RemoveTags(ctx, ffs, []string{"sorted"}); err != nil {
+		t.Fatal(err)
+	}
+	out = captureOutput(t, func() error {
+		return listFileTags(ffs, "ls", nil, formatCSV)
+	})
+	want := "source,path,tags\nlaptop," + filepath.Join(dir, "2019", "a.jpg") + ",beach;vacation\nlaptop," + filepath.Join(dir, "2020", "c.jpg") + ",\n"
+	if out != want {
+		t.Errorf("tag ls csv output:\n%s\nwant:\n%s", out, want)
+	}
+}
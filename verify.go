@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+// corruptFileRecord is a file verify found with different contents than when it was indexed
+type corruptFileRecord struct {
+	Source        string `json:"source"`
+	Path          string `json:"path"`
+	HashAlgorithm string `json:"hash_algorithm"`
+	Expected      string `json:"expected"`
+	Actual        string `json:"actual"`
+}
+
+type verifySummary struct {
+	NumVerified   int `json:"num_verified"`
+	NumCorrupt    int `json:"num_corrupt"`
+	NumNotIndexed int `json:"num_not_indexed"`
+	// NumUnknown counts files hashed with an algorithm this version doesn't support
+	NumUnknown int `json:"num_unknown"`
+	NumErrors  int `json:"num_errors"`
+}
+
+// verifyPath rehashes indexed files whose size and modified time are unchanged and compares them
+// to the stored hash. A different hash means the contents changed without the filesystem noticing.
+// Returns errCorruptFiles if any corrupt files are found.
+func verifyPath(ctx context.Context, store inventory.Store, source string, path string, workers int, filter *walkFilter, format string) error {
+	walkCtx, cancelWalk := context.WithCancel(ctx)
+	defer cancelWalk()
+	res := newResultWriter(format, "corrupt", corruptFileRecord{})
+	fmt.Fprintln(res.log(), "\nVerifying checksums...")
+	progress := newIndexProgress(res.log())
+	errs := newErrorLog(nil, nil)
+	nNotIndexed := 0
+	var unknown []inventory.FoundFile
+	jobs, walkErr := walkJobs(walkCtx, path, "", source, filter, progress, errs, func(ff inventory.FoundFile) (*hashJob, error) {
+		previousFF, err := store.GetFoundFileWithSizeAndModified(ctx, source, ff.Path, ff.Size, ff.Modified)
+		if err != nil {
+			return nil, err
+		}
+		if previousFF == nil {
+			nNotIndexed++
+			return nil, nil
+		}
+		h, ok := hashers[previousFF.HashAlgorithm]
+		if !ok {
+			unknown = append(unknown, *previousFF)
+			return nil, nil
+		}
+		return &hashJob{ff: ff, hs: []Hasher{h}, previous: previousFF}, nil
+	})
+	var corrupt []*inventory.FoundFile
+	var actual []string
+	nUnreadable := 0
+	err := hashFiles(ctx, jobs, workers, progress, func(r hashResult) error {
+		if r.err != nil {
+			errs.add(ctx, source, r.ff.Path, "hash", r.err)
+			nUnreadable++
+			return nil
+		}
+		previousFF := r.previous
+		hash := r.hashes[previousFF.HashAlgorithm]
+		status := inventory.VerifyOK
+		if hash != previousFF.Hash {
+			status = inventory.VerifyCorrupt
+			corrupt = append(corrupt, previousFF)
+			actual = append(actual, hash)
+		}
+		return store.SaveVerified(ctx, previousFF, status, time.Now())
+	})
+	cancelWalk()
+	if werr := <-walkErr; err == nil {
+		err = werr
+	}
+	if err != nil {
+		return err
+	}
+
+	nVerified := progress.numProcessed - nUnreadable
+	if !res.text() {
+		errs.printSummary(res.log())
+		for i, ff := range corrupt {
+			if err := res.write(corruptFileRecord{Source: ff.Source, Path: ff.Path, HashAlgorithm: ff.HashAlgorithm, Expected: ff.Hash, Actual: actual[i]}); err != nil {
+				return err
+			}
+		}
+		summary := verifySummary{NumVerified: nVerified, NumCorrupt: len(corrupt), NumNotIndexed: nNotIndexed, NumUnknown: len(unknown), NumErrors: errs.count()}
+		if err := res.close(summary); err != nil {
+			return err
+		}
+		if len(corrupt) > 0 {
+			return errCorruptFiles
+		}
+		return nil
+	}
+
+	fmt.Println()
+	for _, ff := range unknown {
+		fmt.Printf("Skipped %s, unknown hash algorithm %q\n", ff.Path, ff.HashAlgorithm)
+	}
+	defer errs.printSummary(os.Stdout)
+	if progress.numFound == 0 {
+		fmt.Println("No files found")
+		return nil
+	}
+	if nVerified == 0 {
+		fmt.Println("No indexed files found")
+		return nil
+	}
+	l := fmt.Sprintf("Verified %d files", nVerified)
+	fmt.Printf("%-80.80s\n", l)
+	if nNotIndexed > 0 {
+		fmt.Println(nNotIndexed, "files are not indexed or have changed since they were indexed")
+	}
+	if len(corrupt) == 0 {
+		fmt.Println("No corrupt files found")
+		return nil
+	}
+	fmt.Printf("\n%d files have different contents than when they were indexed:\n", len(corrupt))
+	for i, ff := range corrupt {
+		fmt.Println(ff.Path)
+		fmt.Printf("    expected %s %s\n", ff.HashAlgorithm, ff.Hash)
+		fmt.Printf("    actual   %s %s\n", ff.HashAlgorithm, actual[i])
+	}
+	return errCorruptFiles
+}
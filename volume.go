@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+// volume is the filesystem a folder is on
+type volume struct {
+	UUID       string
+	Label      string
+	MountPoint string
+	Device     string
+}
+
+func (v *volume) String() string {
+	if v.Label != "" {
+		return fmt.Sprintf("%s (%s)", v.UUID, v.Label)
+	}
+	return v.UUID
+}
+
+// mountInfo is a mount listed in /proc/self/mountinfo
+type mountInfo struct {
+	major      uint32
+	minor      uint32
+	mountPoint string
+	fsType     string
+	source     string
+}
+
+// parseMountInfo parses the format of /proc/self/mountinfo, see proc(5)
+func parseMountInfo(r io.Reader) ([]mountInfo, error) {
+	var mounts []mountInfo
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// The optional fields end with a "-", followed by the filesystem type and source
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if len(fields) < 5 || sep < 0 || sep+2 >= len(fields) {
+			return nil, fmt.Errorf("unexpected mountinfo line %q", scanner.Text())
+		}
+		var m mountInfo
+		dev := strings.SplitN(fields[2], ":", 2)
+		if len(dev) != 2 {
+			return nil, fmt.Errorf("unexpected device %q in mountinfo", fields[2])
+		}
+		major, err := strconv.ParseUint(dev[0], 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		minor, err := strconv.ParseUint(dev[1], 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		m.major, m.minor = uint32(major), uint32(minor)
+		m.mountPoint = unescapeMountInfo(fields[4])
+		m.fsType = fields[sep+1]
+		m.source = unescapeMountInfo(fields[sep+2])
+		mounts = append(mounts, m)
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeMountInfo decodes the octal escapes, i.e. \040 for a space, used in mountinfo
+func unescapeMountInfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// unescapeUdev decodes the hex escapes, i.e. \x20 for a space, udev uses in /dev/disk link names
+func unescapeUdev(s string) string {
+	if !strings.Contains(s, `\x`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.HasPrefix(s[i:], `\x`) && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// findMount returns the mount containing path, the deepest if there are several. Of mounts over the
+// same mount point, the last one hides the others.
+func findMount(mounts []mountInfo, path string) *mountInfo {
+	var found *mountInfo
+	for i := range mounts {
+		m := &mounts[i]
+		if isUnder(path, m.mountPoint) && (found == nil || len(m.mountPoint) >= len(found.mountPoint)) {
+			found = m
+		}
+	}
+	return found
+}
+
+// diskLink returns the name of the link in dir, i.e. /dev/disk/by-uuid, to the device of m, or an
+// empty string if there is none
+func diskLink(dir string, m *mountInfo) (string, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	source, err := filepath.EvalSymlinks(m.source)
+	if err != nil {
+		source = m.source
+	}
+	for _, fi := range fis {
+		link := filepath.Join(dir, fi.Name())
+		target, err := filepath.EvalSymlinks(link)
+		if err != nil {
+			continue
+		}
+		// Filesystems like btrfs report an anonymous device number, so match the device path too
+		if major, minor, ok := deviceOf(target); (ok && major == m.major && minor == m.minor) || target == source {
+			return unescapeUdev(fi.Name()), nil
+		}
+	}
+	return "", nil
+}
+
+// volumeOf returns the volume of the mount containing path, listed in mountinfo, with the UUID and
+// label of its device found in the by-uuid and by-label folders. Returns nil if path isn't on a mount.
+func volumeOf(mountinfo io.Reader, byUUID string, byLabel string, path string) (*volume, error) {
+	mounts, err := parseMountInfo(mountinfo)
+	if err != nil {
+		return nil, err
+	}
+	m := findMount(mounts, path)
+	if m == nil {
+		return nil, nil
+	}
+	v := &volume{MountPoint: m.mountPoint, Device: m.source}
+	if v.UUID, err = diskLink(byUUID, m); err != nil {
+		return nil, err
+	}
+	if v.Label, err = diskLink(byLabel, m); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// volumeSource returns the source registered to vol, or nil
+func volumeSource(ctx context.Context, store inventory.Store, vol *volume) (*inventory.Source, error) {
+	if vol == nil || vol.UUID == "" {
+		return nil, nil
+	}
+	srcs, err := store.GetSources(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range srcs {
+		if srcs[i].VolumeUUID == vol.UUID {
+			return &srcs[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// resolveVolumeSource is resolveSource for a folder on vol. Without a name, the source registered to
+// vol is preferred over one whose root contains path. When the name is given but the volume belongs to
+// another source, or the source to another volume, it warns that the wrong source may have been given.
+func resolveVolumeSource(ctx context.Context, store inventory.Store, name string, path string, vol *volume) (string, error) {
+	owner, err := volumeSource(ctx, store, vol)
+	if err != nil {
+		return "", err
+	}
+	if name == "" && owner != nil {
+		fmt.Fprintf(os.Stderr, "Using source %s, the drive %s is registered to it\n", owner.Name, vol)
+		return owner.Name, nil
+	}
+	name, err = resolveSource(ctx, store, name, path)
+	if err != nil || name == "" || vol == nil || vol.UUID == "" {
+		return name, err
+	}
+	src, err := getSource(ctx, store, name)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case owner != nil && owner.Name != name:
+		fmt.Fprintf(os.Stderr, "\nWARNING: %s is on drive %s, which is registered to source %s, not %s\n\n", path, vol, owner.Name, name)
+	case owner == nil && src.VolumeUUID != "":
+		fmt.Fprintf(os.Stderr, "\nWARNING: source %s is drive %s, but %s is on drive %s\n\n", name, src.VolumeUUID, path, vol)
+	}
+	return name, nil
+}
+
+// registerVolume sets up a source on its first index. An external source without a volume is
+// registered to vol, unless another source is, and a source without a root or any files is rooted
+// at path, or at the mount point of its drive. What it changes is noted on stderr, apart from the results.
+func registerVolume(ctx context.Context, store inventory.Store, name string, path string, vol *volume) error {
+	src, err := getSource(ctx, store, name)
+	if err != nil {
+		return err
+	}
+	owner, err := volumeSource(ctx, store, vol)
+	if err != nil {
+		return err
+	}
+	changed := false
+	if src.Kind == inventory.SourceExternal && src.VolumeUUID == "" && vol != nil && vol.UUID != "" && owner == nil {
+		src.VolumeUUID, src.VolumeLabel = vol.UUID, vol.Label
+		fmt.Fprintf(os.Stderr, "Registered drive %s to source %s\n", vol, name)
+		changed = true
+	}
+	onVolume := vol != nil && vol.UUID != "" && src.VolumeUUID == vol.UUID
+	if src.Root == "" && src.NumFiles == 0 {
+		src.Root = path
+		if onVolume {
+			src.Root = vol.MountPoint
+		}
+		fmt.Fprintf(os.Stderr, "Paths of source %s are stored relative to %s, change it with 'sources set -root' if the source is mounted elsewhere\n", name, src.Root)
+		changed = true
+	} else if onVolume && !isUnder(src.Root, vol.MountPoint) {
+		fmt.Fprintf(os.Stderr, "\nWARNING: the drive of source %s is mounted at %s, but its root is %s. If it was mounted there before, run 'sources set -root %s %s'\n\n",
+			name, vol.MountPoint, src.Root, vol.MountPoint, name)
+	}
+	if !changed {
+		return nil
+	}
+	return store.UpdateSource(ctx, src)
+}
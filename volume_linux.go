@@ -0,0 +1,36 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// detectVolume returns the volume containing path, identified by the UUID and label udev links to
+// its device. Returns nil if the mount can't be found.
+func detectVolume(path string) (*volume, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return volumeOf(f, "/dev/disk/by-uuid", "/dev/disk/by-label", path)
+}
+
+// deviceOf returns the major and minor numbers of the device file at path
+func deviceOf(path string) (uint32, uint32, bool) {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeDevice == 0 {
+		return 0, 0, false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	dev := uint64(st.Rdev)
+	major := uint32((dev>>8)&0xfff) | uint32((dev>>32)&^0xfff)
+	minor := uint32(dev&0xff) | uint32((dev>>12)&^0xff)
+	return major, minor, true
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// detectVolume is only supported on Linux, elsewhere sources are matched by their root
+func detectVolume(path string) (*volume, error) {
+	return nil, nil
+}
+
+func deviceOf(path string) (uint32, uint32, bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+const testMountInfo = `22 1 8:2 / / rw,relatime shared:1 - ext4 /dev/sda2 rw
+23 22 0:21 / /proc rw,nosuid,nodev,noexec,relatime shared:12 - proc proc rw
+41 22 8:17 / /media/alice/My\040Backup rw,nosuid,nodev,relatime shared:280 - exfat /dev/sdb1 rw
+42 41 8:33 / /media/alice/My\040Backup/nested rw,relatime - vfat /dev/sdc1 rw
+`
+
+func TestParseMountInfo(t *testing.T) {
+	mounts, err := parseMountInfo(strings.NewReader(testMountInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mounts) != 4 {
+		t.Fatalf("parseMountInfo() returned %d mounts, want 4", len(mounts))
+	}
+	m := mounts[2]
+	if m.major != 8 || m.minor != 17 || m.mountPoint != "/media/alice/My Backup" || m.fsType != "exfat" || m.source != "/dev/sdb1" {
+		t.Errorf("parseMountInfo() mount 3 == %+v, want exfat /dev/sdb1 8:17 at /media/alice/My Backup", m)
+	}
+	if _, err := parseMountInfo(strings.NewReader("22 1 8:2 / / rw\n")); err == nil {
+		t.Error("parseMountInfo() of a truncated line error == nil, want an error")
+	}
+}
+
+func TestFindMount(t *testing.T) {
+	mounts, err := parseMountInfo(strings.NewReader(testMountInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		path string
+		want string
+	}{
+		{"/home/alice", "/"},
+		{"/media/alice/My Backup", "/media/alice/My Backup"},
+		{"/media/alice/My Backup/photos", "/media/alice/My Backup"},
+		{"/media/alice/My Backup/nested/a", "/media/alice/My Backup/nested"},
+		{"/media/alice/My Backupx", "/"},
+	}
+	for _, c := range cases {
+		m := findMount(mounts, c.path)
+		if m == nil || m.mountPoint != c.want {
+			t.Errorf("findMount(%q) == %+v, want %s", c.path, m, c.want)
+		}
+	}
+}
+
+func TestVolumeOf(t *testing.T) {
+	dir := t.TempDir()
+	device := filepath.Join(dir, "sdb1")
+	writeFile(t, device, "", time.Now())
+	for _, link := range []string{"by-uuid/1234-ABCD", `by-label/My\x20Backup`} {
+		path := filepath.Join(dir, filepath.FromSlash(link))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(device, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mountinfo := fmt.Sprintf("41 22 8:17 / /media/backup rw - exfat %s rw\n", device)
+
+	vol, err := volumeOf(strings.NewReader(mountinfo), filepath.Join(dir, "by-uuid"), filepath.Join(dir, "by-label"), "/media/backup/photos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vol == nil || vol.UUID != "1234-ABCD" || vol.Label != "My Backup" || vol.MountPoint != "/media/backup" {
+		t.Errorf("volumeOf() == %+v, want 1234-ABCD (My Backup) at /media/backup", vol)
+	}
+	vol, err = volumeOf(strings.NewReader(mountinfo), filepath.Join(dir, "by-uuid"), filepath.Join(dir, "by-label"), "/home")
+	if err != nil || vol != nil {
+		t.Errorf("volumeOf(/home) == %+v, %v, want nil", vol, err)
+	}
+}
+
+func TestResolveVolumeSource(t *testing.T) {
+	ctx := context.Background()
+	store := inventory.NewMemoryStore()
+	for _, src := range []inventory.Source{
+		{Name: "backup", Kind: inventory.SourceExternal, Root: "/media/backup", VolumeUUID: "1234-ABCD"},
+		{Name: "laptop", Kind: inventory.SourceLaptop, Root: "/"},
+	} {
+		src.Created = time.Now()
+		if err := store.AddSource(ctx, &src); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backup := &volume{UUID: "1234-ABCD", MountPoint: "/mnt/backup"}
+	other := &volume{UUID: "5678-EF01", MountPoint: "/mnt/other"}
+
+	cases := []struct {
+		name string
+		path string
+		vol  *volume
+		want string
+	}{
+		{"", "/mnt/backup", backup, "backup"},
+		{"", "/mnt/other", other, "laptop"},
+		{"", "/home", nil, "laptop"},
+		{"laptop", "/mnt/backup", backup, "laptop"},
+	}
+	for _, c := range cases {
+		var got string
+		captureOutput(t, func() error {
+			var err error
+			got, err = resolveVolumeSource(ctx, store, c.name, c.path, c.vol)
+			return err
+		})
+		if got != c.want {
+			t.Errorf("resolveVolumeSource(%q, %q, %v) == %q, want %q", c.name, c.path, c.vol, got, c.want)
+		}
+	}
+}
+
+func TestRegisterVolume(t *testing.T) {
+	ctx := context.Background()
+	store := inventory.NewMemoryStore()
+	if err := store.AddSource(ctx, &inventory.Source{Name: "backup", Kind: inventory.SourceExternal, Created: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	vol := &volume{UUID: "1234-ABCD", Label: "Backup", MountPoint: "/media/backup"}
+	captureOutput(t, func() error {
+		return registerVolume(ctx, store, "backup", "/media/backup/photos", vol)
+	})
+	src, err := store.GetSource(ctx, "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.VolumeUUID != "1234-ABCD" || src.VolumeLabel != "Backup" || src.Root != "/media/backup" {
+		t.Errorf("GetSource(backup) == %+v, want registered to 1234-ABCD and rooted at its mount point", src)
+	}
+}
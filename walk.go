@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+// walkFunc is called for each file found by walkFiles. Like filepath.WalkFunc, err is set when a
+// file or folder couldn't be read, in which case only ff.Source and ff.Path are set and returning
+// nil carries on with the rest of the walk.
+type walkFunc func(ff inventory.FoundFile, err error) error
+
+// walkFiles calls fn for each file under path that isn't skipped by filter. A folder's files are
+// visited together, sorted by name, before its subfolders, so only the listings of the folders
+// currently being walked are held in memory. Walking stops at the first error returned by fn.
+func walkFiles(ctx context.Context, path string, source string, filter *walkFilter, fn walkFunc) error {
+	return walkFilesAfter(ctx, path, "", source, filter, fn)
+}
+
+// walkFilesAfter is walkFiles skipping every file up to and including after, which is used to resume
+// an interrupted walk. An empty after walks every file.
+func walkFilesAfter(ctx context.Context, path string, after string, source string, filter *walkFilter, fn walkFunc) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fn(newFoundFile(source, path, info), nil)
+	}
+	return walkDir(ctx, path, after, source, filter, fn)
+}
+
+func walkDir(ctx context.Context, dir string, after string, source string, filter *walkFilter, fn walkFunc) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	names, err := readDirNames(dir)
+	if err != nil {
+		// Carry on with whatever could be listed
+		if err := fn(inventory.FoundFile{Source: source, Path: dir}, err); err != nil {
+			return err
+		}
+	}
+	var subdirs []string
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		info, err := os.Lstat(path)
+		if err != nil {
+			if err := fn(inventory.FoundFile{Source: source, Path: path}, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if filter.skip(path, info.IsDir()) {
+			continue
+		}
+		if after != "" && walkedBefore(path, info.IsDir(), after) {
+			continue
+		}
+		if info.IsDir() {
+			subdirs = append(subdirs, path)
+			continue
+		}
+		if err := fn(newFoundFile(source, path, info), nil); err != nil {
+			return err
+		}
+	}
+	for _, subdir := range subdirs {
+		if err := walkDir(ctx, subdir, after, source, filter, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readDirNames returns the sorted names in dir, along with the names read before any error
+func readDirNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	sort.Strings(names)
+	return names, err
+}
+
+func newFoundFile(source string, path string, info os.FileInfo) inventory.FoundFile {
+	ff := inventory.FoundFile{Source: source, Path: path}
+	ff.Name = info.Name()
+	ff.Extension = GetNormalizedExtension(path)
+	ff.Type = GetFileType(path)
+	ff.Size = info.Size()
+	ff.Modified = info.ModTime()
+	ff.Discovered = time.Now()
+	return ff
+}
+
+// walkJobs walks path after the file after in the background, sending the hashJob returned by job for
+// each file found. Files job returns nil for are counted as skipped, and files that couldn't be read are
+// added to errs. The jobs channel is closed when the walk finishes, after which the walk's error is sent
+// on the error channel.
+func walkJobs(ctx context.Context, path string, after string, source string, filter *walkFilter, progress *indexProgress, errs *errorLog, job func(inventory.FoundFile) (*hashJob, error)) (<-chan hashJob, <-chan error) {
+	jobs := make(chan hashJob)
+	errc := make(chan error, 1)
+	go func() {
+		err := walkFilesAfter(ctx, path, after, source, filter, func(ff inventory.FoundFile, err error) error {
+			if err != nil {
+				errs.add(ctx, source, ff.Path, "walk", err)
+				return nil
+			}
+			j, err := job(ff)
+			if err != nil {
+				return err
+			}
+			progress.fileFound(filepath.Dir(ff.Path), ff.Size, j == nil)
+			if j == nil {
+				return nil
+			}
+			select {
+			case jobs <- *j:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		progress.walkDone()
+		close(jobs)
+		errc <- err
+	}()
+	return jobs, errc
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roh/fileinventory/inventory"
+)
+
+func TestWalkFilesOrder(t *testing.T) {
+	dir := t.TempDir()
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, name := range []string{"b/z.txt", "b/a.txt", "a-c.txt", "a/b/c.txt", "z.txt", "a/y.txt", "b.txt"} {
+		writeFile(t, filepath.Join(dir, filepath.FromSlash(name)), name, modified)
+	}
+	want := []string{"a-c.txt", "b.txt", "z.txt", "a/y.txt", "a/b/c.txt", "b/a.txt", "b/z.txt"}
+
+	var got []string
+	err := walkFiles(context.Background(), dir, "laptop", testFilter(dir), func(ff inventory.FoundFile, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, ff.Path)
+		got = append(got, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("walked %v, want %v", got, want)
+	}
+}
+
+func TestIndexPathRecordsUnreadableFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeFile(t, filepath.Join(dir, "a.txt"), "hello", modified)
+	writeFile(t, filepath.Join(dir, "sub", "b.txt"), "world", modified)
+	if err := os.Symlink(filepath.Join(dir, "gone.txt"), filepath.Join(dir, "dangling.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "sub"), filepath.Join(dir, "folder.txt")); err != nil {
+		t.Fatal(err)
+	}
+	store := inventory.NewMemoryStore()
+	index(t, store, "laptop", dir)
+
+	ffs, err := store.GetFoundFilesUnderPath(ctx, "laptop", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ffs) != 2 {
+		t.Errorf("indexed %d files, want 2", len(ffs))
+	}
+	es, err := store.GetIndexErrorsUnderPath(ctx, "laptop", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 2 || es[0].Kind != inventory.ErrorVanished || es[1].Kind != inventory.ErrorIO {
+		t.Errorf("errors are %+v, want dangling.txt vanished and folder.txt io error", es)
+	}
+}